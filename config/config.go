@@ -5,9 +5,72 @@ import (
 	"time"
 )
 
-type Role struct {
-	Role   string   `yaml:"role"`
+type RoleVoice struct {
+	Voice  string  `yaml:"voice"`
+	Format string  `yaml:"format"`
+	Speed  float64 `yaml:"speed"`
+}
+
+type RoleImages struct {
 	Models []string `yaml:"models"`
+	Sizes  []string `yaml:"sizes"`
+}
+
+// RoleLimits override global limits for users with the role, the largest limit of user roles is used. Zero
+// keeps the global limit.
+type RoleLimits struct {
+	MaxTemplates          int `yaml:"max_templates"`
+	MaxKnowledgeDocuments int `yaml:"max_knowledge_documents"`
+}
+
+// RoleQuotas limit usage of chat models by users with the role, the largest quota of user roles is used. Zero
+// quota is inherited or unlimited, negative quota is unlimited even if inherited roles set it.
+type RoleQuotas struct {
+	RequestsPerDay int `yaml:"requests_per_day"`
+	TokensPerMonth int `yaml:"tokens_per_month"`
+	// SpendPerMonth is in USD, see OpenAI.Prices.
+	SpendPerMonth float64 `yaml:"spend_per_month"`
+}
+
+// RoleRateLimit limits how fast users with the role can send messages to the bot, the fastest rate of user roles
+// is used. Burst is the number of messages which can be sent at once. Zero rate is inherited or unlimited,
+// negative rate is unlimited even if inherited roles set it.
+type RoleRateLimit struct {
+	MessagesPerMinute float64 `yaml:"messages_per_minute"`
+	Burst             int     `yaml:"burst"`
+}
+
+type Role struct {
+	Role      string        `yaml:"role"`
+	Models    []string      `yaml:"models"`
+	Voice     RoleVoice     `yaml:"voice"`
+	Images    RoleImages    `yaml:"images"`
+	Tools     []string      `yaml:"tools"`
+	Limits    RoleLimits    `yaml:"limits"`
+	Quotas    RoleQuotas    `yaml:"quotas"`
+	RateLimit RoleRateLimit `yaml:"rate_limit"`
+	// Inherits are roles whose models, images, tools, voice, limits, quotas and rate limits the role gets, see
+	// resolveRoles.
+	Inherits []string `yaml:"inherits"`
+}
+
+type Persona struct {
+	Name         string   `yaml:"name"`
+	Description  string   `yaml:"description"`
+	Model        string   `yaml:"model"`
+	SystemPrompt string   `yaml:"system_prompt"`
+	Temperature  *float32 `yaml:"temperature"`
+	// Tools are available in chats with the persona in addition to the default tools, instead of the role
+	// tools.
+	Tools []string `yaml:"tools"`
+	// Roles the persona is shown to, empty list shows it to everyone.
+	Roles []string `yaml:"roles"`
+}
+
+// ModelPrice is USD per million tokens.
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
 }
 
 type OpenAI struct {
@@ -15,6 +78,10 @@ type OpenAI struct {
 	OpenAIBaseURL           string        `yaml:"open_ai_base_url" env:"OPENAI_BASE_URL"`
 	ConversationIdleTimeout time.Duration `yaml:"conversation_idle_timeout_seconds"`
 	StdModel                string        `env:"OPENAI_STD_MODEL" envDefault:"gpt-3.5-turbo"`
+	MaxToolIterations       int           `yaml:"max_tool_iterations" env-default:"5"`
+	ToolCallTimeout         time.Duration `yaml:"tool_call_timeout" env-default:"30s"`
+	// Prices of chat models by names, usage of models without price costs nothing.
+	Prices map[string]ModelPrice `yaml:"prices"`
 }
 
 type Telegram struct {
@@ -24,6 +91,147 @@ type Telegram struct {
 	PremiumTelegramIDList               []int64  `env:"PREMIUM_TELEGRAM_ID_LIST" envSeparator:","`
 	IsNotPublic                         bool     `yaml:"is_not_public" `
 	AvailableForRoles                   []string `yaml:"available_for_roles" `
+	DefaultTimezone                     string   `yaml:"default_timezone" env-default:"UTC"`
+	// AccessRequestRole is granted to users whose access requests are approved, empty turns requests off.
+	AccessRequestRole string `yaml:"access_request_role"`
+	// AccessRequestCooldown is the time denied users wait before they can request access again.
+	AccessRequestCooldown time.Duration `yaml:"access_request_cooldown" env-default:"24h"`
+	// APIEndpoint replaces the Bot API server, e.g. a local server or a fake one for tests. The format is
+	// "https://api.telegram.org/bot%s/%s" with the token and the method.
+	APIEndpoint string `yaml:"api_endpoint" env:"TELEGRAM_API_ENDPOINT"`
+}
+
+type Documents struct {
+	MaxFileSize int64 `yaml:"max_file_size_bytes" env-default:"2097152"`
+	ChunkTokens int   `yaml:"chunk_tokens" env-default:"500"`
+	MaxTokens   int   `yaml:"max_tokens" env-default:"2000"`
+}
+
+type Speech struct {
+	BaseURL              string        `yaml:"base_url" env:"SPEECH_BASE_URL"`
+	APIKey               string        `env:"SPEECH_API_KEY"`
+	TranscriptionModel   string        `yaml:"transcription_model" env-default:"whisper-1"`
+	TranscriptionTimeout time.Duration `yaml:"transcription_timeout" env-default:"1m"`
+	MaxFileSize          int64         `yaml:"max_file_size_bytes" env-default:"20971520"`
+	SpeechModel          string        `yaml:"speech_model" env-default:"tts-1"`
+	SpeechTimeout        time.Duration `yaml:"speech_timeout" env-default:"1m"`
+	MaxSpeechLength      int           `yaml:"max_speech_length" env-default:"4096"`
+	// SpeechPrice is USD per million characters of synthesized speech.
+	SpeechPrice float64 `yaml:"speech_price"`
+	// TranscriptionPrice is USD per minute of transcribed audio.
+	TranscriptionPrice float64 `yaml:"transcription_price"`
+}
+
+type Images struct {
+	Timeout time.Duration `yaml:"timeout" env-default:"2m"`
+	// Prices of image models by names in USD per image, images of models without price cost nothing.
+	Prices map[string]float64 `yaml:"prices"`
+}
+
+type FetchURL struct {
+	Timeout       time.Duration `yaml:"timeout" env-default:"10s"`
+	MaxSize       int64         `yaml:"max_size_bytes" env-default:"2097152"`
+	MaxTextLength int           `yaml:"max_text_length" env-default:"12000"`
+	UserAgent     string        `yaml:"user_agent" env-default:"ai-telegram-bot"`
+	// AllowedDomains limits fetching to these domains and their subdomains, empty list allows any domain.
+	AllowedDomains       []string `yaml:"allowed_domains"`
+	DeniedDomains        []string `yaml:"denied_domains"`
+	AllowPrivateNetworks bool     `yaml:"allow_private_networks"`
+}
+
+type Tools struct {
+	// DefaultTools are allowed for every role in addition to the role tools.
+	DefaultTools []string `yaml:"default_tools"`
+	FetchURL     FetchURL `yaml:"fetch_url"`
+}
+
+type KnowledgeBase struct {
+	EmbeddingModel string `yaml:"embedding_model" env-default:"text-embedding-3-small"`
+	ChunkTokens    int    `yaml:"chunk_tokens" env-default:"300"`
+	// MaxChunks limits the number of parts stored for one document.
+	MaxChunks     int           `yaml:"max_chunks" env-default:"200"`
+	MaxDocuments  int           `yaml:"max_documents" env-default:"50"`
+	TopK          int           `yaml:"top_k" env-default:"4"`
+	MinScore      float64       `yaml:"min_score" env-default:"0.3"`
+	SearchTimeout time.Duration `yaml:"search_timeout" env-default:"10s"`
+}
+
+type PromptTemplate struct {
+	Name string `yaml:"name"`
+	Text string `yaml:"text"`
+}
+
+type Templates struct {
+	// Shared templates are available to every user, personal templates can't reuse their names.
+	Shared       []PromptTemplate `yaml:"shared"`
+	MaxTemplates int              `yaml:"max_templates" env-default:"50"`
+}
+
+type Inline struct {
+	Enabled bool `yaml:"enabled"`
+	// Model answers inline queries of all users, so a cheap one is expected.
+	Model        string `yaml:"model" env-default:"gpt-4.1-nano"`
+	SystemPrompt string `yaml:"system_prompt" env-default:"Answer briefly, in a few sentences."`
+	MaxTokens    int    `yaml:"max_tokens" env-default:"300"`
+	// MinQueryLength skips queries which are too short to be questions yet.
+	MinQueryLength int           `yaml:"min_query_length" env-default:"3"`
+	Timeout        time.Duration `yaml:"timeout" env-default:"15s"`
+	// Debounce is waited before answering, queries replaced by the user while typing aren't answered.
+	Debounce        time.Duration `yaml:"debounce" env-default:"1s"`
+	RateLimit       int           `yaml:"rate_limit" env-default:"10"`
+	RateLimitPeriod time.Duration `yaml:"rate_limit_period" env-default:"1m"`
+	CacheTTL        time.Duration `yaml:"cache_ttl" env-default:"10m"`
+}
+
+// Product is sold for Telegram Stars. It gives the role for the duration or token credits.
+type Product struct {
+	// ID is sent in callbacks and invoice payloads, so it is short.
+	ID          string        `yaml:"id"`
+	Title       string        `yaml:"title"`
+	Description string        `yaml:"description"`
+	Stars       int           `yaml:"stars"`
+	Role        string        `yaml:"role"`
+	Duration    time.Duration `yaml:"duration"`
+	Tokens      int           `yaml:"tokens"`
+}
+
+type Payments struct {
+	Enabled  bool      `yaml:"enabled"`
+	Products []Product `yaml:"products"`
+}
+
+type RateLimit struct {
+	// QueueTimeout is how long a message over the rate limit waits for its turn, messages which would wait
+	// longer are rejected. Zero rejects them at once.
+	QueueTimeout time.Duration `yaml:"queue_timeout" env-default:"5s"`
+}
+
+// RoleExpiry sets the scheduler removing roles given for a time, e.g. by invites, payments or /grant.
+type RoleExpiry struct {
+	CheckInterval time.Duration `yaml:"check_interval" env-default:"1m"`
+	// NotifyBefore is how long before the expiry users are notified, zero turns notifications off.
+	NotifyBefore time.Duration `yaml:"notify_before" env-default:"72h"`
+}
+
+// Moderation checks prompts through an OpenAI compatible /moderations endpoint before they are sent to models.
+type Moderation struct {
+	Enabled bool   `yaml:"enabled"`
+	BaseURL string `yaml:"base_url" env:"MODERATION_BASE_URL"`
+	APIKey  string `env:"MODERATION_API_KEY"`
+	Model   string `yaml:"model" env-default:"omni-moderation-latest"`
+	// Categories block prompts flagged with them, empty list blocks prompts flagged with any category.
+	Categories []string      `yaml:"categories"`
+	Timeout    time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+// Broadcast sets how announcements of admins are sent to users. Telegram lets bots send about 30 messages per
+// second, flood errors are waited out anyway.
+type Broadcast struct {
+	MessagesPerSecond float64 `yaml:"messages_per_second" env-default:"20"`
+	// ProgressInterval is how often the admin sees the progress of the broadcast.
+	ProgressInterval time.Duration `yaml:"progress_interval" env-default:"10s"`
+	// DraftTimeout is how long /broadcast waits for the message and its confirmation.
+	DraftTimeout time.Duration `yaml:"draft_timeout" env-default:"10m"`
 }
 
 type Redis struct {
@@ -31,10 +239,23 @@ type Redis struct {
 }
 
 type Config struct {
-	OpenAI   OpenAI   `yaml:"open_ai"`
-	Telegram Telegram `yaml:"telegram"`
-	Roles    []Role   `yaml:"roles"`
-	Redis    Redis    `yaml:"redis"`
+	OpenAI        OpenAI        `yaml:"open_ai"`
+	Telegram      Telegram      `yaml:"telegram"`
+	Roles         []Role        `yaml:"roles"`
+	Personas      []Persona     `yaml:"personas"`
+	Redis         Redis         `yaml:"redis"`
+	Documents     Documents     `yaml:"documents"`
+	Speech        Speech        `yaml:"speech"`
+	Images        Images        `yaml:"images"`
+	Tools         Tools         `yaml:"tools"`
+	KnowledgeBase KnowledgeBase `yaml:"knowledge_base"`
+	Templates     Templates     `yaml:"templates"`
+	Inline        Inline        `yaml:"inline"`
+	Payments      Payments      `yaml:"payments"`
+	RoleExpiry    RoleExpiry    `yaml:"role_expiry"`
+	RateLimit     RateLimit     `yaml:"rate_limit"`
+	Moderation    Moderation    `yaml:"moderation"`
+	Broadcast     Broadcast     `yaml:"broadcast"`
 }
 
 func LoadConfig(cfgPath string) (*Config, error) {
@@ -45,5 +266,22 @@ func LoadConfig(cfgPath string) (*Config, error) {
 	if err := cleanenv.ReadEnv(&cfg); err != nil {
 		return nil, err
 	}
+	roles, err := resolveRoles(cfg.Roles)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Roles = roles
+	if err = validateRoleReferences(&cfg); err != nil {
+		return nil, err
+	}
+	if err = validatePersonas(&cfg); err != nil {
+		return nil, err
+	}
+	if err = validatePayments(&cfg); err != nil {
+		return nil, err
+	}
+	if err = validateLimits(&cfg); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,17 @@
+package config
+
+import "fmt"
+
+// validateLimits checks the sizes used as divisors when documents are split into parts.
+func validateLimits(cfg *Config) error {
+	if cfg.Documents.ChunkTokens <= 0 {
+		return fmt.Errorf("documents chunk_tokens must be positive")
+	}
+	if cfg.Documents.MaxTokens <= 0 {
+		return fmt.Errorf("documents max_tokens must be positive")
+	}
+	if cfg.KnowledgeBase.ChunkTokens <= 0 {
+		return fmt.Errorf("knowledge_base chunk_tokens must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxProductIDLength keeps callback data of buy buttons under the Telegram limit of 64 bytes.
+const maxProductIDLength = 32
+
+// validatePayments checks that every product has unique short ID, price and gives either a declared role for
+// a duration or token credits.
+func validatePayments(cfg *Config) error {
+	ids := make(map[string]struct{}, len(cfg.Payments.Products))
+	for _, product := range cfg.Payments.Products {
+		if len(product.ID) == 0 || len(product.ID) > maxProductIDLength || strings.ContainsAny(product.ID, " \t\n") {
+			return fmt.Errorf(
+				"product ID %q must have 1 to %d characters without spaces", product.ID,
+				maxProductIDLength,
+			)
+		}
+		if _, ok := ids[product.ID]; ok {
+			return fmt.Errorf("product %s is declared twice", product.ID)
+		}
+		ids[product.ID] = struct{}{}
+
+		if len(product.Title) == 0 || len(product.Description) == 0 {
+			return fmt.Errorf("product %s must have title and description", product.ID)
+		}
+		if product.Stars <= 0 {
+			return fmt.Errorf("product %s must have positive price in stars", product.ID)
+		}
+		givesRole := len(product.Role) != 0 || product.Duration != 0
+		givesTokens := product.Tokens != 0
+		if givesRole == givesTokens {
+			return fmt.Errorf("product %s must give either role for a duration or tokens", product.ID)
+		}
+		if givesRole {
+			if product.Duration <= 0 {
+				return fmt.Errorf("product %s must have positive duration", product.ID)
+			}
+			isDeclared := slices.ContainsFunc(
+				cfg.Roles, func(role Role) bool {
+					return role.Role == product.Role
+				},
+			)
+			if !isDeclared {
+				return fmt.Errorf("product %s has unknown role %s", product.ID, product.Role)
+			}
+		}
+		if product.Tokens < 0 {
+			return fmt.Errorf("product %s must have positive tokens", product.ID)
+		}
+	}
+	return nil
+}
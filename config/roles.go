@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Roles given by the environment lists.
+const (
+	roleAdmin   = "admin"
+	rolePremium = "premium"
+)
+
+// maxPersonaNameLength keeps callback data of persona buttons, "persona_" followed by the name, within the
+// 64 bytes Telegram allows.
+const maxPersonaNameLength = 64 - len("persona_")
+
+// resolveRoles returns roles with everything inherited from their inherits roles. Own models, tools, images
+// and sizes go first, so they stay the defaults, own voice, limits, quotas and rate limits replace inherited ones.
+func resolveRoles(roles []Role) ([]Role, error) {
+	declared := make(map[string]Role, len(roles))
+	for _, role := range roles {
+		if len(role.Role) == 0 {
+			return nil, fmt.Errorf("role must have name")
+		}
+		if _, ok := declared[role.Role]; ok {
+			return nil, fmt.Errorf("role %s is declared twice", role.Role)
+		}
+		declared[role.Role] = role
+	}
+
+	resolved := make(map[string]Role, len(roles))
+	// resolving keeps roles on the current inheritance path to find cycles.
+	resolving := make(map[string]bool)
+	var resolve func(name string) (Role, error)
+	resolve = func(name string) (Role, error) {
+		if role, ok := resolved[name]; ok {
+			return role, nil
+		}
+		if resolving[name] {
+			return Role{}, fmt.Errorf("role %s inherits itself", name)
+		}
+		role := declared[name]
+		resolving[name] = true
+		for _, parentName := range role.Inherits {
+			if _, ok := declared[parentName]; !ok {
+				return Role{}, fmt.Errorf("role %s inherits unknown role %s", name, parentName)
+			}
+			parent, err := resolve(parentName)
+			if err != nil {
+				return Role{}, err
+			}
+			role = inheritRole(role, parent)
+		}
+		delete(resolving, name)
+		resolved[name] = role
+		return role, nil
+	}
+
+	result := make([]Role, 0, len(roles))
+	for _, role := range roles {
+		resolvedRole, err := resolve(role.Role)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolvedRole)
+	}
+	return result, nil
+}
+
+func inheritRole(role, parent Role) Role {
+	role.Models = appendMissing(role.Models, parent.Models)
+	role.Tools = appendMissing(role.Tools, parent.Tools)
+	role.Images.Models = appendMissing(role.Images.Models, parent.Images.Models)
+	role.Images.Sizes = appendMissing(role.Images.Sizes, parent.Images.Sizes)
+	if len(role.Voice.Voice) == 0 {
+		role.Voice = parent.Voice
+	}
+	if role.Limits.MaxTemplates == 0 {
+		role.Limits.MaxTemplates = parent.Limits.MaxTemplates
+	}
+	if role.Limits.MaxKnowledgeDocuments == 0 {
+		role.Limits.MaxKnowledgeDocuments = parent.Limits.MaxKnowledgeDocuments
+	}
+	if role.Quotas.RequestsPerDay == 0 {
+		role.Quotas.RequestsPerDay = parent.Quotas.RequestsPerDay
+	}
+	if role.Quotas.TokensPerMonth == 0 {
+		role.Quotas.TokensPerMonth = parent.Quotas.TokensPerMonth
+	}
+	if role.Quotas.SpendPerMonth == 0 {
+		role.Quotas.SpendPerMonth = parent.Quotas.SpendPerMonth
+	}
+	if role.RateLimit.MessagesPerMinute == 0 {
+		role.RateLimit = parent.RateLimit
+	}
+	return role
+}
+
+func appendMissing(values, inherited []string) []string {
+	result := slices.Clone(values)
+	for _, value := range inherited {
+		if !slices.Contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// validateRoleReferences checks that roles used by the telegram and personas configs and by the environment
+// role lists are declared.
+func validateRoleReferences(cfg *Config) error {
+	declared := make(map[string]struct{}, len(cfg.Roles))
+	for _, role := range cfg.Roles {
+		declared[role.Role] = struct{}{}
+	}
+	isDeclared := func(name string) bool {
+		_, ok := declared[name]
+		return ok
+	}
+
+	for _, name := range cfg.Telegram.AvailableForRoles {
+		if !isDeclared(name) {
+			return fmt.Errorf("telegram available_for_roles has unknown role %s", name)
+		}
+	}
+	if len(cfg.Telegram.AccessRequestRole) != 0 && !isDeclared(cfg.Telegram.AccessRequestRole) {
+		return fmt.Errorf("telegram access_request_role has unknown role %s", cfg.Telegram.AccessRequestRole)
+	}
+	// Approved users of a private bot must get access by the granted role.
+	if cfg.Telegram.IsNotPublic && len(cfg.Telegram.AccessRequestRole) != 0 &&
+		!slices.Contains(cfg.Telegram.AvailableForRoles, cfg.Telegram.AccessRequestRole) {
+		return fmt.Errorf(
+			"telegram access_request_role %s is not in available_for_roles of the private bot",
+			cfg.Telegram.AccessRequestRole,
+		)
+	}
+	for _, persona := range cfg.Personas {
+		for _, name := range persona.Roles {
+			if !isDeclared(name) {
+				return fmt.Errorf("persona %s has unknown role %s", persona.Name, name)
+			}
+		}
+	}
+	if len(cfg.Telegram.AdminTelegramIDList) != 0 && !isDeclared(roleAdmin) {
+		return fmt.Errorf("ADMIN_TELEGRAM_ID_LIST is set, but role %s is not declared", roleAdmin)
+	}
+	if len(cfg.Telegram.PremiumTelegramIDList) != 0 && !isDeclared(rolePremium) {
+		return fmt.Errorf("PREMIUM_TELEGRAM_ID_LIST is set, but role %s is not declared", rolePremium)
+	}
+	return nil
+}
+
+// validatePersonas checks that personas have names fitting into button callback data and models declared by
+// roles.
+func validatePersonas(cfg *Config) error {
+	declaredModels := make(map[string]struct{})
+	for _, role := range cfg.Roles {
+		for _, aiModel := range role.Models {
+			declaredModels[aiModel] = struct{}{}
+		}
+	}
+	for _, persona := range cfg.Personas {
+		if len(persona.Name) == 0 || len(persona.Model) == 0 {
+			return fmt.Errorf("persona must have name and model")
+		}
+		if len(persona.Name) > maxPersonaNameLength {
+			return fmt.Errorf("persona name %s is longer than %d bytes", persona.Name, maxPersonaNameLength)
+		}
+		if _, ok := declaredModels[persona.Model]; !ok {
+			return fmt.Errorf("persona %s has model %s, which isn't in models of any role", persona.Name, persona.Model)
+		}
+	}
+	return nil
+}
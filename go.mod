@@ -1,15 +1,17 @@
 module github.com/iamvkosarev/ai-telegram-bot
 
-go 1.24
+go 1.24.1
 
 require (
 	github.com/OvyFlash/telegram-bot-api v0.0.0-20251014214618-257761277c51
 	github.com/google/uuid v1.3.0
 	github.com/ilyakaznacheev/cleanenv v1.5.0
 	github.com/joho/godotenv v1.5.1
+	github.com/ledongthuc/pdf v0.0.0-20250511090121-5959a4027728
 	github.com/redis/go-redis/v9 v9.14.1
-	github.com/sashabaranov/go-openai v1.15.4
+	github.com/sashabaranov/go-openai v1.41.2
 	github.com/sourcegraph/conc v0.3.0
+	golang.org/x/net v0.40.0
 )
 
 require (
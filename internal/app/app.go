@@ -1,10 +1,16 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	api "github.com/OvyFlash/telegram-bot-api"
 	"github.com/iamvkosarev/ai-telegram-bot/config"
+	embedding_open_ai "github.com/iamvkosarev/ai-telegram-bot/internal/embedding/open-ai"
+	image_open_ai "github.com/iamvkosarev/ai-telegram-bot/internal/image/open-ai"
+	moderation_open_ai "github.com/iamvkosarev/ai-telegram-bot/internal/moderation/open-ai"
+	speech_open_ai "github.com/iamvkosarev/ai-telegram-bot/internal/speech/open-ai"
 	key_value "github.com/iamvkosarev/ai-telegram-bot/internal/storage/key-value"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/tool"
 	"github.com/iamvkosarev/ai-telegram-bot/internal/usecase"
 	"github.com/redis/go-redis/v9"
 	"log"
@@ -18,7 +24,33 @@ func Run(cfg *config.Config) error {
 	}
 	cfg.OpenAI.OpenAIBaseURL = baseURL
 
-	bot, err := api.NewBotAPI(cfg.Telegram.TelegramAPIToken)
+	speechBaseURL := cfg.OpenAI.OpenAIBaseURL
+	if len(cfg.Speech.BaseURL) != 0 {
+		if speechBaseURL, err = url.JoinPath(cfg.Speech.BaseURL, "/v1"); err != nil {
+			return err
+		}
+	}
+	speechAPIKey := cfg.Speech.APIKey
+	if len(speechAPIKey) == 0 {
+		speechAPIKey = cfg.OpenAI.OpenAIAPIKey
+	}
+
+	moderationBaseURL := cfg.OpenAI.OpenAIBaseURL
+	if len(cfg.Moderation.BaseURL) != 0 {
+		if moderationBaseURL, err = url.JoinPath(cfg.Moderation.BaseURL, "/v1"); err != nil {
+			return err
+		}
+	}
+	moderationAPIKey := cfg.Moderation.APIKey
+	if len(moderationAPIKey) == 0 {
+		moderationAPIKey = cfg.OpenAI.OpenAIAPIKey
+	}
+
+	apiEndpoint := api.APIEndpoint
+	if len(cfg.Telegram.APIEndpoint) != 0 {
+		apiEndpoint = cfg.Telegram.APIEndpoint
+	}
+	bot, err := api.NewBotAPIWithAPIEndpoint(cfg.Telegram.TelegramAPIToken, apiEndpoint)
 	if err != nil {
 		return fmt.Errorf("failed to create new bot: %w", err)
 	}
@@ -30,16 +62,54 @@ func Run(cfg *config.Config) error {
 		},
 	)
 
-	openAIUsecase := usecase.NewOpenAIUsecase(cfg.OpenAI)
+	tools := tool.NewRegistry()
+	if err = tools.Register(tool.Builtin()...); err != nil {
+		return fmt.Errorf("failed to register builtin tools: %w", err)
+	}
+	if err = tools.Register(tool.NewURLFetcher(cfg.Tools.FetchURL).Tool()); err != nil {
+		return fmt.Errorf("failed to register fetch url tool: %w", err)
+	}
+	for _, toolName := range cfg.Tools.DefaultTools {
+		if _, ok := tools.Get(toolName); !ok {
+			return fmt.Errorf("unknown default tool %s", toolName)
+		}
+	}
+	for _, role := range cfg.Roles {
+		for _, toolName := range role.Tools {
+			if _, ok := tools.Get(toolName); !ok {
+				return fmt.Errorf("role %s has unknown tool %s", role.Role, toolName)
+			}
+		}
+	}
+
+	for _, persona := range cfg.Personas {
+		for _, toolName := range persona.Tools {
+			if _, ok := tools.Get(toolName); !ok {
+				return fmt.Errorf("persona %s has unknown tool %s", persona.Name, toolName)
+			}
+		}
+	}
+
+	openAIUsecase := usecase.NewOpenAIUsecase(cfg.OpenAI, tools)
 
 	userStorage := key_value.NewUserStorage(rdb)
+	migrated, err := userStorage.MigrateTelegramKeys(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to migrate telegram keys: %w", err)
+	}
+	if migrated != 0 {
+		log.Printf("Migrated %d telegram users and chats", migrated)
+	}
 
 	userUsecase := usecase.NewUserUsecase(
 		usecase.UserUsecaseDeps{
 			UserStorage: userStorage,
 		},
-		cfg.Telegram,
+		cfg.Telegram, cfg.Roles,
 	)
+	if err = userUsecase.ReconcileConfiguredRoles(context.Background()); err != nil {
+		return fmt.Errorf("failed to reconcile configured roles: %w", err)
+	}
 
 	aiChatStorage := key_value.NewAIChatStorage(rdb)
 
@@ -47,15 +117,138 @@ func Run(cfg *config.Config) error {
 		usecase.AiChatUsecaseDeps{
 			AiChatStorage: aiChatStorage,
 			User:          userUsecase,
+		}, cfg.Roles, cfg.Tools, cfg.Personas,
+	)
+
+	documentUsecase := usecase.NewDocumentUsecase(
+		usecase.DocumentUsecaseDeps{
+			AIChat: aiChatUsecase,
+		}, cfg.Documents,
+	)
+
+	speechUsecase := usecase.NewSpeechUsecase(
+		usecase.SpeechUsecaseDeps{
+			Transcriber: speech_open_ai.NewTranscriber(speechAPIKey, speechBaseURL, cfg.Speech.TranscriptionModel),
+			Synthesizer: speech_open_ai.NewSynthesizer(speechAPIKey, speechBaseURL, cfg.Speech.SpeechModel),
+		}, cfg.Speech, cfg.Roles,
+	)
+
+	imageUsecase := usecase.NewImageUsecase(
+		usecase.ImageUsecaseDeps{
+			Generator: image_open_ai.NewGenerator(cfg.OpenAI.OpenAIAPIKey, cfg.OpenAI.OpenAIBaseURL),
+			AIChat:    aiChatUsecase,
+		}, cfg.Images, cfg.Roles,
+	)
+
+	knowledgeUsecase := usecase.NewKnowledgeUsecase(
+		usecase.KnowledgeUsecaseDeps{
+			Embedder: embedding_open_ai.NewEmbedder(
+				cfg.OpenAI.OpenAIAPIKey, cfg.OpenAI.OpenAIBaseURL, cfg.KnowledgeBase.EmbeddingModel,
+			),
+			KnowledgeStorage: key_value.NewKnowledgeStorage(rdb),
+		}, cfg.KnowledgeBase, cfg.Roles,
+	)
+
+	templateUsecase, err := usecase.NewTemplateUsecase(
+		usecase.TemplateUsecaseDeps{
+			TemplateStorage: key_value.NewTemplateStorage(rdb),
+		}, cfg.Templates, cfg.Roles,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create template usecase: %w", err)
+	}
+
+	topicUsecase := usecase.NewTopicUsecase(
+		usecase.TopicUsecaseDeps{
+			TopicStorage: key_value.NewTopicStorage(rdb),
+			AIChat:       aiChatUsecase,
+		},
+	)
+
+	moderationUsecase := usecase.NewModerationUsecase(
+		usecase.ModerationUsecaseDeps{
+			Moderator: moderation_open_ai.NewModerator(moderationAPIKey, moderationBaseURL, cfg.Moderation.Model),
+		}, cfg.Moderation,
+	)
+
+	quotaUsecase := usecase.NewQuotaUsecase(
+		usecase.QuotaUsecaseDeps{
+			UsageStorage: key_value.NewUsageStorage(rdb),
 		}, cfg.Roles,
 	)
 
+	inlineUsecase := usecase.NewInlineUsecase(
+		usecase.InlineUsecaseDeps{
+			OpenAI:     openAIUsecase,
+			Moderation: moderationUsecase,
+			Quota:      quotaUsecase,
+		}, cfg.Inline,
+	)
+
+	inviteUsecase := usecase.NewInviteUsecase(
+		usecase.InviteUsecaseDeps{
+			InviteStorage: key_value.NewInviteStorage(rdb),
+			User:          userUsecase,
+		},
+	)
+
+	accessRequestUsecase := usecase.NewAccessRequestUsecase(
+		usecase.AccessRequestUsecaseDeps{
+			AccessRequestStorage: key_value.NewAccessRequestStorage(rdb),
+			User:                 userUsecase,
+		}, cfg.Telegram,
+	)
+
+	paymentUsecase := usecase.NewPaymentUsecase(
+		usecase.PaymentUsecaseDeps{
+			PaymentStorage: key_value.NewPaymentStorage(rdb),
+			User:           userUsecase,
+			Quota:          quotaUsecase,
+		}, cfg.Payments,
+	)
+
+	roleExpiryUsecase := usecase.NewRoleExpiryUsecase(
+		usecase.RoleExpiryUsecaseDeps{
+			RoleExpiryStorage: userStorage,
+			User:              userUsecase,
+		}, cfg.RoleExpiry,
+	)
+
+	banUsecase := usecase.NewBanUsecase(
+		usecase.BanUsecaseDeps{
+			BanStorage: key_value.NewBanStorage(rdb),
+			User:       userUsecase,
+		},
+	)
+
 	telegramUsecase, err := usecase.NewTelegramUsecase(
 		cfg.Telegram, usecase.TelegramUsecaseDeps{
-			User:   userUsecase,
-			Bot:    bot,
-			OpenAI: openAIUsecase,
-			AIChat: aiChatUsecase,
+			User:          userUsecase,
+			Bot:           bot,
+			OpenAI:        openAIUsecase,
+			AIChat:        aiChatUsecase,
+			Document:      documentUsecase,
+			Speech:        speechUsecase,
+			Image:         imageUsecase,
+			Knowledge:     knowledgeUsecase,
+			Template:      templateUsecase,
+			Topic:         topicUsecase,
+			Inline:        inlineUsecase,
+			Invite:        inviteUsecase,
+			AccessRequest: accessRequestUsecase,
+			Quota:         quotaUsecase,
+			Payment:       paymentUsecase,
+			RoleExpiry:    roleExpiryUsecase,
+			RateLimit:     usecase.NewRateLimitUsecase(cfg.RateLimit, cfg.Roles),
+			Ban:           banUsecase,
+			Moderation:    moderationUsecase,
+			Broadcast: usecase.NewBroadcastUsecase(
+				usecase.BroadcastUsecaseDeps{
+					BroadcastStorage: userStorage,
+					User:             userUsecase,
+					Ban:              banUsecase,
+				}, cfg.Broadcast,
+			),
 		},
 	)
 	if err != nil {
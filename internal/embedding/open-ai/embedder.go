@@ -0,0 +1,53 @@
+package open_ai
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+)
+
+// batchSize limits the number of texts sent in one embeddings request.
+const batchSize = 64
+
+// Embedder creates text embeddings through an OpenAI compatible /embeddings endpoint.
+type Embedder struct {
+	client *openai.Client
+	model  string
+}
+
+func NewEmbedder(apiKey, baseURL, model string) *Embedder {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.BaseURL = baseURL
+	return &Embedder{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		batch := texts[start:min(start+batchSize, len(texts))]
+		resp, err := e.client.CreateEmbeddings(
+			ctx, openai.EmbeddingRequest{
+				Input: batch,
+				Model: openai.EmbeddingModel(e.model),
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embeddings: %w", err)
+		}
+		if len(resp.Data) != len(batch) {
+			return nil, fmt.Errorf("got %d embeddings for %d texts", len(resp.Data), len(batch))
+		}
+		batchEmbeddings := make([][]float32, len(batch))
+		for _, data := range resp.Data {
+			if data.Index < 0 || data.Index >= len(batch) {
+				return nil, fmt.Errorf("unexpected embedding index %d", data.Index)
+			}
+			batchEmbeddings[data.Index] = data.Embedding
+		}
+		embeddings = append(embeddings, batchEmbeddings...)
+	}
+	return embeddings, nil
+}
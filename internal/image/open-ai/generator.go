@@ -0,0 +1,84 @@
+package open_ai
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrEmptyImageResponse = errors.New("empty image response")
+)
+
+// Generator creates images through an OpenAI compatible /images/generations endpoint.
+type Generator struct {
+	client     *openai.Client
+	httpClient *http.Client
+}
+
+func NewGenerator(apiKey, baseURL string) *Generator {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.BaseURL = baseURL
+	return &Generator{
+		client:     openai.NewClientWithConfig(clientConfig),
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (g *Generator) GenerateImage(ctx context.Context, request model.ImageRequest) (model.GeneratedImage, error) {
+	resp, err := g.client.CreateImage(
+		ctx, openai.ImageRequest{
+			Prompt: request.Prompt,
+			Model:  request.Model,
+			Size:   request.Size,
+			N:      1,
+		},
+	)
+	if err != nil {
+		return model.GeneratedImage{}, fmt.Errorf("failed to create image: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return model.GeneratedImage{}, ErrEmptyImageResponse
+	}
+
+	// Some models return only base64 data and some only a link, so both are supported.
+	imageData := resp.Data[0]
+	var data []byte
+	if len(imageData.B64JSON) != 0 {
+		if data, err = base64.StdEncoding.DecodeString(imageData.B64JSON); err != nil {
+			return model.GeneratedImage{}, fmt.Errorf("failed to decode image: %w", err)
+		}
+	} else if len(imageData.URL) != 0 {
+		if data, err = g.download(ctx, imageData.URL); err != nil {
+			return model.GeneratedImage{}, fmt.Errorf("failed to download image: %w", err)
+		}
+	} else {
+		return model.GeneratedImage{}, ErrEmptyImageResponse
+	}
+
+	return model.GeneratedImage{
+		Data:          data,
+		RevisedPrompt: imageData.RevisedPrompt,
+	}, nil
+}
+
+func (g *Generator) download(ctx context.Context, imageURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
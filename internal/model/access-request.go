@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// AccessRequest is a request of a Telegram user without access to a private bot, waiting for admins.
+type AccessRequest struct {
+	TelegramID int64
+	Name       string
+	Username   string
+	// LanguageCode is used to notify the user about the decision.
+	LanguageCode string
+	CreatedAt    time.Time
+}
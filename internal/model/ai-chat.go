@@ -7,11 +7,14 @@ type MessageSource string
 const (
 	MessageSourceUser      = MessageSource("user")
 	MessageSourceAssistant = MessageSource("assistant")
+	MessageSourceContext   = MessageSource("context")
 )
 
 type Message struct {
 	Source MessageSource
 	Body   string
+	// Usage is set for assistant messages answered by chat models.
+	Usage Usage
 }
 
 type AIChat struct {
@@ -20,4 +23,10 @@ type AIChat struct {
 	Messages         []Message
 	Model            string
 	ModelTemperature float32
+	VoiceReplies     bool
+	UseKnowledgeBase bool
+	Persona          string
+	SystemPrompt     string
+	// Usage is the sum of usage of the chat messages.
+	Usage Usage
 }
@@ -0,0 +1,20 @@
+package model
+
+type ToolCallStatus string
+
+const (
+	ToolCallStatusRunning = ToolCallStatus("running")
+	ToolCallStatusDone    = ToolCallStatus("done")
+	ToolCallStatusFailed  = ToolCallStatus("failed")
+)
+
+type ToolCall struct {
+	Name   string
+	Status ToolCallStatus
+}
+
+// AnswerProgress is the current state of a streamed answer.
+type AnswerProgress struct {
+	Text      string
+	ToolCalls []ToolCall
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// Ban keeps the Telegram user away from the bot.
+type Ban struct {
+	TelegramID int64
+	Reason     string
+	BannedBy   int64
+	CreatedAt  time.Time
+	// ExpiresAt is zero for bans without expiry.
+	ExpiresAt time.Time
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// BroadcastDraft is a message of the admin waiting for confirmation to be copied to users.
+type BroadcastDraft struct {
+	ID      string
+	AdminID int64
+	// ChatID and MessageID are of the message to copy.
+	ChatID    int64
+	MessageID int
+	// Role limits recipients to users with the role, empty role sends to everyone.
+	Role      UserRole
+	CreatedAt time.Time
+}
@@ -5,5 +5,13 @@ import (
 )
 
 var (
-	ErrTelegramUserDoesNotExists = errors.New("telegram userInternal doesn't exists")
+	ErrTelegramUserDoesNotExists     = errors.New("telegram userInternal doesn't exists")
+	ErrUserDoesNotExist              = errors.New("user doesn't exist")
+	ErrTelegramChatDoesNotExist      = errors.New("telegram chat doesn't exist")
+	ErrPromptTemplateDoesNotExist    = errors.New("prompt template doesn't exist")
+	ErrKnowledgeDocumentDoesNotExist = errors.New("knowledge document doesn't exist")
+	ErrInviteDoesNotExist            = errors.New("invite doesn't exist")
+	ErrAccessRequestDoesNotExist     = errors.New("access request doesn't exist")
+	ErrPaymentDoesNotExist           = errors.New("payment doesn't exist")
+	ErrBanDoesNotExist               = errors.New("ban doesn't exist")
 )
@@ -0,0 +1,12 @@
+package model
+
+type ImageRequest struct {
+	Prompt string
+	Model  string
+	Size   string
+}
+
+type GeneratedImage struct {
+	Data          []byte
+	RevisedPrompt string
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// Invite grants the role to users redeeming its code.
+type Invite struct {
+	Code string
+	Role UserRole
+	// Uses is the number of users who can redeem the invite, zero allows any number.
+	Uses int
+	Used int
+	// ExpiresAt is zero for invites without expiry.
+	ExpiresAt time.Time
+	CreatedBy int64
+	CreatedAt time.Time
+}
+
+func (i Invite) IsExpired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt)
+}
+
+func (i Invite) IsUsedUp() bool {
+	return i.Uses != 0 && i.Used >= i.Uses
+}
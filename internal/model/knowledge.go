@@ -0,0 +1,27 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type KnowledgeDocument struct {
+	DocumentID  uuid.UUID
+	UserID      uuid.UUID
+	Title       string
+	ChunksCount int
+	CreatedAt   time.Time
+}
+
+type KnowledgeChunk struct {
+	DocumentID uuid.UUID
+	Title      string
+	Index      int
+	Text       string
+	Embedding  []float32
+}
+
+type KnowledgeMatch struct {
+	Chunk KnowledgeChunk
+	Score float64
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+)
+
+// Payment is a purchase of a product for Telegram Stars. What the product gave is kept in the payment, so it
+// can be taken back on refund after the product is changed.
+type Payment struct {
+	// ChargeID is the Telegram payment charge ID, it is needed for refunds.
+	ChargeID   string
+	TelegramID int64
+	ProductID  string
+	Stars      int
+	Role       UserRole
+	Duration   time.Duration
+	Tokens     int
+	CreatedAt  time.Time
+	// RefundedAt is zero for payments which weren't refunded.
+	RefundedAt time.Time
+}
+
+func (p Payment) IsRefunded() bool {
+	return !p.RefundedAt.IsZero()
+}
@@ -0,0 +1,10 @@
+package model
+
+type Persona struct {
+	Name         string
+	Description  string
+	Model        string
+	SystemPrompt string
+	Temperature  float32
+	Tools        []string
+}
@@ -1,20 +1,24 @@
 package model
 
-type UserRole int8
+import (
+	"strings"
+)
+
+// UserRole is a name of a role from the roles config. Default, admin and premium roles have special meaning:
+// every user has the default role, admins manage the bot and both admin and premium roles can be given by
+// environment lists.
+type UserRole string
 
 const (
-	UserRoleDefault = UserRole(iota)
-	UserRoleAdmin
-	UserRolePremium
+	UserRoleDefault = UserRole("default")
+	UserRoleAdmin   = UserRole("admin")
+	UserRolePremium = UserRole("premium")
 )
 
 func ParseUserRole(s string) UserRole {
-	switch s {
-	case "admin":
-		return UserRoleAdmin
-	case "premium":
-		return UserRolePremium
-	default:
-		return UserRoleDefault
-	}
+	return UserRole(strings.TrimSpace(s))
+}
+
+func (r UserRole) String() string {
+	return string(r)
 }
@@ -0,0 +1,8 @@
+package model
+
+type PromptTemplate struct {
+	Name string
+	Text string
+	// Shared templates come from the config and are available to every user.
+	Shared bool
+}
@@ -0,0 +1,17 @@
+package model
+
+import "github.com/google/uuid"
+
+// Topic is a thread of a Telegram forum supergroup with its own AI chat.
+type Topic struct {
+	ChatID   int64
+	ThreadID int
+	AIChatID uuid.UUID
+	// Model or Persona the topic is bound to, new chats of the topic are created with it.
+	Model   string
+	Persona string
+}
+
+func (t Topic) IsBound() bool {
+	return len(t.Model) != 0 || len(t.Persona) != 0
+}
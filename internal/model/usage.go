@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+)
+
+// Usage is tokens and cost of a model answer.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	// Cost is in USD.
+	Cost float64
+}
+
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Cost:             u.Cost + other.Cost,
+	}
+}
+
+// Quota limits usage of chat models by a user, zero limits are unlimited.
+type Quota struct {
+	RequestsPerDay int
+	TokensPerMonth int
+	SpendPerMonth  float64
+}
+
+// QuotaUsage is usage of a user in the current quota periods.
+type QuotaUsage struct {
+	Requests int
+	Tokens   int
+	Spend    float64
+	// RequestsResetAt and SpendResetAt are when the oldest counted usage leaves the rolling period.
+	RequestsResetAt time.Time
+	SpendResetAt    time.Time
+	// Total is usage of the user for all time.
+	Total Usage
+	// Credits are bought tokens spent after the month tokens, they don't reset.
+	Credits int
+}
@@ -2,11 +2,16 @@ package model
 
 import (
 	"github.com/google/uuid"
+	"time"
 )
 
 type User struct {
-	UserID     uuid.UUID
+	UserID uuid.UUID
+	// TelegramID is the ID of the Telegram user or, for users owning AI chats of group chats, of the group chat.
 	TelegramID int64
 	Roles      []UserRole
 	LastAIChat uuid.UUID
+	Timezone   string
+	// RoleExpiresAt has expiry of roles given for a time, other roles never expire.
+	RoleExpiresAt map[UserRole]time.Time
 }
@@ -0,0 +1,7 @@
+package model
+
+type VoiceSettings struct {
+	Voice  string
+	Format string
+	Speed  float64
+}
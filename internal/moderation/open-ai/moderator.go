@@ -0,0 +1,87 @@
+package open_ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodySize limits the part of an error response kept in the error.
+const maxErrorBodySize = 512
+
+type moderationRequest struct {
+	Input string `json:"input"`
+	Model string `json:"model,omitempty"`
+}
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// Moderator checks texts through an OpenAI compatible /moderations endpoint. The go-openai client isn't used,
+// as it knows only a few OpenAI models and categories.
+type Moderator struct {
+	client  *http.Client
+	apiKey  string
+	baseURL string
+	model   string
+}
+
+func NewModerator(apiKey, baseURL, model string) *Moderator {
+	return &Moderator{
+		client:  &http.Client{},
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+	}
+}
+
+// Moderate returns categories the text is flagged with.
+func (m *Moderator) Moderate(ctx context.Context, text string) ([]string, error) {
+	body, err := json.Marshal(moderationRequest{Input: text, Model: m.model})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal moderation request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/moderations", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(m.apiKey) != 0 {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send moderation request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, fmt.Errorf("moderation request failed with status %s: %s", resp.Status, errBody)
+	}
+
+	var moderation moderationResponse
+	if err = json.NewDecoder(resp.Body).Decode(&moderation); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+	categories := make([]string, 0)
+	for _, result := range moderation.Results {
+		if !result.Flagged {
+			continue
+		}
+		for category, flagged := range result.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+	}
+	return categories, nil
+}
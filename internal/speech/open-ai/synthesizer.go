@@ -0,0 +1,46 @@
+package open_ai
+
+import (
+	"context"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/sashabaranov/go-openai"
+	"io"
+)
+
+// Synthesizer converts text to speech through an OpenAI compatible /audio/speech endpoint.
+type Synthesizer struct {
+	client *openai.Client
+	model  string
+}
+
+func NewSynthesizer(apiKey, baseURL, model string) *Synthesizer {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.BaseURL = baseURL
+	return &Synthesizer{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (s *Synthesizer) Synthesize(ctx context.Context, text string, settings model.VoiceSettings) ([]byte, error) {
+	resp, err := s.client.CreateSpeech(
+		ctx, openai.CreateSpeechRequest{
+			Model:          openai.SpeechModel(s.model),
+			Input:          text,
+			Voice:          openai.SpeechVoice(settings.Voice),
+			ResponseFormat: openai.SpeechResponseFormat(settings.Format),
+			Speed:          settings.Speed,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create speech: %w", err)
+	}
+	defer resp.Close()
+
+	audio, err := io.ReadAll(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speech: %w", err)
+	}
+	return audio, nil
+}
@@ -0,0 +1,38 @@
+package open_ai
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+)
+
+// Transcriber converts speech to text through an OpenAI compatible /audio/transcriptions endpoint, so
+// a self-hosted whisper server can be used instead of OpenAI.
+type Transcriber struct {
+	client *openai.Client
+	model  string
+}
+
+func NewTranscriber(apiKey, baseURL, model string) *Transcriber {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.BaseURL = baseURL
+	return &Transcriber{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (t *Transcriber) Transcribe(ctx context.Context, fileName string, audio io.Reader) (string, error) {
+	resp, err := t.client.CreateTranscription(
+		ctx, openai.AudioRequest{
+			Model:    t.model,
+			FilePath: fileName,
+			Reader:   audio,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription: %w", err)
+	}
+	return resp.Text, nil
+}
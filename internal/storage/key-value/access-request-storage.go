@@ -0,0 +1,115 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// accessRequestsKey is a hash of pending access requests by Telegram user IDs.
+const accessRequestsKey = "access_requests"
+
+type accessRequestInternal struct {
+	TelegramID   int64     `json:"telegram_id"`
+	Name         string    `json:"name"`
+	Username     string    `json:"username"`
+	LanguageCode string    `json:"language_code"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type AccessRequestStorage struct {
+	rdb *redis.Client
+}
+
+func NewAccessRequestStorage(rdb *redis.Client) *AccessRequestStorage {
+	return &AccessRequestStorage{
+		rdb: rdb,
+	}
+}
+
+// CreateAccessRequest saves the request if the user has no pending one. It returns false for repeated requests.
+func (a *AccessRequestStorage) CreateAccessRequest(ctx context.Context, request model.AccessRequest) (bool, error) {
+	requestJSON, err := json.Marshal(
+		accessRequestInternal{
+			TelegramID:   request.TelegramID,
+			Name:         request.Name,
+			Username:     request.Username,
+			LanguageCode: request.LanguageCode,
+			CreatedAt:    request.CreatedAt,
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal access request: %w", err)
+	}
+	created, err := a.rdb.HSetNX(ctx, accessRequestsKey, getAccessRequestField(request.TelegramID), requestJSON).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to save access request of %v: %w", request.TelegramID, err)
+	}
+	return created, nil
+}
+
+// TakeAccessRequest removes the pending request and returns it. Only one of concurrent callers gets the request,
+// others get model.ErrAccessRequestDoesNotExist.
+func (a *AccessRequestStorage) TakeAccessRequest(ctx context.Context, telegramID int64) (model.AccessRequest, error) {
+	field := getAccessRequestField(telegramID)
+	requestRaw, err := a.rdb.HGet(ctx, accessRequestsKey, field).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return model.AccessRequest{}, model.ErrAccessRequestDoesNotExist
+		}
+		return model.AccessRequest{}, fmt.Errorf("failed to get access request of %v: %w", telegramID, err)
+	}
+	deleted, err := a.rdb.HDel(ctx, accessRequestsKey, field).Result()
+	if err != nil {
+		return model.AccessRequest{}, fmt.Errorf("failed to delete access request of %v: %w", telegramID, err)
+	}
+	if deleted == 0 {
+		return model.AccessRequest{}, model.ErrAccessRequestDoesNotExist
+	}
+	var request accessRequestInternal
+	if err = json.Unmarshal([]byte(requestRaw), &request); err != nil {
+		return model.AccessRequest{}, fmt.Errorf("failed to unmarshal access request of %v: %w", telegramID, err)
+	}
+	return model.AccessRequest{
+		TelegramID:   request.TelegramID,
+		Name:         request.Name,
+		Username:     request.Username,
+		LanguageCode: request.LanguageCode,
+		CreatedAt:    request.CreatedAt,
+	}, nil
+}
+
+// SetAccessRequestDenied keeps the denial of the user until the time, the key expires with it.
+func (a *AccessRequestStorage) SetAccessRequestDenied(ctx context.Context, telegramID int64, until time.Time) error {
+	err := a.rdb.Set(ctx, getAccessRequestDeniedKey(telegramID), until.Unix(), time.Until(until)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save access request denial of %v: %w", telegramID, err)
+	}
+	return nil
+}
+
+// GetAccessRequestDeniedUntil returns the time the user can request access again, zero time if the user hasn't
+// been denied.
+func (a *AccessRequestStorage) GetAccessRequestDeniedUntil(ctx context.Context, telegramID int64) (time.Time, error) {
+	until, err := a.rdb.Get(ctx, getAccessRequestDeniedKey(telegramID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get access request denial of %v: %w", telegramID, err)
+	}
+	return time.Unix(until, 0), nil
+}
+
+func getAccessRequestField(telegramID int64) string {
+	return strconv.FormatInt(telegramID, 10)
+}
+
+func getAccessRequestDeniedKey(telegramID int64) string {
+	return fmt.Sprintf("access_request_denied_%v", telegramID)
+}
@@ -18,6 +18,32 @@ var (
 type messageInternal struct {
 	Source model.MessageSource `json:"source"`
 	Body   string              `json:"body"`
+	Usage  *usageInternal      `json:"usage,omitempty"`
+}
+
+type usageInternal struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func newUsageInternal(usage model.Usage) usageInternal {
+	return usageInternal{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             usage.Cost,
+	}
+}
+
+func (u *usageInternal) toModel() model.Usage {
+	if u == nil {
+		return model.Usage{}
+	}
+	return model.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Cost:             u.Cost,
+	}
 }
 
 type chatInternal struct {
@@ -26,8 +52,16 @@ type chatInternal struct {
 	Messages         []messageInternal `json:"messages"`
 	Model            string            `json:"model"`
 	ModelTemperature float32           `json:"model_temperature"`
+	VoiceReplies     bool              `json:"voice_replies"`
+	UseKnowledgeBase bool              `json:"use_knowledge_base"`
+	Persona          string            `json:"persona,omitempty"`
+	SystemPrompt     string            `json:"system_prompt,omitempty"`
+	Usage            usageInternal     `json:"usage"`
 }
 
+// maxChatUpdateRetries limits attempts to update a chat changed concurrently, see updateChat.
+const maxChatUpdateRetries = 10
+
 type userChatsIDs struct {
 	Chats []string `json:"chats"`
 }
@@ -61,17 +95,7 @@ func (a *AIChatStorage) CreateChat(
 	if err := a.setChatInt(ctx, chatID, chatInt); err != nil {
 		return model.AIChat{}, fmt.Errorf("failed to set chat internal %s: %w", chatID.String(), err)
 	}
-	userChatsIDsInt, err := a.getUserChatsIDs(ctx, userID)
-	if err != nil {
-		if !errors.Is(err, ErrUserChatsIDsDoNotExist) {
-			return model.AIChat{}, fmt.Errorf("failed to get user chats ids: %w", err)
-		}
-		userChatsIDsInt = userChatsIDs{
-			Chats: make([]string, 0),
-		}
-	}
-	userChatsIDsInt.Chats = append(userChatsIDsInt.Chats, chatID.String())
-	if err = a.setUserChatsIDs(ctx, userID, userChatsIDsInt); err != nil {
+	if err := a.addUserChatID(ctx, userID, chatID); err != nil {
 		return model.AIChat{}, fmt.Errorf("failed to set user chats ids: %w", err)
 	}
 
@@ -118,6 +142,7 @@ func (a *AIChatStorage) GetChat(ctx context.Context, chatID uuid.UUID) (model.AI
 			messages, model.Message{
 				Source: msg.Source,
 				Body:   msg.Body,
+				Usage:  msg.Usage.toModel(),
 			},
 		)
 	}
@@ -128,6 +153,11 @@ func (a *AIChatStorage) GetChat(ctx context.Context, chatID uuid.UUID) (model.AI
 		Model:            chatInt.Model,
 		ModelTemperature: chatInt.ModelTemperature,
 		Messages:         messages,
+		VoiceReplies:     chatInt.VoiceReplies,
+		UseKnowledgeBase: chatInt.UseKnowledgeBase,
+		Persona:          chatInt.Persona,
+		SystemPrompt:     chatInt.SystemPrompt,
+		Usage:            chatInt.Usage.toModel(),
 	}
 	return chat, nil
 }
@@ -138,27 +168,116 @@ func (a *AIChatStorage) AddMessageToChat(
 	messageText string,
 	messageSource model.MessageSource,
 ) error {
-	chatInt, err := a.getChatInt(ctx, chatID)
-	if err != nil {
-		return err
-	}
-	chatInt.Messages = append(
-		chatInt.Messages, messageInternal{
-			Source: messageSource,
-			Body:   messageText,
+	return a.updateChat(
+		ctx, chatID, func(chatInt *chatInternal) {
+			chatInt.Messages = append(
+				chatInt.Messages, messageInternal{
+					Source: messageSource,
+					Body:   messageText,
+				},
+			)
+		},
+	)
+}
+
+// AddAnswerToChat adds the assistant message with its usage to the chat and to the chat usage. The index of the
+// message in the chat is returned.
+func (a *AIChatStorage) AddAnswerToChat(
+	ctx context.Context,
+	chatID uuid.UUID,
+	messageText string,
+	usage model.Usage,
+) (int, error) {
+	messageUsage := newUsageInternal(usage)
+	var index int
+	err := a.updateChat(
+		ctx, chatID, func(chatInt *chatInternal) {
+			index = len(chatInt.Messages)
+			chatInt.Messages = append(
+				chatInt.Messages, messageInternal{
+					Source: model.MessageSourceAssistant,
+					Body:   messageText,
+					Usage:  &messageUsage,
+				},
+			)
+			chatInt.Usage = newUsageInternal(chatInt.Usage.toModel().Add(usage))
 		},
 	)
-	err = a.setChatInt(ctx, chatID, chatInt)
 	if err != nil {
-		return fmt.Errorf("failed to set internal chat %s: %w", chatID.String(), err)
+		return 0, err
 	}
+	return index, nil
+}
 
-	return nil
+func (a *AIChatStorage) UpdateChatVoiceReplies(ctx context.Context, chatID uuid.UUID, enabled bool) error {
+	return a.updateChat(
+		ctx, chatID, func(chatInt *chatInternal) {
+			chatInt.VoiceReplies = enabled
+		},
+	)
+}
+
+func (a *AIChatStorage) UpdateChatKnowledgeBase(ctx context.Context, chatID uuid.UUID, enabled bool) error {
+	return a.updateChat(
+		ctx, chatID, func(chatInt *chatInternal) {
+			chatInt.UseKnowledgeBase = enabled
+		},
+	)
+}
+
+func (a *AIChatStorage) UpdateChatPersona(
+	ctx context.Context,
+	chatID uuid.UUID,
+	persona string,
+	systemPrompt string,
+) error {
+	return a.updateChat(
+		ctx, chatID, func(chatInt *chatInternal) {
+			chatInt.Persona = persona
+			chatInt.SystemPrompt = systemPrompt
+		},
+	)
+}
+
+// updateChat applies the update to the chat in a transaction watching the chat key, so updates of concurrently
+// handled messages aren't lost. The update is retried if the chat is changed meanwhile.
+func (a *AIChatStorage) updateChat(ctx context.Context, chatID uuid.UUID, update func(chatInt *chatInternal)) error {
+	chatIDKey := getChatIDKey(chatID)
+	for range maxChatUpdateRetries {
+		err := a.rdb.Watch(
+			ctx, func(tx *redis.Tx) error {
+				chatInt, err := readChat(ctx, tx, chatID)
+				if err != nil {
+					return err
+				}
+				update(&chatInt)
+				chatIntJSON, err := json.Marshal(chatInt)
+				if err != nil {
+					return fmt.Errorf("failed to marshal internal chat: %w", err)
+				}
+				_, err = tx.TxPipelined(
+					ctx, func(pipe redis.Pipeliner) error {
+						pipe.Set(ctx, chatIDKey, chatIntJSON, 0)
+						return nil
+					},
+				)
+				return err
+			}, chatIDKey,
+		)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to set internal chat %s: changed concurrently %d times", chatID, maxChatUpdateRetries)
 }
 
 func (a *AIChatStorage) getChatInt(ctx context.Context, chatID uuid.UUID) (chatInternal, error) {
+	return readChat(ctx, a.rdb, chatID)
+}
+
+func readChat(ctx context.Context, rdb redis.Cmdable, chatID uuid.UUID) (chatInternal, error) {
 	chatIDKey := getChatIDKey(chatID)
-	chatIntRaw, err := a.rdb.Get(ctx, chatIDKey).Result()
+	chatIntRaw, err := rdb.Get(ctx, chatIDKey).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return chatInternal{}, ErrChatDoesNotExist
@@ -186,8 +305,12 @@ func (a *AIChatStorage) setChatInt(ctx context.Context, chatID uuid.UUID, chatIn
 }
 
 func (a *AIChatStorage) getUserChatsIDs(ctx context.Context, userID uuid.UUID) (userChatsIDs, error) {
+	return readUserChatsIDs(ctx, a.rdb, userID)
+}
+
+func readUserChatsIDs(ctx context.Context, rdb redis.Cmdable, userID uuid.UUID) (userChatsIDs, error) {
 	userChatsKey := getUserChatsKey(userID)
-	userChatsRaw, err := a.rdb.Get(ctx, userChatsKey).Result()
+	userChatsRaw, err := rdb.Get(ctx, userChatsKey).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return userChatsIDs{}, ErrUserChatsIDsDoNotExist
@@ -202,16 +325,43 @@ func (a *AIChatStorage) getUserChatsIDs(ctx context.Context, userID uuid.UUID) (
 	return userChats, nil
 }
 
-func (a *AIChatStorage) setUserChatsIDs(ctx context.Context, userID uuid.UUID, userChatsIDsInt userChatsIDs) error {
-	userChatsIDsIntJSON, err := json.Marshal(userChatsIDsInt)
-	if err != nil {
-		return fmt.Errorf("failed to marshal user chats ids: %w", err)
-	}
+// addUserChatID appends the chat to the chats of the user in a transaction watching them, so chats created
+// concurrently are all kept.
+func (a *AIChatStorage) addUserChatID(ctx context.Context, userID uuid.UUID, chatID uuid.UUID) error {
 	userChatsKey := getUserChatsKey(userID)
-	if err = a.rdb.Set(ctx, userChatsKey, userChatsIDsIntJSON, 0).Err(); err != nil {
-		return fmt.Errorf("failed to save user chats ids %s: %w", userChatsKey, err)
+	for range maxChatUpdateRetries {
+		err := a.rdb.Watch(
+			ctx, func(tx *redis.Tx) error {
+				userChatsIDsInt, err := readUserChatsIDs(ctx, tx, userID)
+				if err != nil {
+					if !errors.Is(err, ErrUserChatsIDsDoNotExist) {
+						return fmt.Errorf("failed to get user chats ids: %w", err)
+					}
+					userChatsIDsInt = userChatsIDs{
+						Chats: make([]string, 0),
+					}
+				}
+				userChatsIDsInt.Chats = append(userChatsIDsInt.Chats, chatID.String())
+				userChatsIDsIntJSON, err := json.Marshal(userChatsIDsInt)
+				if err != nil {
+					return fmt.Errorf("failed to marshal user chats ids: %w", err)
+				}
+				_, err = tx.TxPipelined(
+					ctx, func(pipe redis.Pipeliner) error {
+						pipe.Set(ctx, userChatsKey, userChatsIDsIntJSON, 0)
+						return nil
+					},
+				)
+				return err
+			}, userChatsKey,
+		)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
 	}
-	return nil
+	return fmt.Errorf(
+		"failed to save user chats ids %s: changed concurrently %d times", userChatsKey, maxChatUpdateRetries,
+	)
 }
 
 func getChatIDKey(chatID uuid.UUID) string {
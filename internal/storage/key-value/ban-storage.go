@@ -0,0 +1,88 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+type banInternal struct {
+	TelegramID int64     `json:"telegram_id"`
+	Reason     string    `json:"reason,omitempty"`
+	BannedBy   int64     `json:"banned_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+}
+
+// BanStorage keeps bans in keys expiring with them, so lifted bans don't have to be removed.
+type BanStorage struct {
+	rdb *redis.Client
+}
+
+func NewBanStorage(rdb *redis.Client) *BanStorage {
+	return &BanStorage{
+		rdb: rdb,
+	}
+}
+
+// SetBan saves the ban, replacing the previous ban of the user.
+func (b *BanStorage) SetBan(ctx context.Context, ban model.Ban) error {
+	banJSON, err := json.Marshal(
+		banInternal{
+			TelegramID: ban.TelegramID,
+			Reason:     ban.Reason,
+			BannedBy:   ban.BannedBy,
+			CreatedAt:  ban.CreatedAt,
+			ExpiresAt:  ban.ExpiresAt,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ban: %w", err)
+	}
+	var ttl time.Duration
+	if !ban.ExpiresAt.IsZero() {
+		ttl = time.Until(ban.ExpiresAt)
+	}
+	if err = b.rdb.Set(ctx, getBanKey(ban.TelegramID), banJSON, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save ban of %v: %w", ban.TelegramID, err)
+	}
+	return nil
+}
+
+func (b *BanStorage) GetBan(ctx context.Context, telegramID int64) (model.Ban, error) {
+	banRaw, err := b.rdb.Get(ctx, getBanKey(telegramID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return model.Ban{}, model.ErrBanDoesNotExist
+		}
+		return model.Ban{}, fmt.Errorf("failed to get ban of %v: %w", telegramID, err)
+	}
+	var ban banInternal
+	if err = json.Unmarshal([]byte(banRaw), &ban); err != nil {
+		return model.Ban{}, fmt.Errorf("failed to unmarshal ban of %v: %w", telegramID, err)
+	}
+	return model.Ban{
+		TelegramID: ban.TelegramID,
+		Reason:     ban.Reason,
+		BannedBy:   ban.BannedBy,
+		CreatedAt:  ban.CreatedAt,
+		ExpiresAt:  ban.ExpiresAt,
+	}, nil
+}
+
+// DeleteBan returns false if the user isn't banned.
+func (b *BanStorage) DeleteBan(ctx context.Context, telegramID int64) (bool, error) {
+	deleted, err := b.rdb.Del(ctx, getBanKey(telegramID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete ban of %v: %w", telegramID, err)
+	}
+	return deleted != 0, nil
+}
+
+func getBanKey(telegramID int64) string {
+	return fmt.Sprintf("ban_%v", telegramID)
+}
@@ -0,0 +1,178 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const invitesKey = "invites"
+
+type inviteInternal struct {
+	Code      string         `json:"code"`
+	Role      model.UserRole `json:"role"`
+	Uses      int            `json:"uses"`
+	ExpiresAt time.Time      `json:"expires_at,omitempty"`
+	CreatedBy int64          `json:"created_by"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// InviteStorage keeps invites in separate keys expiring with them, the number of redemptions is counted in
+// another key by a script, so invites are redeemed atomically.
+type InviteStorage struct {
+	rdb *redis.Client
+}
+
+func NewInviteStorage(rdb *redis.Client) *InviteStorage {
+	return &InviteStorage{
+		rdb: rdb,
+	}
+}
+
+func (i *InviteStorage) CreateInvite(ctx context.Context, invite model.Invite) error {
+	inviteJSON, err := json.Marshal(
+		inviteInternal{
+			Code:      invite.Code,
+			Role:      invite.Role,
+			Uses:      invite.Uses,
+			ExpiresAt: invite.ExpiresAt,
+			CreatedBy: invite.CreatedBy,
+			CreatedAt: invite.CreatedAt,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to marshal invite: %w", err)
+	}
+	var ttl time.Duration
+	if !invite.ExpiresAt.IsZero() {
+		ttl = time.Until(invite.ExpiresAt)
+	}
+	_, err = i.rdb.TxPipelined(
+		ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, getInviteKey(invite.Code), inviteJSON, ttl)
+			pipe.Set(ctx, getInviteUsedKey(invite.Code), 0, ttl)
+			pipe.SAdd(ctx, invitesKey, invite.Code)
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save invite %s: %w", invite.Code, err)
+	}
+	return nil
+}
+
+func (i *InviteStorage) GetInvite(ctx context.Context, code string) (model.Invite, error) {
+	inviteRaw, err := i.rdb.Get(ctx, getInviteKey(code)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return model.Invite{}, model.ErrInviteDoesNotExist
+		}
+		return model.Invite{}, fmt.Errorf("failed to get invite %s: %w", code, err)
+	}
+	var invite inviteInternal
+	if err = json.Unmarshal([]byte(inviteRaw), &invite); err != nil {
+		return model.Invite{}, fmt.Errorf("failed to unmarshal invite %s: %w", code, err)
+	}
+	used, err := i.rdb.Get(ctx, getInviteUsedKey(code)).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return model.Invite{}, fmt.Errorf("failed to get invite %s uses: %w", code, err)
+	}
+	return model.Invite{
+		Code:      invite.Code,
+		Role:      invite.Role,
+		Uses:      invite.Uses,
+		Used:      used,
+		ExpiresAt: invite.ExpiresAt,
+		CreatedBy: invite.CreatedBy,
+		CreatedAt: invite.CreatedAt,
+	}, nil
+}
+
+// ListInvites returns invites which haven't expired, expired ones are removed from the list.
+func (i *InviteStorage) ListInvites(ctx context.Context) ([]model.Invite, error) {
+	codes, err := i.rdb.SMembers(ctx, invitesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invites: %w", err)
+	}
+	invites := make([]model.Invite, 0, len(codes))
+	for _, code := range codes {
+		invite, err := i.GetInvite(ctx, code)
+		if err != nil {
+			if errors.Is(err, model.ErrInviteDoesNotExist) {
+				if err = i.rdb.SRem(ctx, invitesKey, code).Err(); err != nil {
+					return nil, fmt.Errorf("failed to remove expired invite %s: %w", code, err)
+				}
+				continue
+			}
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+// useInviteScript counts one redemption of the invite if it still exists, so revoked and expired invites can't
+// be redeemed. The counter expires with the invite and both are removed after the last use.
+var useInviteScript = redis.NewScript(
+	`
+local uses = tonumber(ARGV[1])
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local used = redis.call("INCR", KEYS[2])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+if uses == 0 then
+	return 1
+end
+if used > uses then
+	return 0
+end
+if used == uses then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	redis.call("SREM", KEYS[3], ARGV[2])
+end
+return 1
+`,
+)
+
+// UseInvite counts one redemption of the invite. It returns false if the invite has no uses left or doesn't
+// exist anymore, e.g. it has been revoked after it was read.
+func (i *InviteStorage) UseInvite(ctx context.Context, invite model.Invite) (bool, error) {
+	used, err := useInviteScript.Run(
+		ctx, i.rdb, []string{getInviteKey(invite.Code), getInviteUsedKey(invite.Code), invitesKey}, invite.Uses,
+		invite.Code,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to use invite %s: %w", invite.Code, err)
+	}
+	return used == 1, nil
+}
+
+func (i *InviteStorage) DeleteInvite(ctx context.Context, code string) error {
+	_, err := i.rdb.TxPipelined(
+		ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, getInviteKey(code), getInviteUsedKey(code))
+			pipe.SRem(ctx, invitesKey, code)
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete invite %s: %w", code, err)
+	}
+	return nil
+}
+
+func getInviteKey(code string) string {
+	return fmt.Sprintf("invite_%s", code)
+}
+
+func getInviteUsedKey(code string) string {
+	return fmt.Sprintf("invite_used_%s", code)
+}
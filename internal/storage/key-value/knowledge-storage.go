@@ -0,0 +1,295 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"slices"
+	"time"
+)
+
+type knowledgeChunkInternal struct {
+	Index     int       `json:"index"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type knowledgeDocumentInternal struct {
+	DocumentID string                   `json:"document_id"`
+	UserID     string                   `json:"user_id"`
+	Title      string                   `json:"title"`
+	CreatedAt  time.Time                `json:"created_at"`
+	Chunks     []knowledgeChunkInternal `json:"chunks"`
+}
+
+type userKnowledgeIDs struct {
+	Documents []string `json:"documents"`
+}
+
+// maxKnowledgeUpdateRetries limits attempts to update knowledge IDs of a user changed concurrently, see
+// updateUserKnowledgeIDs.
+const maxKnowledgeUpdateRetries = 10
+
+type KnowledgeStorage struct {
+	rdb *redis.Client
+}
+
+func NewKnowledgeStorage(rdb *redis.Client) *KnowledgeStorage {
+	return &KnowledgeStorage{
+		rdb: rdb,
+	}
+}
+
+func (k *KnowledgeStorage) CreateKnowledgeDocument(
+	ctx context.Context,
+	userID uuid.UUID,
+	title string,
+	chunks []model.KnowledgeChunk,
+) (model.KnowledgeDocument, error) {
+	documentID := uuid.New()
+	documentInt := knowledgeDocumentInternal{
+		DocumentID: documentID.String(),
+		UserID:     userID.String(),
+		Title:      title,
+		CreatedAt:  time.Now(),
+		Chunks:     make([]knowledgeChunkInternal, 0, len(chunks)),
+	}
+	for _, chunk := range chunks {
+		documentInt.Chunks = append(
+			documentInt.Chunks, knowledgeChunkInternal{
+				Index:     chunk.Index,
+				Text:      chunk.Text,
+				Embedding: chunk.Embedding,
+			},
+		)
+	}
+	if err := k.setDocumentInt(ctx, documentID, documentInt); err != nil {
+		return model.KnowledgeDocument{}, fmt.Errorf("failed to set knowledge document: %w", err)
+	}
+
+	err := k.updateUserKnowledgeIDs(
+		ctx, userID, func(userIDs *userKnowledgeIDs) error {
+			userIDs.Documents = append(userIDs.Documents, documentID.String())
+			return nil
+		},
+	)
+	if err != nil {
+		return model.KnowledgeDocument{}, fmt.Errorf("failed to set user knowledge ids: %w", err)
+	}
+	return toKnowledgeDocument(documentID, userID, documentInt), nil
+}
+
+func (k *KnowledgeStorage) ListKnowledgeDocuments(ctx context.Context, userID uuid.UUID) (
+	[]model.KnowledgeDocument,
+	error,
+) {
+	documentInts, err := k.listUserDocumentInts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]model.KnowledgeDocument, 0, len(documentInts))
+	for _, documentInt := range documentInts {
+		documentID, err := uuid.Parse(documentInt.DocumentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document id %s: %w", documentInt.DocumentID, err)
+		}
+		documents = append(documents, toKnowledgeDocument(documentID, userID, documentInt))
+	}
+	return documents, nil
+}
+
+func (k *KnowledgeStorage) ListKnowledgeChunks(ctx context.Context, userID uuid.UUID) (
+	[]model.KnowledgeChunk,
+	error,
+) {
+	documentInts, err := k.listUserDocumentInts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]model.KnowledgeChunk, 0)
+	for _, documentInt := range documentInts {
+		documentID, err := uuid.Parse(documentInt.DocumentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document id %s: %w", documentInt.DocumentID, err)
+		}
+		for _, chunk := range documentInt.Chunks {
+			chunks = append(
+				chunks, model.KnowledgeChunk{
+					DocumentID: documentID,
+					Title:      documentInt.Title,
+					Index:      chunk.Index,
+					Text:       chunk.Text,
+					Embedding:  chunk.Embedding,
+				},
+			)
+		}
+	}
+	return chunks, nil
+}
+
+func (k *KnowledgeStorage) DeleteKnowledgeDocument(ctx context.Context, userID, documentID uuid.UUID) error {
+	err := k.updateUserKnowledgeIDs(
+		ctx, userID, func(userIDs *userKnowledgeIDs) error {
+			index := slices.Index(userIDs.Documents, documentID.String())
+			if index < 0 {
+				return model.ErrKnowledgeDocumentDoesNotExist
+			}
+			userIDs.Documents = slices.Delete(userIDs.Documents, index, index+1)
+			return nil
+		},
+	)
+	if err != nil {
+		if errors.Is(err, model.ErrKnowledgeDocumentDoesNotExist) {
+			return err
+		}
+		return fmt.Errorf("failed to set user knowledge ids: %w", err)
+	}
+	if err = k.rdb.Del(ctx, getKnowledgeDocumentKey(documentID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete knowledge document %s: %w", documentID, err)
+	}
+	return nil
+}
+
+func (k *KnowledgeStorage) listUserDocumentInts(ctx context.Context, userID uuid.UUID) (
+	[]knowledgeDocumentInternal,
+	error,
+) {
+	userIDs, err := k.getUserKnowledgeIDs(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user knowledge ids: %w", err)
+	}
+	documents := make([]knowledgeDocumentInternal, 0, len(userIDs.Documents))
+	for _, documentIDStr := range userIDs.Documents {
+		documentID, err := uuid.Parse(documentIDStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document id %s: %w", documentIDStr, err)
+		}
+		documentInt, err := k.getDocumentInt(ctx, documentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get knowledge document: %w", err)
+		}
+		documents = append(documents, documentInt)
+	}
+	return documents, nil
+}
+
+func (k *KnowledgeStorage) getDocumentInt(ctx context.Context, documentID uuid.UUID) (
+	knowledgeDocumentInternal,
+	error,
+) {
+	documentRaw, err := k.rdb.Get(ctx, getKnowledgeDocumentKey(documentID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return knowledgeDocumentInternal{}, model.ErrKnowledgeDocumentDoesNotExist
+		}
+		return knowledgeDocumentInternal{}, fmt.Errorf("failed to get knowledge document %s: %w", documentID, err)
+	}
+	var documentInt knowledgeDocumentInternal
+	if err = json.Unmarshal([]byte(documentRaw), &documentInt); err != nil {
+		return knowledgeDocumentInternal{}, fmt.Errorf(
+			"failed to unmarshal knowledge document %s: %w", documentID, err,
+		)
+	}
+	return documentInt, nil
+}
+
+func (k *KnowledgeStorage) setDocumentInt(
+	ctx context.Context,
+	documentID uuid.UUID,
+	documentInt knowledgeDocumentInternal,
+) error {
+	documentJSON, err := json.Marshal(documentInt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal knowledge document: %w", err)
+	}
+	documentKey := getKnowledgeDocumentKey(documentID)
+	if err = k.rdb.Set(ctx, documentKey, documentJSON, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save knowledge document %s: %w", documentKey, err)
+	}
+	return nil
+}
+
+func (k *KnowledgeStorage) getUserKnowledgeIDs(ctx context.Context, userID uuid.UUID) (userKnowledgeIDs, error) {
+	return readUserKnowledgeIDs(ctx, k.rdb, userID)
+}
+
+func readUserKnowledgeIDs(ctx context.Context, rdb redis.Cmdable, userID uuid.UUID) (userKnowledgeIDs, error) {
+	userIDsRaw, err := rdb.Get(ctx, getUserKnowledgeKey(userID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return userKnowledgeIDs{Documents: make([]string, 0)}, nil
+		}
+		return userKnowledgeIDs{}, fmt.Errorf("failed to get userKnowledgeIDs %s: %w", userID, err)
+	}
+	var userIDs userKnowledgeIDs
+	if err = json.Unmarshal([]byte(userIDsRaw), &userIDs); err != nil {
+		return userKnowledgeIDs{}, fmt.Errorf("failed to unmarshal userKnowledgeIDs %s: %w", userID, err)
+	}
+	return userIDs, nil
+}
+
+// updateUserKnowledgeIDs applies the update to document IDs of the user in a transaction watching them, so
+// documents added or deleted concurrently aren't lost. The update is retried if the IDs are changed meanwhile.
+func (k *KnowledgeStorage) updateUserKnowledgeIDs(
+	ctx context.Context,
+	userID uuid.UUID,
+	update func(userIDs *userKnowledgeIDs) error,
+) error {
+	userKnowledgeKey := getUserKnowledgeKey(userID)
+	for range maxKnowledgeUpdateRetries {
+		err := k.rdb.Watch(
+			ctx, func(tx *redis.Tx) error {
+				userIDs, err := readUserKnowledgeIDs(ctx, tx, userID)
+				if err != nil {
+					return fmt.Errorf("failed to get user knowledge ids: %w", err)
+				}
+				if err = update(&userIDs); err != nil {
+					return err
+				}
+				userIDsJSON, err := json.Marshal(userIDs)
+				if err != nil {
+					return fmt.Errorf("failed to marshal user knowledge ids: %w", err)
+				}
+				_, err = tx.TxPipelined(
+					ctx, func(pipe redis.Pipeliner) error {
+						pipe.Set(ctx, userKnowledgeKey, userIDsJSON, 0)
+						return nil
+					},
+				)
+				return err
+			}, userKnowledgeKey,
+		)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf(
+		"failed to save user knowledge ids %s: changed concurrently %d times", userKnowledgeKey,
+		maxKnowledgeUpdateRetries,
+	)
+}
+
+func toKnowledgeDocument(
+	documentID, userID uuid.UUID,
+	documentInt knowledgeDocumentInternal,
+) model.KnowledgeDocument {
+	return model.KnowledgeDocument{
+		DocumentID:  documentID,
+		UserID:      userID,
+		Title:       documentInt.Title,
+		ChunksCount: len(documentInt.Chunks),
+		CreatedAt:   documentInt.CreatedAt,
+	}
+}
+
+func getKnowledgeDocumentKey(documentID uuid.UUID) string {
+	return fmt.Sprintf("kb_document_%v", documentID.String())
+}
+
+func getUserKnowledgeKey(userID uuid.UUID) string {
+	return fmt.Sprintf("user_kb_%v", userID.String())
+}
@@ -0,0 +1,141 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+type paymentInternal struct {
+	ChargeID   string         `json:"charge_id"`
+	TelegramID int64          `json:"telegram_id"`
+	ProductID  string         `json:"product_id"`
+	Stars      int            `json:"stars"`
+	Role       model.UserRole `json:"role,omitempty"`
+	Duration   time.Duration  `json:"duration,omitempty"`
+	Tokens     int            `json:"tokens,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// PaymentStorage keeps payments by charge IDs and lists of charge IDs of users. Refunds are marked in separate
+// keys, so a payment is refunded once.
+type PaymentStorage struct {
+	rdb *redis.Client
+}
+
+func NewPaymentStorage(rdb *redis.Client) *PaymentStorage {
+	return &PaymentStorage{
+		rdb: rdb,
+	}
+}
+
+// CreatePayment saves the payment if it wasn't saved yet. It returns false for repeated payments, Telegram may
+// send the same update again.
+func (p *PaymentStorage) CreatePayment(ctx context.Context, payment model.Payment) (bool, error) {
+	paymentJSON, err := json.Marshal(
+		paymentInternal{
+			ChargeID:   payment.ChargeID,
+			TelegramID: payment.TelegramID,
+			ProductID:  payment.ProductID,
+			Stars:      payment.Stars,
+			Role:       payment.Role,
+			Duration:   payment.Duration,
+			Tokens:     payment.Tokens,
+			CreatedAt:  payment.CreatedAt,
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal payment: %w", err)
+	}
+	created, err := p.rdb.SetNX(ctx, getPaymentKey(payment.ChargeID), paymentJSON, 0).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to save payment %s: %w", payment.ChargeID, err)
+	}
+	if !created {
+		return false, nil
+	}
+	if err = p.rdb.RPush(ctx, getUserPaymentsKey(payment.TelegramID), payment.ChargeID).Err(); err != nil {
+		return true, fmt.Errorf("failed to add payment %s to user payments: %w", payment.ChargeID, err)
+	}
+	return true, nil
+}
+
+func (p *PaymentStorage) GetPayment(ctx context.Context, chargeID string) (model.Payment, error) {
+	values, err := p.rdb.MGet(ctx, getPaymentKey(chargeID), getPaymentRefundedKey(chargeID)).Result()
+	if err != nil {
+		return model.Payment{}, fmt.Errorf("failed to get payment %s: %w", chargeID, err)
+	}
+	paymentRaw, ok := values[0].(string)
+	if !ok {
+		return model.Payment{}, model.ErrPaymentDoesNotExist
+	}
+	var payment paymentInternal
+	if err = json.Unmarshal([]byte(paymentRaw), &payment); err != nil {
+		return model.Payment{}, fmt.Errorf("failed to unmarshal payment %s: %w", chargeID, err)
+	}
+	var refundedAt time.Time
+	if refundedRaw, ok := values[1].(string); ok {
+		if refundedAt, err = time.Parse(time.RFC3339, refundedRaw); err != nil {
+			return model.Payment{}, fmt.Errorf("failed to parse refund time of payment %s: %w", chargeID, err)
+		}
+	}
+	return model.Payment{
+		ChargeID:   payment.ChargeID,
+		TelegramID: payment.TelegramID,
+		ProductID:  payment.ProductID,
+		Stars:      payment.Stars,
+		Role:       payment.Role,
+		Duration:   payment.Duration,
+		Tokens:     payment.Tokens,
+		CreatedAt:  payment.CreatedAt,
+		RefundedAt: refundedAt,
+	}, nil
+}
+
+// ListUserPayments returns payments of the Telegram user from the oldest one.
+func (p *PaymentStorage) ListUserPayments(ctx context.Context, telegramID int64) ([]model.Payment, error) {
+	chargeIDs, err := p.rdb.LRange(ctx, getUserPaymentsKey(telegramID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payments of %v: %w", telegramID, err)
+	}
+	payments := make([]model.Payment, 0, len(chargeIDs))
+	for _, chargeID := range chargeIDs {
+		payment, err := p.GetPayment(ctx, chargeID)
+		if err != nil {
+			if errors.Is(err, model.ErrPaymentDoesNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
+
+// MarkPaymentRefunded returns false if the payment was already marked.
+func (p *PaymentStorage) MarkPaymentRefunded(ctx context.Context, chargeID string, refundedAt time.Time) (
+	bool,
+	error,
+) {
+	marked, err := p.rdb.SetNX(ctx, getPaymentRefundedKey(chargeID), refundedAt.Format(time.RFC3339), 0).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark payment %s refunded: %w", chargeID, err)
+	}
+	return marked, nil
+}
+
+func getPaymentKey(chargeID string) string {
+	return fmt.Sprintf("payment_%s", chargeID)
+}
+
+func getPaymentRefundedKey(chargeID string) string {
+	return fmt.Sprintf("payment_refunded_%s", chargeID)
+}
+
+func getUserPaymentsKey(telegramID int64) string {
+	return fmt.Sprintf("payments_%d", telegramID)
+}
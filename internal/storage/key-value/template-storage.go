@@ -0,0 +1,110 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"slices"
+)
+
+type templateInternal struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+type userTemplates struct {
+	Templates []templateInternal `json:"templates"`
+}
+
+type TemplateStorage struct {
+	rdb *redis.Client
+}
+
+func NewTemplateStorage(rdb *redis.Client) *TemplateStorage {
+	return &TemplateStorage{
+		rdb: rdb,
+	}
+}
+
+// SaveTemplate creates the template or replaces the text of the existing one with the same name.
+func (t *TemplateStorage) SaveTemplate(ctx context.Context, userID uuid.UUID, name, text string) error {
+	templates, err := t.getUserTemplates(ctx, userID)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(
+		templates.Templates, func(template templateInternal) bool {
+			return template.Name == name
+		},
+	)
+	if index < 0 {
+		templates.Templates = append(templates.Templates, templateInternal{Name: name, Text: text})
+	} else {
+		templates.Templates[index].Text = text
+	}
+	return t.setUserTemplates(ctx, userID, templates)
+}
+
+func (t *TemplateStorage) ListTemplates(ctx context.Context, userID uuid.UUID) ([]model.PromptTemplate, error) {
+	templates, err := t.getUserTemplates(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]model.PromptTemplate, 0, len(templates.Templates))
+	for _, template := range templates.Templates {
+		result = append(result, model.PromptTemplate{Name: template.Name, Text: template.Text})
+	}
+	return result, nil
+}
+
+func (t *TemplateStorage) DeleteTemplate(ctx context.Context, userID uuid.UUID, name string) error {
+	templates, err := t.getUserTemplates(ctx, userID)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(
+		templates.Templates, func(template templateInternal) bool {
+			return template.Name == name
+		},
+	)
+	if index < 0 {
+		return model.ErrPromptTemplateDoesNotExist
+	}
+	templates.Templates = slices.Delete(templates.Templates, index, index+1)
+	return t.setUserTemplates(ctx, userID, templates)
+}
+
+func (t *TemplateStorage) getUserTemplates(ctx context.Context, userID uuid.UUID) (userTemplates, error) {
+	templatesRaw, err := t.rdb.Get(ctx, getUserTemplatesKey(userID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return userTemplates{Templates: make([]templateInternal, 0)}, nil
+		}
+		return userTemplates{}, fmt.Errorf("failed to get user templates %s: %w", userID, err)
+	}
+	var templates userTemplates
+	if err = json.Unmarshal([]byte(templatesRaw), &templates); err != nil {
+		return userTemplates{}, fmt.Errorf("failed to unmarshal user templates %s: %w", userID, err)
+	}
+	return templates, nil
+}
+
+func (t *TemplateStorage) setUserTemplates(ctx context.Context, userID uuid.UUID, templates userTemplates) error {
+	templatesJSON, err := json.Marshal(templates)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user templates: %w", err)
+	}
+	templatesKey := getUserTemplatesKey(userID)
+	if err = t.rdb.Set(ctx, templatesKey, templatesJSON, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save user templates %s: %w", templatesKey, err)
+	}
+	return nil
+}
+
+func getUserTemplatesKey(userID uuid.UUID) string {
+	return fmt.Sprintf("user_templates_%v", userID.String())
+}
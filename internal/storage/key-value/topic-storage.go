@@ -0,0 +1,134 @@
+package key_value
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+type topicInternal struct {
+	AIChatID string `json:"ai_chat_id,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Persona  string `json:"persona,omitempty"`
+}
+
+// maxTopicUpdateRetries limits attempts to update a topic changed concurrently, see updateTopic.
+const maxTopicUpdateRetries = 10
+
+type TopicStorage struct {
+	rdb *redis.Client
+}
+
+func NewTopicStorage(rdb *redis.Client) *TopicStorage {
+	return &TopicStorage{
+		rdb: rdb,
+	}
+}
+
+// GetTopic returns the topic, unknown topics are returned without AI chat and binding.
+func (t *TopicStorage) GetTopic(ctx context.Context, chatID int64, threadID int) (model.Topic, error) {
+	topicInt, err := t.getTopicInt(ctx, chatID, threadID)
+	if err != nil {
+		return model.Topic{}, err
+	}
+	topic := model.Topic{
+		ChatID:   chatID,
+		ThreadID: threadID,
+		Model:    topicInt.Model,
+		Persona:  topicInt.Persona,
+	}
+	if len(topicInt.AIChatID) != 0 {
+		if topic.AIChatID, err = uuid.Parse(topicInt.AIChatID); err != nil {
+			return model.Topic{}, fmt.Errorf("failed to parse topic ai chat id %s: %w", topicInt.AIChatID, err)
+		}
+	}
+	return topic, nil
+}
+
+func (t *TopicStorage) UpdateTopicAIChat(ctx context.Context, chatID int64, threadID int, aiChatID uuid.UUID) error {
+	return t.updateTopic(
+		ctx, chatID, threadID, func(topicInt *topicInternal) {
+			topicInt.AIChatID = aiChatID.String()
+		},
+	)
+}
+
+func (t *TopicStorage) UpdateTopicBinding(
+	ctx context.Context,
+	chatID int64,
+	threadID int,
+	aiModel string,
+	persona string,
+) error {
+	return t.updateTopic(
+		ctx, chatID, threadID, func(topicInt *topicInternal) {
+			topicInt.Model = aiModel
+			topicInt.Persona = persona
+		},
+	)
+}
+
+// updateTopic applies the update to the topic in a transaction watching the topic key, so concurrent updates of
+// its chat and binding aren't lost. The update is retried if the topic is changed meanwhile.
+func (t *TopicStorage) updateTopic(
+	ctx context.Context,
+	chatID int64,
+	threadID int,
+	update func(topicInt *topicInternal),
+) error {
+	topicKey := getTopicKey(chatID, threadID)
+	for range maxTopicUpdateRetries {
+		err := t.rdb.Watch(
+			ctx, func(tx *redis.Tx) error {
+				topicInt, err := readTopic(ctx, tx, chatID, threadID)
+				if err != nil {
+					return err
+				}
+				update(&topicInt)
+				topicJSON, err := json.Marshal(topicInt)
+				if err != nil {
+					return fmt.Errorf("failed to marshal topic: %w", err)
+				}
+				_, err = tx.TxPipelined(
+					ctx, func(pipe redis.Pipeliner) error {
+						pipe.Set(ctx, topicKey, topicJSON, 0)
+						return nil
+					},
+				)
+				return err
+			}, topicKey,
+		)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to save topic %s: changed concurrently %d times", topicKey, maxTopicUpdateRetries)
+}
+
+func (t *TopicStorage) getTopicInt(ctx context.Context, chatID int64, threadID int) (topicInternal, error) {
+	return readTopic(ctx, t.rdb, chatID, threadID)
+}
+
+func readTopic(ctx context.Context, rdb redis.Cmdable, chatID int64, threadID int) (topicInternal, error) {
+	topicKey := getTopicKey(chatID, threadID)
+	topicRaw, err := rdb.Get(ctx, topicKey).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return topicInternal{}, nil
+		}
+		return topicInternal{}, fmt.Errorf("failed to get topic %s: %w", topicKey, err)
+	}
+	var topicInt topicInternal
+	if err = json.Unmarshal([]byte(topicRaw), &topicInt); err != nil {
+		return topicInternal{}, fmt.Errorf("failed to unmarshal topic %s: %w", topicKey, err)
+	}
+	return topicInt, nil
+}
+
+func getTopicKey(chatID int64, threadID int) string {
+	return fmt.Sprintf("telegram_topic_%v_%v", chatID, threadID)
+}
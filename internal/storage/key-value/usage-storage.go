@@ -0,0 +1,232 @@
+package key_value
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/redis/go-redis/v9"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	usageTotalPromptTokensField     = "prompt_tokens"
+	usageTotalCompletionTokensField = "completion_tokens"
+	usageTotalCostField             = "cost"
+)
+
+// UsageStorage counts usage of users in separate keys for every bucket of rolling periods, the usage of a period
+// is the sum of its buckets. Keys expire when their buckets leave the period. Usage for all time and bought token
+// credits are kept without expiry.
+type UsageStorage struct {
+	rdb *redis.Client
+}
+
+func NewUsageStorage(rdb *redis.Client) *UsageStorage {
+	return &UsageStorage{
+		rdb: rdb,
+	}
+}
+
+// IncrementRequests adds a request to the last of the buckets and returns requests of every bucket.
+func (u *UsageStorage) IncrementRequests(
+	ctx context.Context,
+	userID uuid.UUID,
+	buckets []time.Time,
+	ttl time.Duration,
+) ([]int, error) {
+	keys := getUsageBucketKeys(getUsageRequestsKey, userID, buckets)
+	key := keys[len(keys)-1]
+	var requests *redis.SliceCmd
+	_, err := u.rdb.TxPipelined(
+		ctx, func(pipe redis.Pipeliner) error {
+			pipe.Incr(ctx, key)
+			pipe.Expire(ctx, key, ttl)
+			requests = pipe.MGet(ctx, keys...)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to increment requests of user %s: %w", userID, err)
+	}
+	counters, err := parseUsageCounters(requests.Val())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse requests of user %s: %w", userID, err)
+	}
+	return toInts(counters), nil
+}
+
+// DecrementRequests returns the request refused after IncrementRequests.
+func (u *UsageStorage) DecrementRequests(ctx context.Context, userID uuid.UUID, bucket time.Time) error {
+	if err := u.rdb.Decr(ctx, getUsageRequestsKey(userID, bucket)).Err(); err != nil {
+		return fmt.Errorf("failed to decrement requests of user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// AddUsage adds tokens and cost to the last of the buckets and to the usage for all time. Tokens of every bucket
+// are returned.
+func (u *UsageStorage) AddUsage(
+	ctx context.Context,
+	userID uuid.UUID,
+	buckets []time.Time,
+	usage model.Usage,
+	ttl time.Duration,
+) ([]int, error) {
+	tokensKeys := getUsageBucketKeys(getUsageTokensKey, userID, buckets)
+	tokensKey := tokensKeys[len(tokensKeys)-1]
+	spendKey := getUsageSpendKey(userID, buckets[len(buckets)-1])
+	totalKey := getUsageTotalKey(userID)
+	var tokens *redis.SliceCmd
+	_, err := u.rdb.TxPipelined(
+		ctx, func(pipe redis.Pipeliner) error {
+			pipe.IncrBy(ctx, tokensKey, int64(usage.TotalTokens()))
+			pipe.IncrByFloat(ctx, spendKey, usage.Cost)
+			pipe.Expire(ctx, tokensKey, ttl)
+			pipe.Expire(ctx, spendKey, ttl)
+			pipe.HIncrBy(ctx, totalKey, usageTotalPromptTokensField, int64(usage.PromptTokens))
+			pipe.HIncrBy(ctx, totalKey, usageTotalCompletionTokensField, int64(usage.CompletionTokens))
+			pipe.HIncrByFloat(ctx, totalKey, usageTotalCostField, usage.Cost)
+			tokens = pipe.MGet(ctx, tokensKeys...)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add usage of user %s: %w", userID, err)
+	}
+	counters, err := parseUsageCounters(tokens.Val())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tokens of user %s: %w", userID, err)
+	}
+	return toInts(counters), nil
+}
+
+// AddCredits adds tokens to the credits of the user and returns the credits. Negative tokens are taken from the
+// credits.
+func (u *UsageStorage) AddCredits(ctx context.Context, userID uuid.UUID, tokens int) (int, error) {
+	credits, err := u.rdb.IncrBy(ctx, getUsageCreditsKey(userID), int64(tokens)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to add credits of user %s: %w", userID, err)
+	}
+	return int(credits), nil
+}
+
+func (u *UsageStorage) GetCredits(ctx context.Context, userID uuid.UUID) (int, error) {
+	credits, err := u.rdb.Get(ctx, getUsageCreditsKey(userID)).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get credits of user %s: %w", userID, err)
+	}
+	return credits, nil
+}
+
+// GetUsage returns requests of the request buckets, tokens and spend of the spend buckets.
+func (u *UsageStorage) GetUsage(ctx context.Context, userID uuid.UUID, requestBuckets, spendBuckets []time.Time) (
+	[]int,
+	[]int,
+	[]float64,
+	error,
+) {
+	keys := slices.Concat(
+		getUsageBucketKeys(getUsageRequestsKey, userID, requestBuckets),
+		getUsageBucketKeys(getUsageTokensKey, userID, spendBuckets),
+		getUsageBucketKeys(getUsageSpendKey, userID, spendBuckets),
+	)
+	values, err := u.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get usage of user %s: %w", userID, err)
+	}
+	counters, err := parseUsageCounters(values)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse usage of user %s: %w", userID, err)
+	}
+	requests := counters[:len(requestBuckets)]
+	tokens := counters[len(requestBuckets) : len(requestBuckets)+len(spendBuckets)]
+	spend := counters[len(requestBuckets)+len(spendBuckets):]
+	return toInts(requests), toInts(tokens), spend, nil
+}
+
+func (u *UsageStorage) GetTotalUsage(ctx context.Context, userID uuid.UUID) (model.Usage, error) {
+	fields, err := u.rdb.HGetAll(ctx, getUsageTotalKey(userID)).Result()
+	if err != nil {
+		return model.Usage{}, fmt.Errorf("failed to get total usage of user %s: %w", userID, err)
+	}
+	var usage model.Usage
+	if value, ok := fields[usageTotalPromptTokensField]; ok {
+		if usage.PromptTokens, err = strconv.Atoi(value); err != nil {
+			return model.Usage{}, fmt.Errorf("failed to parse prompt tokens of user %s: %w", userID, err)
+		}
+	}
+	if value, ok := fields[usageTotalCompletionTokensField]; ok {
+		if usage.CompletionTokens, err = strconv.Atoi(value); err != nil {
+			return model.Usage{}, fmt.Errorf("failed to parse completion tokens of user %s: %w", userID, err)
+		}
+	}
+	if value, ok := fields[usageTotalCostField]; ok {
+		if usage.Cost, err = strconv.ParseFloat(value, 64); err != nil {
+			return model.Usage{}, fmt.Errorf("failed to parse cost of user %s: %w", userID, err)
+		}
+	}
+	return usage, nil
+}
+
+// parseUsageCounters parses values of counter keys, missing keys are zero.
+func parseUsageCounters(values []interface{}) ([]float64, error) {
+	counters := make([]float64, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return nil, errors.New("unexpected usage counter type")
+		}
+		counter, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse usage counter %s: %w", str, err)
+		}
+		counters[i] = counter
+	}
+	return counters, nil
+}
+
+func toInts(counters []float64) []int {
+	ints := make([]int, 0, len(counters))
+	for _, counter := range counters {
+		ints = append(ints, int(counter))
+	}
+	return ints
+}
+
+func getUsageBucketKeys(getKey func(uuid.UUID, time.Time) string, userID uuid.UUID, buckets []time.Time) []string {
+	keys := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		keys = append(keys, getKey(userID, bucket))
+	}
+	return keys
+}
+
+func getUsageRequestsKey(userID uuid.UUID, bucket time.Time) string {
+	return fmt.Sprintf("usage_requests_%s_%d", userID, bucket.Unix())
+}
+
+func getUsageTokensKey(userID uuid.UUID, bucket time.Time) string {
+	return fmt.Sprintf("usage_tokens_%s_%d", userID, bucket.Unix())
+}
+
+func getUsageSpendKey(userID uuid.UUID, bucket time.Time) string {
+	return fmt.Sprintf("usage_spend_%s_%d", userID, bucket.Unix())
+}
+
+func getUsageTotalKey(userID uuid.UUID) string {
+	return fmt.Sprintf("usage_total_%s", userID)
+}
+
+func getUsageCreditsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("usage_credits_%s", userID)
+}
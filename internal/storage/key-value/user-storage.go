@@ -8,18 +8,68 @@ import (
 	"github.com/google/uuid"
 	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
 	"github.com/redis/go-redis/v9"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	ErrUserAlreadyExists = errors.New("userInternal already exists")
-	ErrUserDoesNotExists = errors.New("userInternal doesn't exists")
+	ErrUserDoesNotExists = model.ErrUserDoesNotExist
 )
 
+// roleExpiriesKey is a sorted set of IDs of users having roles given for a time, scored by the earliest expiry.
+const roleExpiriesKey = "role_expiries"
+
+const telegramUserKeyPrefix = "telegram_user_"
+
+// configuredRolesKey is a hash of roles given by the environment lists, fields are Telegram user IDs.
+const configuredRolesKey = "configured_roles"
+
+// maxUserUpdateRetries limits attempts to update a user changed concurrently, see updateUser.
+const maxUserUpdateRetries = 10
+
+// legacyTelegramKeyRegexp matches keys of users saved by Telegram chat ID, see MigrateTelegramKeys.
+var legacyTelegramKeyRegexp = regexp.MustCompile(`^telegram_(-?\d+)$`)
+
 type userInternal struct {
-	UserID     string           `json:"user_id"`
-	TelegramID int64            `json:"telegram_id"`
-	Roles      []model.UserRole `json:"roles"`
-	LastAIChat string           `json:"last_ai_chat"`
+	UserID        string                       `json:"user_id"`
+	TelegramID    int64                        `json:"telegram_id"`
+	Roles         storedRoles                  `json:"roles"`
+	LastAIChat    string                       `json:"last_ai_chat"`
+	Timezone      string                       `json:"timezone,omitempty"`
+	RoleExpiresAt map[model.UserRole]time.Time `json:"role_expires_at,omitempty"`
+}
+
+// storedRoles are saved as role names. Users saved before roles got names have role numbers, which are read as
+// the roles they stood for.
+type storedRoles []model.UserRole
+
+var legacyUserRoles = []model.UserRole{model.UserRoleDefault, model.UserRoleAdmin, model.UserRolePremium}
+
+func (r *storedRoles) UnmarshalJSON(data []byte) error {
+	var rawRoles []json.RawMessage
+	if err := json.Unmarshal(data, &rawRoles); err != nil {
+		return err
+	}
+	roles := make(storedRoles, 0, len(rawRoles))
+	for _, rawRole := range rawRoles {
+		var role model.UserRole
+		if err := json.Unmarshal(rawRole, &role); err == nil {
+			roles = append(roles, role)
+			continue
+		}
+		var legacyRole int
+		if err := json.Unmarshal(rawRole, &legacyRole); err != nil {
+			return fmt.Errorf("failed to unmarshal role %s: %w", rawRole, err)
+		}
+		if legacyRole < 0 || legacyRole >= len(legacyUserRoles) {
+			return fmt.Errorf("unknown role number %d", legacyRole)
+		}
+		roles = append(roles, legacyUserRoles[legacyRole])
+	}
+	*r = roles
+	return nil
 }
 
 type UserStorage struct {
@@ -37,42 +87,90 @@ func (u *UserStorage) CreateNewTelegramUser(
 	userTelegramID int64,
 	roles []model.UserRole,
 ) (uuid.UUID, error) {
-	userTelegramIDKey := getUserTelegramIDKey(userTelegramID)
-	_, err := u.rdb.Get(ctx, userTelegramIDKey).Result()
-	if err != nil {
-		if !errors.Is(err, redis.Nil) {
-			return uuid.Nil, fmt.Errorf("failed to get userInternal %s: %w", userTelegramIDKey, err)
-		}
-	} else {
-		return uuid.Nil, ErrUserAlreadyExists
-	}
-	userID := uuid.New()
-	if err = u.rdb.Set(ctx, userTelegramIDKey, userID.String(), 0).Err(); err != nil {
-		return uuid.Nil, fmt.Errorf("failed to save userInternal %s: %w", userID, err)
-	}
+	return u.createUser(ctx, getTelegramUserKey(userTelegramID), userTelegramID, roles)
+}
+
+// CreateNewTelegramChat creates the user owning AI chats of the Telegram group chat. The user has no roles,
+// roles of the message sender are used instead.
+func (u *UserStorage) CreateNewTelegramChat(ctx context.Context, chatTelegramID int64) (uuid.UUID, error) {
+	return u.createUser(ctx, getTelegramChatKey(chatTelegramID), chatTelegramID, nil)
+}
 
+// createUser saves the user before the key of its Telegram ID, so the key always points to a saved user. If
+// messages of a new user are handled concurrently, the first created user is returned to every caller.
+func (u *UserStorage) createUser(
+	ctx context.Context,
+	telegramIDKey string,
+	telegramID int64,
+	roles []model.UserRole,
+) (uuid.UUID, error) {
+	userID := uuid.New()
 	user := userInternal{
-		TelegramID: userTelegramID,
+		TelegramID: telegramID,
 		UserID:     userID.String(),
 		Roles:      roles,
 	}
-	err = u.setUser(ctx, userID, user)
-	if err != nil {
+	if err := u.setUser(ctx, userID, user); err != nil {
 		return uuid.Nil, fmt.Errorf("failed to set user: %w", err)
 	}
-	return userID, nil
-}
 
-func (u *UserStorage) UpdateUserLastAIChat(ctx context.Context, userID uuid.UUID, aiChatID uuid.UUID) error {
-	user, err := u.getUser(ctx, userID)
+	created, err := u.rdb.SetNX(ctx, telegramIDKey, userID.String(), 0).Result()
 	if err != nil {
-		return fmt.Errorf("failed to get user: %w", err)
+		return uuid.Nil, fmt.Errorf("failed to save userInternal %s: %w", userID, err)
 	}
-	user.LastAIChat = aiChatID.String()
-	if err = u.setUser(ctx, userID, user); err != nil {
-		return fmt.Errorf("failed to set user: %w", err)
+	if created {
+		return userID, nil
 	}
-	return nil
+	if err = u.rdb.Del(ctx, getUserIDKey(userID)).Err(); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to delete duplicate userInternal %s: %w", userID, err)
+	}
+	existingUserID, err := u.getUserID(ctx, telegramIDKey)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get created userInternal %s: %w", telegramIDKey, err)
+	}
+	return existingUserID, nil
+}
+
+func (u *UserStorage) UpdateUserLastAIChat(ctx context.Context, userID uuid.UUID, aiChatID uuid.UUID) error {
+	return u.updateUser(
+		ctx, userID, func(user *userInternal) error {
+			user.LastAIChat = aiChatID.String()
+			return nil
+		},
+	)
+}
+
+// UpdateUserRoles replaces roles of the user with their expiry returned by the update of the saved ones. The update
+// is run again if the user is changed concurrently, an error of the update is returned as is.
+func (u *UserStorage) UpdateUserRoles(
+	ctx context.Context,
+	userID uuid.UUID,
+	update func(roles []model.UserRole, roleExpiresAt map[model.UserRole]time.Time) (
+		[]model.UserRole,
+		map[model.UserRole]time.Time,
+		error,
+	),
+) error {
+	return u.updateUser(
+		ctx, userID, func(user *userInternal) error {
+			roles, roleExpiresAt, err := update(user.Roles, user.RoleExpiresAt)
+			if err != nil {
+				return err
+			}
+			user.Roles = roles
+			user.RoleExpiresAt = roleExpiresAt
+			return nil
+		},
+	)
+}
+
+func (u *UserStorage) UpdateUserTimezone(ctx context.Context, userID uuid.UUID, timezone string) error {
+	return u.updateUser(
+		ctx, userID, func(user *userInternal) error {
+			user.Timezone = timezone
+			return nil
+		},
+	)
 }
 
 func (u *UserStorage) GetUserInfo(ctx context.Context, userID uuid.UUID) (model.User, error) {
@@ -89,23 +187,184 @@ func (u *UserStorage) GetUserInfo(ctx context.Context, userID uuid.UUID) (model.
 	}
 
 	user := model.User{
-		TelegramID: userInt.TelegramID,
-		UserID:     userID,
-		LastAIChat: lastAIChat,
-		Roles:      userInt.Roles,
+		TelegramID:    userInt.TelegramID,
+		UserID:        userID,
+		LastAIChat:    lastAIChat,
+		Roles:         userInt.Roles,
+		Timezone:      userInt.Timezone,
+		RoleExpiresAt: userInt.RoleExpiresAt,
 	}
 	return user, nil
 }
 
 func (u *UserStorage) GetUserIDForTelegramUser(ctx context.Context, userTelegramID int64) (uuid.UUID, error) {
-	userTelegramIDKey := getUserTelegramIDKey(userTelegramID)
-	userIDStr, err := u.rdb.Get(ctx, userTelegramIDKey).Result()
+	userID, err := u.getUserID(ctx, getTelegramUserKey(userTelegramID))
+	if errors.Is(err, redis.Nil) {
+		return uuid.Nil, model.ErrTelegramUserDoesNotExists
+	}
+	return userID, err
+}
+
+func (u *UserStorage) GetUserIDForTelegramChat(ctx context.Context, chatTelegramID int64) (uuid.UUID, error) {
+	userID, err := u.getUserID(ctx, getTelegramChatKey(chatTelegramID))
+	if errors.Is(err, redis.Nil) {
+		return uuid.Nil, model.ErrTelegramChatDoesNotExist
+	}
+	return userID, err
+}
+
+// ListUsersWithExpiringRoles returns IDs of users having roles which expire until the time.
+func (u *UserStorage) ListUsersWithExpiringRoles(ctx context.Context, until time.Time) ([]uuid.UUID, error) {
+	members, err := u.rdb.ZRangeByScore(
+		ctx, roleExpiriesKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(until.Unix(), 10),
+		},
+	).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users with expiring roles: %w", err)
+	}
+	userIDs := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		userID, err := uuid.Parse(member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse userID %s: %w", member, err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// RemoveRoleExpiries removes the user from users having roles given for a time, e.g. when the user is deleted.
+func (u *UserStorage) RemoveRoleExpiries(ctx context.Context, userID uuid.UUID) error {
+	if err := u.rdb.ZRem(ctx, roleExpiriesKey, userID.String()).Err(); err != nil {
+		return fmt.Errorf("failed to remove role expiries of user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// MarkRoleExpiryNotified returns false if the user was already notified about this expiry of the role. Extended
+// roles have new expiry, so the user is notified again.
+func (u *UserStorage) MarkRoleExpiryNotified(
+	ctx context.Context,
+	userID uuid.UUID,
+	role model.UserRole,
+	expiresAt time.Time,
+) (bool, error) {
+	key := fmt.Sprintf("role_expiry_notified_%s_%s_%d", userID, role, expiresAt.Unix())
+	marked, err := u.rdb.SetNX(ctx, key, 1, time.Until(expiresAt)+24*time.Hour).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark role %s expiry of user %s notified: %w", role, userID, err)
+	}
+	return marked, nil
+}
+
+// GetConfiguredRoles returns roles given to Telegram users by the environment lists on the previous start.
+func (u *UserStorage) GetConfiguredRoles(ctx context.Context) (map[int64][]model.UserRole, error) {
+	fields, err := u.rdb.HGetAll(ctx, configuredRolesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configured roles: %w", err)
+	}
+	configuredRoles := make(map[int64][]model.UserRole, len(fields))
+	for field, rolesJSON := range fields {
+		telegramID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse telegram id %s of configured roles: %w", field, err)
+		}
+		var roles []model.UserRole
+		if err = json.Unmarshal([]byte(rolesJSON), &roles); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal configured roles of %v: %w", telegramID, err)
+		}
+		configuredRoles[telegramID] = roles
+	}
+	return configuredRoles, nil
+}
+
+// SetConfiguredRoles replaces roles given to Telegram users by the environment lists.
+func (u *UserStorage) SetConfiguredRoles(ctx context.Context, configuredRoles map[int64][]model.UserRole) error {
+	fields := make(map[string]any, len(configuredRoles))
+	for telegramID, roles := range configuredRoles {
+		rolesJSON, err := json.Marshal(roles)
+		if err != nil {
+			return fmt.Errorf("failed to marshal configured roles of %v: %w", telegramID, err)
+		}
+		fields[strconv.FormatInt(telegramID, 10)] = rolesJSON
+	}
+	_, err := u.rdb.TxPipelined(
+		ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, configuredRolesKey)
+			if len(fields) != 0 {
+				pipe.HSet(ctx, configuredRolesKey, fields)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set configured roles: %w", err)
+	}
+	return nil
+}
+
+// ListTelegramUsers returns IDs of all saved Telegram users, group chats aren't included.
+func (u *UserStorage) ListTelegramUsers(ctx context.Context) ([]int64, error) {
+	telegramIDs := make([]int64, 0)
+	iter := u.rdb.Scan(ctx, 0, telegramUserKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		telegramID, err := strconv.ParseInt(strings.TrimPrefix(key, telegramUserKeyPrefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse telegram id of %s: %w", key, err)
+		}
+		telegramIDs = append(telegramIDs, telegramID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan telegram users: %w", err)
+	}
+	return telegramIDs, nil
+}
+
+// MigrateTelegramKeys moves IDs of users saved by Telegram chat ID to separate keys of Telegram users and group
+// chats. It returns the number of moved keys and can be run on every start.
+func (u *UserStorage) MigrateTelegramKeys(ctx context.Context) (int, error) {
+	migrated := 0
+	iter := u.rdb.Scan(ctx, 0, "telegram_*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		match := legacyTelegramKeyRegexp.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		telegramID, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to parse telegram id of %s: %w", key, err)
+		}
+		// IDs of private chats are equal to IDs of their users, IDs of group chats are negative.
+		newKey := getTelegramUserKey(telegramID)
+		if telegramID < 0 {
+			newKey = getTelegramChatKey(telegramID)
+		}
+		renamed, err := u.rdb.RenameNX(ctx, key, newKey).Result()
+		if err != nil {
+			return migrated, fmt.Errorf("failed to rename %s to %s: %w", key, newKey, err)
+		}
+		if renamed {
+			migrated++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return migrated, fmt.Errorf("failed to scan telegram keys: %w", err)
+	}
+	return migrated, nil
+}
+
+// getUserID returns redis.Nil if there is no user for the key.
+func (u *UserStorage) getUserID(ctx context.Context, telegramIDKey string) (uuid.UUID, error) {
+	userIDStr, err := u.rdb.Get(ctx, telegramIDKey).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return uuid.Nil, model.ErrTelegramUserDoesNotExists
-		} else {
-			return uuid.Nil, fmt.Errorf("failed to get telegram user id %s: %w", userTelegramIDKey, err)
+			return uuid.Nil, err
 		}
+		return uuid.Nil, fmt.Errorf("failed to get telegram user id %s: %w", telegramIDKey, err)
 	}
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
@@ -115,8 +374,56 @@ func (u *UserStorage) GetUserIDForTelegramUser(ctx context.Context, userTelegram
 }
 
 func (u *UserStorage) getUser(ctx context.Context, userID uuid.UUID) (userInternal, error) {
+	return readUser(ctx, u.rdb, userID)
+}
+
+// setUser saves the user and keeps the user in the set of users with expiring roles while the user has them.
+func (u *UserStorage) setUser(
+	ctx context.Context, userID uuid.UUID, userInt userInternal,
+) error {
+	_, err := u.rdb.TxPipelined(
+		ctx, func(pipe redis.Pipeliner) error {
+			return writeUser(ctx, pipe, userID, userInt)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save userInternal %s: %w", userID, err)
+	}
+	return nil
+}
+
+// updateUser saves the user changed by the update. Users are saved as a whole, so the user is watched to keep
+// concurrent updates of different fields from overwriting each other, and the update is retried if the user changes.
+func (u *UserStorage) updateUser(ctx context.Context, userID uuid.UUID, update func(user *userInternal) error) error {
+	userIDKey := getUserIDKey(userID)
+	for range maxUserUpdateRetries {
+		err := u.rdb.Watch(
+			ctx, func(tx *redis.Tx) error {
+				user, err := readUser(ctx, tx, userID)
+				if err != nil {
+					return fmt.Errorf("failed to get user: %w", err)
+				}
+				if err = update(&user); err != nil {
+					return err
+				}
+				_, err = tx.TxPipelined(
+					ctx, func(pipe redis.Pipeliner) error {
+						return writeUser(ctx, pipe, userID, user)
+					},
+				)
+				return err
+			}, userIDKey,
+		)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to save userInternal %s: changed concurrently %d times", userID, maxUserUpdateRetries)
+}
+
+func readUser(ctx context.Context, rdb redis.Cmdable, userID uuid.UUID) (userInternal, error) {
 	userIDKey := getUserIDKey(userID)
-	userRaw, err := u.rdb.Get(ctx, userIDKey).Result()
+	userRaw, err := rdb.Get(ctx, userIDKey).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return userInternal{}, ErrUserDoesNotExists
@@ -131,22 +438,33 @@ func (u *UserStorage) getUser(ctx context.Context, userID uuid.UUID) (userIntern
 	return user, nil
 }
 
-func (u *UserStorage) setUser(
-	ctx context.Context, userID uuid.UUID, userInt userInternal,
-) error {
-	userIDKey := getUserIDKey(userID)
+// writeUser queues saving of the user and its earliest role expiry to the transaction pipe.
+func writeUser(ctx context.Context, pipe redis.Pipeliner, userID uuid.UUID, userInt userInternal) error {
 	newUserJSON, err := json.Marshal(userInt)
 	if err != nil {
 		return fmt.Errorf("failed to marshal internal user: %w", err)
 	}
-	if err = u.rdb.Set(ctx, userIDKey, newUserJSON, 0).Err(); err != nil {
-		return fmt.Errorf("failed to save userInternal %s: %w", userID, err)
+	var earliestExpiry time.Time
+	for _, expiresAt := range userInt.RoleExpiresAt {
+		if earliestExpiry.IsZero() || expiresAt.Before(earliestExpiry) {
+			earliestExpiry = expiresAt
+		}
+	}
+	pipe.Set(ctx, getUserIDKey(userID), newUserJSON, 0)
+	if earliestExpiry.IsZero() {
+		pipe.ZRem(ctx, roleExpiriesKey, userID.String())
+	} else {
+		pipe.ZAdd(ctx, roleExpiriesKey, redis.Z{Score: float64(earliestExpiry.Unix()), Member: userID.String()})
 	}
 	return nil
 }
 
-func getUserTelegramIDKey(id int64) string {
-	return fmt.Sprintf("telegram_%d", id)
+func getTelegramUserKey(id int64) string {
+	return fmt.Sprintf("%s%d", telegramUserKeyPrefix, id)
+}
+
+func getTelegramChatKey(id int64) string {
+	return fmt.Sprintf("telegram_chat_%d", id)
 }
 
 func getUserIDKey(id uuid.UUID) string {
@@ -0,0 +1,125 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/calc"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/units"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NameCalculator   = "calculator"
+	NameCurrentTime  = "current_time"
+	NameConvertUnits = "convert_units"
+)
+
+// Builtin returns the offline tools which are safe to allow for every role.
+func Builtin() []Tool {
+	return []Tool{
+		Calculator(),
+		CurrentTime(),
+		ConvertUnits(),
+	}
+}
+
+func Calculator() Tool {
+	return Tool{
+		Name: NameCalculator,
+		Description: "Evaluate an arithmetic expression. Supports + - * / % ^, parentheses, pi, e and functions " +
+			"sqrt, abs, sin, cos, tan, asin, acos, atan, ln, log, log2, exp, floor, ceil, round. " +
+			"Always use it instead of calculating in mind.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"expression": {"type": "string", "description": "Expression to evaluate, e.g. (2+3)*sqrt(16)"}
+			},
+			"required": ["expression"]
+		}`),
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("failed to parse arguments: %w", err)
+			}
+			result, err := calc.Eval(args.Expression)
+			if err != nil {
+				return "", err
+			}
+			return formatNumber(result), nil
+		},
+	}
+}
+
+func CurrentTime() Tool {
+	return Tool{
+		Name:        NameCurrentTime,
+		Description: "Get the current date, time and weekday in the user's timezone or in the given timezone.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"timezone": {"type": "string", "description": "Optional IANA timezone, e.g. Europe/Berlin"}
+			}
+		}`),
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+			if len(strings.TrimSpace(arguments)) != 0 {
+				if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+					return "", fmt.Errorf("failed to parse arguments: %w", err)
+				}
+			}
+			location := LocationFromContext(ctx)
+			if len(args.Timezone) != 0 {
+				var err error
+				if location, err = time.LoadLocation(args.Timezone); err != nil {
+					return "", fmt.Errorf("unknown timezone %s", args.Timezone)
+				}
+			}
+			now := time.Now().In(location)
+			return fmt.Sprintf("%s, %s (%s)", now.Format(time.RFC3339), now.Weekday(), location), nil
+		},
+	}
+}
+
+func ConvertUnits() Tool {
+	return Tool{
+		Name: NameConvertUnits,
+		Description: "Convert a value between units of length, mass, volume, area, time, speed, data, pressure, " +
+			"energy or temperature. Supported units: " + strings.Join(units.Names(), ", ") + ".",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"value": {"type": "number"},
+				"from": {"type": "string", "description": "Unit to convert from, e.g. km"},
+				"to": {"type": "string", "description": "Unit to convert to, e.g. mi"}
+			},
+			"required": ["value", "from", "to"]
+		}`),
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Value float64 `json:"value"`
+				From  string  `json:"from"`
+				To    string  `json:"to"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("failed to parse arguments: %w", err)
+			}
+			result, err := units.Convert(args.Value, args.From, args.To)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s %s = %s %s", formatNumber(args.Value), args.From, formatNumber(result), args.To), nil
+		},
+	}
+}
+
+// formatNumber hides float rounding errors, e.g. 211.99999999999994 is formatted as 212.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', 12, 64)
+}
@@ -0,0 +1,21 @@
+package tool
+
+import (
+	"context"
+	"time"
+)
+
+type locationKey struct{}
+
+// WithLocation returns a context with the time location of the user the tools are called for.
+func WithLocation(ctx context.Context, location *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, location)
+}
+
+// LocationFromContext returns the user time location or UTC if it is not set.
+func LocationFromContext(ctx context.Context) *time.Location {
+	if location, ok := ctx.Value(locationKey{}).(*time.Location); ok && location != nil {
+		return location
+	}
+	return time.UTC
+}
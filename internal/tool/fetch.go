@@ -0,0 +1,193 @@
+package tool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/readability"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+const (
+	NameFetchURL = "fetch_url"
+
+	maxRedirects = 5
+)
+
+var (
+	ErrDomainNotAllowed     = errors.New("domain is not allowed")
+	ErrPrivateAddress       = errors.New("private network addresses are not allowed")
+	ErrUnsupportedURL       = errors.New("only http and https links are supported")
+	ErrUnsupportedContent   = errors.New("unsupported content type")
+	ErrResponseTooLarge     = errors.New("page is too large")
+	ErrTooManyRedirects     = errors.New("too many redirects")
+	ErrUnexpectedStatusCode = errors.New("unexpected status code")
+)
+
+// URLFetcher downloads web pages for the model with size and time limits and domain allow and deny lists.
+type URLFetcher struct {
+	cfg    config.FetchURL
+	client *http.Client
+}
+
+// NewURLFetcher creates a fetcher. Connections to private network addresses are refused on dial, after DNS
+// resolution, unless they are allowed in config.
+func NewURLFetcher(cfg config.FetchURL) *URLFetcher {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+	}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: cfg.Timeout,
+	}
+	fetcher := &URLFetcher{
+		cfg: cfg,
+	}
+	fetcher.client = &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return ErrTooManyRedirects
+			}
+			return fetcher.checkURL(req.URL)
+		},
+	}
+	return fetcher
+}
+
+func (f *URLFetcher) Tool() Tool {
+	return Tool{
+		Name: NameFetchURL,
+		Description: "Download a web page by its link and get its title and readable text. Use it when the user " +
+			"shares a link or asks about a page.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"url": {"type": "string", "description": "Absolute http or https link"}
+			},
+			"required": ["url"]
+		}`),
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("failed to parse arguments: %w", err)
+			}
+			page, err := f.Fetch(ctx, args.URL)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Title: %s\n\n%s", page.Title, page.Text), nil
+		},
+	}
+}
+
+// Fetch downloads the page and extracts its readable text. Text longer than the configured limit is cut.
+func (f *URLFetcher) Fetch(ctx context.Context, rawURL string) (readability.Page, error) {
+	pageURL, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return readability.Page{}, fmt.Errorf("failed to parse url: %w", err)
+	}
+	if err = f.checkURL(pageURL); err != nil {
+		return readability.Page{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return readability.Page{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9")
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return readability.Page{}, fmt.Errorf("failed to fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readability.Page{}, fmt.Errorf("%w: %s", ErrUnexpectedStatusCode, resp.Status)
+	}
+	if resp.ContentLength > f.cfg.MaxSize {
+		return readability.Page{}, ErrResponseTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxSize+1))
+	if err != nil {
+		return readability.Page{}, fmt.Errorf("failed to read page: %w", err)
+	}
+	if int64(len(body)) > f.cfg.MaxSize {
+		return readability.Page{}, ErrResponseTooLarge
+	}
+
+	var page readability.Page
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "":
+		if page, err = readability.Extract(bytes.NewReader(body)); err != nil {
+			return readability.Page{}, err
+		}
+	case "text/plain", "text/markdown":
+		page.Text = string(body)
+	default:
+		return readability.Page{}, fmt.Errorf("%w: %s", ErrUnsupportedContent, mediaType)
+	}
+
+	if text := []rune(page.Text); len(text) > f.cfg.MaxTextLength {
+		page.Text = string(text[:f.cfg.MaxTextLength]) + "\n[text truncated]"
+	}
+	return page, nil
+}
+
+func (f *URLFetcher) checkURL(pageURL *url.URL) error {
+	if pageURL.Scheme != "http" && pageURL.Scheme != "https" {
+		return ErrUnsupportedURL
+	}
+	host := strings.ToLower(pageURL.Hostname())
+	for _, domain := range f.cfg.DeniedDomains {
+		if matchDomain(host, domain) {
+			return fmt.Errorf("%w: %s", ErrDomainNotAllowed, host)
+		}
+	}
+	if len(f.cfg.AllowedDomains) == 0 {
+		return nil
+	}
+	for _, domain := range f.cfg.AllowedDomains {
+		if matchDomain(host, domain) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDomainNotAllowed, host)
+}
+
+// matchDomain reports whether the host is the domain or its subdomain.
+func matchDomain(host, domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), "*.")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast()
+}
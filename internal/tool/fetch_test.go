@@ -0,0 +1,252 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPage = `<html><head><title>Test page</title></head><body><p>Hello from the test server.</p></body></html>`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/page", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, testPage)
+		},
+	)
+	mux.HandleFunc(
+		"/text", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = fmt.Fprint(w, strings.Repeat("a", 100))
+		},
+	)
+	mux.HandleFunc(
+		"/large", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = fmt.Fprint(w, strings.Repeat("a", 2048))
+		},
+	)
+	mux.HandleFunc(
+		"/large-chunked", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			// Flushed parts are sent without the content length.
+			for range 4 {
+				_, _ = fmt.Fprint(w, strings.Repeat("a", 512))
+				w.(http.Flusher).Flush()
+			}
+		},
+	)
+	mux.HandleFunc(
+		"/image", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = fmt.Fprint(w, "png")
+		},
+	)
+	mux.HandleFunc(
+		"/missing", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+	)
+	mux.HandleFunc(
+		"/redirect", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+		},
+	)
+	mux.HandleFunc(
+		"/loop", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		},
+	)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// localhostURL returns the link to the path of the test server by the localhost domain.
+func localhostURL(t *testing.T, server *httptest.Server, path string) string {
+	t.Helper()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server url: %v", err)
+	}
+	return fmt.Sprintf("http://localhost:%s%s", serverURL.Port(), path)
+}
+
+func testFetchConfig() config.FetchURL {
+	return config.FetchURL{
+		Timeout:              5 * time.Second,
+		MaxSize:              1024,
+		MaxTextLength:        1000,
+		UserAgent:            "test",
+		AllowPrivateNetworks: true,
+	}
+}
+
+func TestURLFetcherFetch(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		name      string
+		cfg       func(cfg *config.FetchURL)
+		path      string
+		wantTitle string
+		wantText  string
+		wantErr   error
+	}{
+		{
+			name:      "html page",
+			path:      "/page",
+			wantTitle: "Test page",
+			wantText:  "Hello from the test server.",
+		},
+		{
+			name:     "plain text",
+			path:     "/text",
+			wantText: strings.Repeat("a", 100),
+		},
+		{
+			name: "truncated text",
+			cfg: func(cfg *config.FetchURL) {
+				cfg.MaxTextLength = 10
+			},
+			path:     "/text",
+			wantText: strings.Repeat("a", 10) + "\n[text truncated]",
+		},
+		{
+			name: "allowed domain",
+			cfg: func(cfg *config.FetchURL) {
+				cfg.AllowedDomains = []string{"example.com", "localhost"}
+			},
+			path:      "/page",
+			wantTitle: "Test page",
+			wantText:  "Hello from the test server.",
+		},
+		{
+			name: "not allowed domain",
+			cfg: func(cfg *config.FetchURL) {
+				cfg.AllowedDomains = []string{"example.com"}
+			},
+			path:    "/page",
+			wantErr: ErrDomainNotAllowed,
+		},
+		{
+			name: "denied domain",
+			cfg: func(cfg *config.FetchURL) {
+				cfg.DeniedDomains = []string{"localhost"}
+			},
+			path:    "/page",
+			wantErr: ErrDomainNotAllowed,
+		},
+		{
+			name:    "too large",
+			path:    "/large",
+			wantErr: ErrResponseTooLarge,
+		},
+		{
+			name:    "too large without content length",
+			path:    "/large-chunked",
+			wantErr: ErrResponseTooLarge,
+		},
+		{
+			name:    "unsupported content",
+			path:    "/image",
+			wantErr: ErrUnsupportedContent,
+		},
+		{
+			name:    "unexpected status",
+			path:    "/missing",
+			wantErr: ErrUnexpectedStatusCode,
+		},
+		{
+			name:      "redirect",
+			path:      "/redirect?to=/page",
+			wantTitle: "Test page",
+			wantText:  "Hello from the test server.",
+		},
+		{
+			name: "redirect to denied domain",
+			cfg: func(cfg *config.FetchURL) {
+				cfg.DeniedDomains = []string{"denied.localhost"}
+			},
+			path:    "/redirect?to=http://denied.localhost/page",
+			wantErr: ErrDomainNotAllowed,
+		},
+		{
+			name:    "too many redirects",
+			path:    "/loop",
+			wantErr: ErrTooManyRedirects,
+		},
+		{
+			name: "private network",
+			cfg: func(cfg *config.FetchURL) {
+				cfg.AllowPrivateNetworks = false
+			},
+			path:    "/page",
+			wantErr: ErrPrivateAddress,
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				cfg := testFetchConfig()
+				if test.cfg != nil {
+					test.cfg(&cfg)
+				}
+				page, err := NewURLFetcher(cfg).Fetch(context.Background(), localhostURL(t, server, test.path))
+				if test.wantErr != nil {
+					if !errors.Is(err, test.wantErr) {
+						t.Fatalf("Fetch() error = %v, want %v", err, test.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Fetch() error = %v", err)
+				}
+				if page.Title != test.wantTitle {
+					t.Errorf("Fetch() title = %q, want %q", page.Title, test.wantTitle)
+				}
+				if page.Text != test.wantText {
+					t.Errorf("Fetch() text = %q, want %q", page.Text, test.wantText)
+				}
+			},
+		)
+	}
+}
+
+func TestURLFetcherFetchUnsupportedURL(t *testing.T) {
+	fetcher := NewURLFetcher(testFetchConfig())
+	for _, rawURL := range []string{"ftp://localhost/file", "file:///etc/passwd", "localhost/page"} {
+		if _, err := fetcher.Fetch(context.Background(), rawURL); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("Fetch(%q) error = %v, want %v", rawURL, err, ErrUnsupportedURL)
+		}
+	}
+}
+
+func TestMatchDomain(t *testing.T) {
+	tests := []struct {
+		host   string
+		domain string
+		want   bool
+	}{
+		{host: "example.com", domain: "example.com", want: true},
+		{host: "www.example.com", domain: "example.com", want: true},
+		{host: "www.example.com", domain: "*.example.com", want: true},
+		{host: "example.com", domain: "Example.COM", want: true},
+		{host: "badexample.com", domain: "example.com", want: false},
+		{host: "example.com.evil.org", domain: "example.com", want: false},
+	}
+	for _, test := range tests {
+		if got := matchDomain(test.host, test.domain); got != test.want {
+			t.Errorf("matchDomain(%q, %q) = %v, want %v", test.host, test.domain, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrToolAlreadyRegistered = errors.New("tool already registered")
+	ErrToolDoesNotExist      = errors.New("tool does not exist")
+)
+
+// Handler executes a tool call. Arguments are the JSON encoded arguments generated by the model, the
+// returned string is sent back to the model as the tool result.
+type Handler func(ctx context.Context, arguments string) (string, error)
+
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the tool arguments.
+	Parameters json.RawMessage
+	Handler    Handler
+}
+
+type Registry struct {
+	tools map[string]Tool
+	names []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		tools: make(map[string]Tool),
+		names: make([]string, 0),
+	}
+}
+
+func (r *Registry) Register(tools ...Tool) error {
+	for _, t := range tools {
+		if _, ok := r.tools[t.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrToolAlreadyRegistered, t.Name)
+		}
+		r.tools[t.Name] = t
+		r.names = append(r.names, t.Name)
+	}
+	return nil
+}
+
+func (r *Registry) Get(name string) (Tool, bool) {
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Names returns the names of all registered tools in registration order.
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// List returns the registered tools with the given names, unknown names are skipped.
+func (r *Registry) List(names []string) []Tool {
+	tools := make([]Tool, 0, len(names))
+	for _, name := range names {
+		if t, ok := r.tools[name]; ok {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+func (r *Registry) Call(ctx context.Context, name, arguments string) (string, error) {
+	t, ok := r.tools[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrToolDoesNotExist, name)
+	}
+	return t.Handler(ctx, arguments)
+}
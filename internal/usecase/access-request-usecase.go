@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"time"
+)
+
+var (
+	ErrAccessAlreadyRequested = errors.New("access already requested")
+)
+
+// AccessRequestDeniedError is returned for requests of users denied recently, they can request again after Until.
+type AccessRequestDeniedError struct {
+	Until time.Time
+}
+
+func (e *AccessRequestDeniedError) Error() string {
+	return fmt.Sprintf("access request denied until %s", e.Until.Format(time.RFC3339))
+}
+
+type AccessRequestStorage interface {
+	CreateAccessRequest(ctx context.Context, request model.AccessRequest) (bool, error)
+	TakeAccessRequest(ctx context.Context, telegramID int64) (model.AccessRequest, error)
+	SetAccessRequestDenied(ctx context.Context, telegramID int64, until time.Time) error
+	GetAccessRequestDeniedUntil(ctx context.Context, telegramID int64) (time.Time, error)
+}
+
+type AccessRequestUsecaseDeps struct {
+	AccessRequestStorage AccessRequestStorage
+	User                 *UserUsecase
+}
+
+// AccessRequestUsecase keeps requests of users without access to the private bot until admins approve or
+// deny them.
+type AccessRequestUsecase struct {
+	AccessRequestUsecaseDeps
+	cfg config.Telegram
+}
+
+func NewAccessRequestUsecase(deps AccessRequestUsecaseDeps, cfg config.Telegram) *AccessRequestUsecase {
+	return &AccessRequestUsecase{
+		AccessRequestUsecaseDeps: deps,
+		cfg:                      cfg,
+	}
+}
+
+// Enabled reports whether users of the private bot can request access.
+func (a *AccessRequestUsecase) Enabled() bool {
+	return a.cfg.IsNotPublic && len(a.cfg.AccessRequestRole) != 0 && len(a.cfg.AdminTelegramIDList) != 0
+}
+
+// Role returns the role granted by approved requests.
+func (a *AccessRequestUsecase) Role() model.UserRole {
+	return model.ParseUserRole(a.cfg.AccessRequestRole)
+}
+
+// Admins returns Telegram IDs of admins notified about requests.
+func (a *AccessRequestUsecase) Admins() []int64 {
+	return a.cfg.AdminTelegramIDList
+}
+
+// RequestAccess saves the request, ErrAccessAlreadyRequested is returned if the user has a pending one and
+// AccessRequestDeniedError if the user was denied less than the cooldown ago.
+func (a *AccessRequestUsecase) RequestAccess(ctx context.Context, request model.AccessRequest) error {
+	request.CreatedAt = time.Now()
+	deniedUntil, err := a.AccessRequestStorage.GetAccessRequestDeniedUntil(ctx, request.TelegramID)
+	if err != nil {
+		return fmt.Errorf("failed to get access request denial: %w", err)
+	}
+	if request.CreatedAt.Before(deniedUntil) {
+		return &AccessRequestDeniedError{Until: deniedUntil}
+	}
+	created, err := a.AccessRequestStorage.CreateAccessRequest(ctx, request)
+	if err != nil {
+		return fmt.Errorf("failed to create access request: %w", err)
+	}
+	if !created {
+		return ErrAccessAlreadyRequested
+	}
+	return nil
+}
+
+// ApproveRequest grants the access request role to the user. model.ErrAccessRequestDoesNotExist is returned if
+// the request was already handled.
+func (a *AccessRequestUsecase) ApproveRequest(ctx context.Context, telegramID int64) (model.AccessRequest, error) {
+	request, err := a.AccessRequestStorage.TakeAccessRequest(ctx, telegramID)
+	if err != nil {
+		return model.AccessRequest{}, err
+	}
+	if _, err = a.User.GrantRole(ctx, telegramID, a.Role()); err != nil && !errors.Is(err, ErrUserAlreadyHasRole) {
+		return model.AccessRequest{}, fmt.Errorf("failed to grant access request role: %w", err)
+	}
+	return request, nil
+}
+
+// DenyRequest removes the request, the user can't request access again until the cooldown passes.
+// model.ErrAccessRequestDoesNotExist is returned if the request was already handled.
+func (a *AccessRequestUsecase) DenyRequest(ctx context.Context, telegramID int64) (model.AccessRequest, error) {
+	request, err := a.AccessRequestStorage.TakeAccessRequest(ctx, telegramID)
+	if err != nil {
+		return model.AccessRequest{}, err
+	}
+	if a.cfg.AccessRequestCooldown > 0 {
+		until := time.Now().Add(a.cfg.AccessRequestCooldown)
+		if err = a.AccessRequestStorage.SetAccessRequestDenied(ctx, telegramID, until); err != nil {
+			return model.AccessRequest{}, fmt.Errorf("failed to save access request denial: %w", err)
+		}
+	}
+	return request, nil
+}
@@ -7,13 +7,18 @@ import (
 	"github.com/google/uuid"
 	"github.com/iamvkosarev/ai-telegram-bot/config"
 	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"strings"
 )
 
 var (
 	ErrUserRoleHasNotAnyAvailableModels = errors.New("user role has not any available models")
 	ErrUserRoleHasNotAccessToModel      = errors.New("user has not access to model")
+	ErrUserRoleHasNotAccessToPersona    = errors.New("user has not access to persona")
 )
 
+const defaultChatTemperature = 1
+
 type AiChatStorage interface {
 	GetChat(ctx context.Context, chatID uuid.UUID) (model.AIChat, error)
 	CreateChat(
@@ -21,7 +26,11 @@ type AiChatStorage interface {
 		temperature float32,
 	) (model.AIChat, error)
 	AddMessageToChat(ctx context.Context, chatID uuid.UUID, messageText string, messageSource model.MessageSource) error
+	AddAnswerToChat(ctx context.Context, chatID uuid.UUID, messageText string, usage model.Usage) (int, error)
 	ListUserChats(ctx context.Context, userID uuid.UUID) ([]model.AIChat, error)
+	UpdateChatVoiceReplies(ctx context.Context, chatID uuid.UUID, enabled bool) error
+	UpdateChatKnowledgeBase(ctx context.Context, chatID uuid.UUID, enabled bool) error
+	UpdateChatPersona(ctx context.Context, chatID uuid.UUID, persona string, systemPrompt string) error
 }
 
 type AiChatUsecaseDeps struct {
@@ -32,16 +41,67 @@ type AiChatUsecaseDeps struct {
 type AiChatUsecase struct {
 	AiChatUsecaseDeps
 	userRoleToChatModels map[model.UserRole][]string
+	userRoleToTools      map[model.UserRole][]string
+	defaultTools         []string
+	personas             []rolePersona
+}
+
+type rolePersona struct {
+	persona model.Persona
+	// roles the persona is visible to, nil for everyone.
+	roles []model.UserRole
+}
+
+func (p rolePersona) isVisibleFor(user model.User) bool {
+	if p.roles == nil {
+		return true
+	}
+	for _, role := range user.Roles {
+		if slices.Contains(p.roles, role) {
+			return true
+		}
+	}
+	return false
 }
 
-func NewAiChatUsecase(deps AiChatUsecaseDeps, roles []config.Role) *AiChatUsecase {
+func NewAiChatUsecase(
+	deps AiChatUsecaseDeps,
+	roles []config.Role,
+	toolsCfg config.Tools,
+	personasCfg []config.Persona,
+) *AiChatUsecase {
 	userRoleToChatModels := make(map[model.UserRole][]string)
+	userRoleToTools := make(map[model.UserRole][]string)
 	for _, roleToModels := range roles {
 		userRoleToChatModels[model.ParseUserRole(roleToModels.Role)] = roleToModels.Models
+		userRoleToTools[model.ParseUserRole(roleToModels.Role)] = roleToModels.Tools
+	}
+	personas := make([]rolePersona, 0, len(personasCfg))
+	for _, personaCfg := range personasCfg {
+		persona := rolePersona{
+			persona: model.Persona{
+				Name:         personaCfg.Name,
+				Description:  personaCfg.Description,
+				Model:        personaCfg.Model,
+				SystemPrompt: personaCfg.SystemPrompt,
+				Temperature:  defaultChatTemperature,
+				Tools:        personaCfg.Tools,
+			},
+		}
+		if personaCfg.Temperature != nil {
+			persona.persona.Temperature = *personaCfg.Temperature
+		}
+		for _, role := range personaCfg.Roles {
+			persona.roles = append(persona.roles, model.ParseUserRole(role))
+		}
+		personas = append(personas, persona)
 	}
 	return &AiChatUsecase{
 		AiChatUsecaseDeps:    deps,
 		userRoleToChatModels: userRoleToChatModels,
+		userRoleToTools:      userRoleToTools,
+		defaultTools:         toolsCfg.DefaultTools,
+		personas:             personas,
 	}
 }
 
@@ -61,7 +121,32 @@ func (a *AiChatUsecase) CreateChat(ctx context.Context, userID uuid.UUID, aiMode
 	if _, ok := availableModels[aiModel]; !ok {
 		return model.AIChat{}, ErrUserRoleHasNotAccessToModel
 	}
-	return a.AiChatStorage.CreateChat(ctx, userID, aiModel, 1)
+	return a.AiChatStorage.CreateChat(ctx, userID, aiModel, defaultChatTemperature)
+}
+
+// CreatePersonaChat creates a chat with the model, temperature and system prompt of the persona.
+func (a *AiChatUsecase) CreatePersonaChat(ctx context.Context, userID uuid.UUID, personaName string) (
+	model.AIChat,
+	error,
+) {
+	user, err := a.User.GetUserInfo(ctx, userID)
+	if err != nil {
+		return model.AIChat{}, fmt.Errorf("failed get user info: %w", err)
+	}
+	persona, ok := a.GetPersona(user, personaName)
+	if !ok {
+		return model.AIChat{}, ErrUserRoleHasNotAccessToPersona
+	}
+	chat, err := a.AiChatStorage.CreateChat(ctx, userID, persona.Model, persona.Temperature)
+	if err != nil {
+		return model.AIChat{}, err
+	}
+	if err = a.AiChatStorage.UpdateChatPersona(ctx, chat.ChatID, persona.Name, persona.SystemPrompt); err != nil {
+		return model.AIChat{}, fmt.Errorf("failed to update chat persona: %w", err)
+	}
+	chat.Persona = persona.Name
+	chat.SystemPrompt = persona.SystemPrompt
+	return chat, nil
 }
 
 func (a *AiChatUsecase) ListUserChats(ctx context.Context, userID uuid.UUID) ([]model.AIChat, error) {
@@ -77,6 +162,25 @@ func (a *AiChatUsecase) AddMessageToChat(
 	return a.AiChatStorage.AddMessageToChat(ctx, chatID, messageText, messageSource)
 }
 
+// AddAnswerToChat adds the answer of the chat model with tokens and cost it took and returns its index in the chat
+// messages.
+func (a *AiChatUsecase) AddAnswerToChat(
+	ctx context.Context,
+	chatID uuid.UUID,
+	messageText string,
+	usage model.Usage,
+) (int, error) {
+	return a.AiChatStorage.AddAnswerToChat(ctx, chatID, messageText, usage)
+}
+
+func (a *AiChatUsecase) UpdateChatVoiceReplies(ctx context.Context, chatID uuid.UUID, enabled bool) error {
+	return a.AiChatStorage.UpdateChatVoiceReplies(ctx, chatID, enabled)
+}
+
+func (a *AiChatUsecase) UpdateChatKnowledgeBase(ctx context.Context, chatID uuid.UUID, enabled bool) error {
+	return a.AiChatStorage.UpdateChatKnowledgeBase(ctx, chatID, enabled)
+}
+
 func (a *AiChatUsecase) GetAvailableForUserModels(user model.User) map[string]struct{} {
 	availableModels := make(map[string]struct{})
 	for _, role := range user.Roles {
@@ -86,3 +190,112 @@ func (a *AiChatUsecase) GetAvailableForUserModels(user model.User) map[string]st
 	}
 	return availableModels
 }
+
+// CreateBoundChat creates a chat with the persona, or with the model if personaName is empty, without
+// checking the owner access. It is used for chats of bound topics, where the access was checked on binding.
+func (a *AiChatUsecase) CreateBoundChat(ctx context.Context, ownerID uuid.UUID, aiModel, personaName string) (
+	model.AIChat,
+	error,
+) {
+	if len(personaName) == 0 {
+		return a.AiChatStorage.CreateChat(ctx, ownerID, aiModel, defaultChatTemperature)
+	}
+	for _, persona := range a.personas {
+		if persona.persona.Name != personaName {
+			continue
+		}
+		chat, err := a.AiChatStorage.CreateChat(ctx, ownerID, persona.persona.Model, persona.persona.Temperature)
+		if err != nil {
+			return model.AIChat{}, err
+		}
+		err = a.AiChatStorage.UpdateChatPersona(ctx, chat.ChatID, persona.persona.Name, persona.persona.SystemPrompt)
+		if err != nil {
+			return model.AIChat{}, fmt.Errorf("failed to update chat persona: %w", err)
+		}
+		chat.Persona = persona.persona.Name
+		chat.SystemPrompt = persona.persona.SystemPrompt
+		return chat, nil
+	}
+	return model.AIChat{}, ErrUserRoleHasNotAccessToPersona
+}
+
+// GetAvailableForUserPersonas returns personas visible to any of the user roles in the config order.
+func (a *AiChatUsecase) GetAvailableForUserPersonas(user model.User) []model.Persona {
+	personas := make([]model.Persona, 0, len(a.personas))
+	for _, persona := range a.personas {
+		if persona.isVisibleFor(user) {
+			personas = append(personas, persona.persona)
+		}
+	}
+	return personas
+}
+
+// CanUseChat reports whether the user can be answered in the chat: the persona of the chat has to be visible to
+// the user and the model of other chats has to be available to the user roles. Chats of personas removed from the
+// config are checked by the model.
+func (a *AiChatUsecase) CanUseChat(user model.User, chat model.AIChat) bool {
+	if len(chat.Persona) != 0 {
+		for _, persona := range a.personas {
+			if persona.persona.Name == chat.Persona {
+				return persona.isVisibleFor(user)
+			}
+		}
+	}
+	_, ok := a.GetAvailableForUserModels(user)[chat.Model]
+	return ok
+}
+
+func (a *AiChatUsecase) GetPersona(user model.User, name string) (model.Persona, bool) {
+	for _, persona := range a.GetAvailableForUserPersonas(user) {
+		if persona.Name == name {
+			return persona, true
+		}
+	}
+	return model.Persona{}, false
+}
+
+// GetAvailableForChatTools returns the persona tools with the default ones for persona chats and the user
+// tools otherwise.
+func (a *AiChatUsecase) GetAvailableForChatTools(user model.User, chat model.AIChat) []string {
+	if len(chat.Persona) != 0 {
+		for _, persona := range a.personas {
+			if persona.persona.Name == chat.Persona && len(persona.persona.Tools) != 0 {
+				tools := slices.Clone(a.defaultTools)
+				for _, toolName := range persona.persona.Tools {
+					if !slices.Contains(tools, toolName) {
+						tools = append(tools, toolName)
+					}
+				}
+				return tools
+			}
+		}
+	}
+	return a.GetAvailableForUserTools(user)
+}
+
+// GetAvailableForUserTools returns names of the default tools and the tools allowed for any of the user
+// roles.
+func (a *AiChatUsecase) GetAvailableForUserTools(user model.User) []string {
+	tools := slices.Clone(a.defaultTools)
+	for _, role := range user.Roles {
+		for _, toolName := range a.userRoleToTools[role] {
+			if !slices.Contains(tools, toolName) {
+				tools = append(tools, toolName)
+			}
+		}
+	}
+	return tools
+}
+
+// ExportChat renders the chat history, including attached context, as a markdown document.
+func (a *AiChatUsecase) ExportChat(chat model.AIChat) []byte {
+	result := strings.Builder{}
+	result.WriteString(fmt.Sprintf("# Chat %s\n\nModel: %s, T: %v\n", chat.ChatID, chat.Model, chat.ModelTemperature))
+	if len(chat.Persona) != 0 {
+		result.WriteString(fmt.Sprintf("\nPersona: %s\n\n> %s\n", chat.Persona, chat.SystemPrompt))
+	}
+	for _, message := range chat.Messages {
+		result.WriteString(fmt.Sprintf("\n## %s\n\n%s\n", message.Source, message.Body))
+	}
+	return []byte(result.String())
+}
@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"testing"
+)
+
+func newTestAiChatUsecase() *AiChatUsecase {
+	return NewAiChatUsecase(
+		AiChatUsecaseDeps{}, []config.Role{
+			{Role: "default", Models: []string{"gpt-4.1-nano"}},
+			{Role: "premium", Models: []string{"gpt-4.1-nano", "gpt-4.1"}},
+		}, config.Tools{}, []config.Persona{
+			{Name: "coder", Model: "gpt-4.1", Roles: []string{"premium"}},
+			{Name: "helper", Model: "gpt-4.1"},
+		},
+	)
+}
+
+func TestAiChatUsecaseCanUseChat(t *testing.T) {
+	aiChat := newTestAiChatUsecase()
+	defaultUser := model.User{Roles: []model.UserRole{model.UserRoleDefault}}
+	premiumUser := model.User{Roles: []model.UserRole{model.UserRoleDefault, model.UserRolePremium}}
+	tests := []struct {
+		name string
+		user model.User
+		chat model.AIChat
+		want bool
+	}{
+		{
+			name: "available model",
+			user: defaultUser,
+			chat: model.AIChat{Model: "gpt-4.1-nano"},
+			want: true,
+		},
+		{
+			name: "model of another role",
+			user: defaultUser,
+			chat: model.AIChat{Model: "gpt-4.1"},
+			want: false,
+		},
+		{
+			name: "model of the user role",
+			user: premiumUser,
+			chat: model.AIChat{Model: "gpt-4.1"},
+			want: true,
+		},
+		{
+			name: "persona of another role",
+			user: defaultUser,
+			chat: model.AIChat{Model: "gpt-4.1", Persona: "coder"},
+			want: false,
+		},
+		{
+			name: "persona of the user role",
+			user: premiumUser,
+			chat: model.AIChat{Model: "gpt-4.1", Persona: "coder"},
+			want: true,
+		},
+		{
+			name: "persona for everyone",
+			user: defaultUser,
+			chat: model.AIChat{Model: "gpt-4.1", Persona: "helper"},
+			want: true,
+		},
+		{
+			name: "removed persona",
+			user: defaultUser,
+			chat: model.AIChat{Model: "gpt-4.1", Persona: "removed"},
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				if got := aiChat.CanUseChat(test.user, test.chat); got != test.want {
+					t.Errorf("CanUseChat() = %v, want %v", got, test.want)
+				}
+			},
+		)
+	}
+}
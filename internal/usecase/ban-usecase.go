@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"time"
+)
+
+var (
+	ErrUserIsNotBanned = errors.New("user is not banned")
+	ErrCannotBanAdmin  = errors.New("admins can't be banned")
+)
+
+type BanStorage interface {
+	SetBan(ctx context.Context, ban model.Ban) error
+	GetBan(ctx context.Context, telegramID int64) (model.Ban, error)
+	DeleteBan(ctx context.Context, telegramID int64) (bool, error)
+}
+
+type BanUsecaseDeps struct {
+	BanStorage BanStorage
+	User       *UserUsecase
+}
+
+type BanUsecase struct {
+	BanUsecaseDeps
+}
+
+func NewBanUsecase(deps BanUsecaseDeps) *BanUsecase {
+	return &BanUsecase{
+		BanUsecaseDeps: deps,
+	}
+}
+
+// Ban bans the Telegram user, zero duration bans until Unban. A new ban replaces the previous one. The user
+// doesn't have to be saved yet, so users can be banned before they write to the bot.
+func (b *BanUsecase) Ban(
+	ctx context.Context,
+	bannedBy int64,
+	telegramID int64,
+	reason string,
+	duration time.Duration,
+) (model.Ban, error) {
+	if slices.Contains(b.User.getTelegramUserRoles(telegramID), model.UserRoleAdmin) {
+		return model.Ban{}, ErrCannotBanAdmin
+	}
+	user, err := b.User.FindTelegramUser(ctx, telegramID)
+	if err != nil && !errors.Is(err, model.ErrTelegramUserDoesNotExists) {
+		return model.Ban{}, fmt.Errorf("failed to find user: %w", err)
+	}
+	if err == nil && isAdmin(user) {
+		return model.Ban{}, ErrCannotBanAdmin
+	}
+
+	now := time.Now()
+	ban := model.Ban{
+		TelegramID: telegramID,
+		Reason:     reason,
+		BannedBy:   bannedBy,
+		CreatedAt:  now,
+	}
+	if duration != 0 {
+		ban.ExpiresAt = now.Add(duration)
+	}
+	if err = b.BanStorage.SetBan(ctx, ban); err != nil {
+		return model.Ban{}, fmt.Errorf("failed to save ban: %w", err)
+	}
+	return ban, nil
+}
+
+func (b *BanUsecase) Unban(ctx context.Context, telegramID int64) error {
+	deleted, err := b.BanStorage.DeleteBan(ctx, telegramID)
+	if err != nil {
+		return fmt.Errorf("failed to delete ban: %w", err)
+	}
+	if !deleted {
+		return ErrUserIsNotBanned
+	}
+	return nil
+}
+
+// GetBan returns the ban of the Telegram user, false is returned if the user isn't banned.
+func (b *BanUsecase) GetBan(ctx context.Context, telegramID int64) (model.Ban, bool, error) {
+	ban, err := b.BanStorage.GetBan(ctx, telegramID)
+	if err != nil {
+		if errors.Is(err, model.ErrBanDoesNotExist) {
+			return model.Ban{}, false, nil
+		}
+		return model.Ban{}, false, fmt.Errorf("failed to get ban: %w", err)
+	}
+	return ban, true, nil
+}
@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+type BroadcastStorage interface {
+	ListTelegramUsers(ctx context.Context) ([]int64, error)
+}
+
+type BroadcastUsecaseDeps struct {
+	BroadcastStorage BroadcastStorage
+	User             *UserUsecase
+	Ban              *BanUsecase
+}
+
+// draftRequestKey is the admin waiting to send the message to broadcast in the chat.
+type draftRequestKey struct {
+	adminID int64
+	chatID  int64
+}
+
+type draftRequest struct {
+	role      model.UserRole
+	expiresAt time.Time
+}
+
+// BroadcastUsecase keeps drafts of broadcasts and finds their recipients. Drafts are kept in memory, so they are
+// lost on restart.
+type BroadcastUsecase struct {
+	BroadcastUsecaseDeps
+	cfg config.Broadcast
+
+	mu            sync.Mutex
+	draftRequests map[draftRequestKey]draftRequest
+	drafts        map[string]model.BroadcastDraft
+}
+
+func NewBroadcastUsecase(deps BroadcastUsecaseDeps, cfg config.Broadcast) *BroadcastUsecase {
+	return &BroadcastUsecase{
+		BroadcastUsecaseDeps: deps,
+		cfg:                  cfg,
+		draftRequests:        make(map[draftRequestKey]draftRequest),
+		drafts:               make(map[string]model.BroadcastDraft),
+	}
+}
+
+// Interval is the delay between messages of a broadcast, zero doesn't limit the rate.
+func (b *BroadcastUsecase) Interval() time.Duration {
+	if b.cfg.MessagesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / b.cfg.MessagesPerSecond)
+}
+
+func (b *BroadcastUsecase) ProgressInterval() time.Duration {
+	return b.cfg.ProgressInterval
+}
+
+// RequestDraft makes the next message of the admin in the chat a draft of the broadcast to users with the role.
+func (b *BroadcastUsecase) RequestDraft(adminID, chatID int64, role model.UserRole) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draftRequests[draftRequestKey{adminID: adminID, chatID: chatID}] = draftRequest{
+		role:      role,
+		expiresAt: time.Now().Add(b.cfg.DraftTimeout),
+	}
+}
+
+func (b *BroadcastUsecase) CancelDraftRequest(adminID, chatID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.draftRequests, draftRequestKey{adminID: adminID, chatID: chatID})
+}
+
+func (b *BroadcastUsecase) IsWaitingForDraft(adminID, chatID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	request, ok := b.draftRequests[draftRequestKey{adminID: adminID, chatID: chatID}]
+	return ok && time.Now().Before(request.expiresAt)
+}
+
+// CreateDraft makes the message the draft the admin is asked for. False is returned if the admin isn't asked for
+// a draft in the chat.
+func (b *BroadcastUsecase) CreateDraft(adminID, chatID int64, messageID int) (model.BroadcastDraft, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := draftRequestKey{adminID: adminID, chatID: chatID}
+	request, ok := b.draftRequests[key]
+	delete(b.draftRequests, key)
+	now := time.Now()
+	if !ok || !now.Before(request.expiresAt) {
+		return model.BroadcastDraft{}, false
+	}
+
+	for draftID, draft := range b.drafts {
+		if b.isDraftExpired(draft, now) {
+			delete(b.drafts, draftID)
+		}
+	}
+	draft := model.BroadcastDraft{
+		ID:        uuid.NewString(),
+		AdminID:   adminID,
+		ChatID:    chatID,
+		MessageID: messageID,
+		Role:      request.role,
+		CreatedAt: now,
+	}
+	b.drafts[draft.ID] = draft
+	return draft, true
+}
+
+// TakeDraft removes the draft of the admin to send or cancel it, so it is sent once. False is returned if there
+// is no such draft or it has expired.
+func (b *BroadcastUsecase) TakeDraft(draftID string, adminID int64) (model.BroadcastDraft, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	draft, ok := b.drafts[draftID]
+	if !ok || draft.AdminID != adminID {
+		return model.BroadcastDraft{}, false
+	}
+	delete(b.drafts, draftID)
+	return draft, !b.isDraftExpired(draft, time.Now())
+}
+
+func (b *BroadcastUsecase) isDraftExpired(draft model.BroadcastDraft, now time.Time) bool {
+	return !now.Before(draft.CreatedAt.Add(b.cfg.DraftTimeout))
+}
+
+// ListRecipients returns Telegram IDs of users with the role, empty role returns all users. Banned users are
+// skipped.
+func (b *BroadcastUsecase) ListRecipients(ctx context.Context, role model.UserRole) ([]int64, error) {
+	telegramIDs, err := b.BroadcastStorage.ListTelegramUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list telegram users: %w", err)
+	}
+	slices.Sort(telegramIDs)
+	recipients := make([]int64, 0, len(telegramIDs))
+	for _, telegramID := range slices.Compact(telegramIDs) {
+		_, banned, err := b.Ban.GetBan(ctx, telegramID)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			continue
+		}
+		if len(role) != 0 {
+			user, err := b.User.FindTelegramUser(ctx, telegramID)
+			if err != nil {
+				if errors.Is(err, model.ErrTelegramUserDoesNotExists) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to find user %v: %w", telegramID, err)
+			}
+			if !slices.Contains(user.Roles, role) {
+				continue
+			}
+		}
+		recipients = append(recipients, telegramID)
+	}
+	return recipients, nil
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/document"
+	openai_tools "github.com/iamvkosarev/ai-telegram-bot/pkg/openai-tools"
+)
+
+var (
+	ErrDocumentTooLarge = errors.New("document is too large")
+)
+
+type AttachedDocument struct {
+	FileName   string
+	Parts      int
+	TotalParts int
+	Truncated  bool
+}
+
+type DocumentUsecaseDeps struct {
+	AIChat *AiChatUsecase
+}
+
+type DocumentUsecase struct {
+	DocumentUsecaseDeps
+	cfg config.Documents
+}
+
+func NewDocumentUsecase(deps DocumentUsecaseDeps, cfg config.Documents) *DocumentUsecase {
+	return &DocumentUsecase{
+		DocumentUsecaseDeps: deps,
+		cfg:                 cfg,
+	}
+}
+
+func (d *DocumentUsecase) MaxFileSize() int64 {
+	return d.cfg.MaxFileSize
+}
+
+// AttachDocument extracts the document text and adds it to the chat as context messages. Only the
+// first parts that fit into the configured token budget are attached.
+func (d *DocumentUsecase) AttachDocument(
+	ctx context.Context,
+	chat model.AIChat,
+	fileName string,
+	mimeType string,
+	data []byte,
+) (AttachedDocument, error) {
+	if int64(len(data)) > d.cfg.MaxFileSize {
+		return AttachedDocument{}, ErrDocumentTooLarge
+	}
+	text, err := document.ExtractText(fileName, mimeType, data)
+	if err != nil {
+		return AttachedDocument{}, fmt.Errorf("failed to extract document text: %w", err)
+	}
+	chunks, err := openai_tools.ChunkText(text, chat.Model, d.cfg.ChunkTokens)
+	if err != nil {
+		return AttachedDocument{}, fmt.Errorf("failed to chunk document text: %w", err)
+	}
+
+	attached := AttachedDocument{
+		FileName:   fileName,
+		Parts:      len(chunks),
+		TotalParts: len(chunks),
+	}
+	if maxParts := max(d.cfg.MaxTokens/d.cfg.ChunkTokens, 1); len(chunks) > maxParts {
+		chunks = chunks[:maxParts]
+		attached.Parts = maxParts
+		attached.Truncated = true
+	}
+	for i, chunk := range chunks {
+		body := fmt.Sprintf("Document \"%s\" (part %d/%d):\n\n%s", fileName, i+1, attached.TotalParts, chunk)
+		if err = d.AIChat.AddMessageToChat(ctx, chat.ChatID, body, model.MessageSourceContext); err != nil {
+			return AttachedDocument{}, fmt.Errorf("failed to add document part to chat: %w", err)
+		}
+	}
+	return attached, nil
+}
@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUserRoleHasNotAnyImageModels = errors.New("user role has not any image models")
+	ErrEmptyImagePrompt             = errors.New("empty image prompt")
+)
+
+type ImageGenerator interface {
+	GenerateImage(ctx context.Context, request model.ImageRequest) (model.GeneratedImage, error)
+}
+
+type ImageUsecaseDeps struct {
+	Generator ImageGenerator
+	AIChat    *AiChatUsecase
+}
+
+type roleImages struct {
+	role   model.UserRole
+	models []string
+	sizes  []string
+}
+
+type ImageUsecase struct {
+	ImageUsecaseDeps
+	cfg config.Images
+	// roleImages keeps the config order of roles, so the first model and size of the first user role
+	// are used by default.
+	roleImages []roleImages
+}
+
+func NewImageUsecase(deps ImageUsecaseDeps, cfg config.Images, roles []config.Role) *ImageUsecase {
+	images := make([]roleImages, 0, len(roles))
+	for _, role := range roles {
+		images = append(
+			images, roleImages{
+				role:   model.ParseUserRole(role.Role),
+				models: role.Images.Models,
+				sizes:  role.Images.Sizes,
+			},
+		)
+	}
+	return &ImageUsecase{
+		ImageUsecaseDeps: deps,
+		cfg:              cfg,
+		roleImages:       images,
+	}
+}
+
+// GetAvailableForUserOptions returns image models and sizes of all user roles in config order.
+func (i *ImageUsecase) GetAvailableForUserOptions(user model.User) ([]string, []string) {
+	models := make([]string, 0)
+	sizes := make([]string, 0)
+	for _, images := range i.roleImages {
+		if !slices.Contains(user.Roles, images.role) {
+			continue
+		}
+		for _, imageModel := range images.models {
+			if !slices.Contains(models, imageModel) {
+				models = append(models, imageModel)
+			}
+		}
+		for _, size := range images.sizes {
+			if !slices.Contains(sizes, size) {
+				sizes = append(sizes, size)
+			}
+		}
+	}
+	return models, sizes
+}
+
+// ParseImageRequest parses "[model] [size] prompt" arguments. Model and size are optional and must be
+// available for the user, by default the first available ones are used.
+func (i *ImageUsecase) ParseImageRequest(user model.User, args string) (model.ImageRequest, error) {
+	models, sizes := i.GetAvailableForUserOptions(user)
+	if len(models) == 0 {
+		return model.ImageRequest{}, ErrUserRoleHasNotAnyImageModels
+	}
+	request := model.ImageRequest{
+		Model: models[0],
+	}
+	if len(sizes) != 0 {
+		request.Size = sizes[0]
+	}
+
+	words := strings.Fields(args)
+	for len(words) != 0 {
+		switch {
+		case slices.Contains(models, words[0]):
+			request.Model = words[0]
+		case slices.Contains(sizes, words[0]):
+			request.Size = words[0]
+		default:
+			request.Prompt = strings.Join(words, " ")
+			return request, nil
+		}
+		words = words[1:]
+	}
+	return model.ImageRequest{}, ErrEmptyImagePrompt
+}
+
+// GetImageCost returns the price of the image in USD, images of models without price cost nothing.
+func (i *ImageUsecase) GetImageCost(request model.ImageRequest) float64 {
+	return i.cfg.Prices[request.Model]
+}
+
+// GenerateImage generates the image and records the prompt and the result in the chat history, so the
+// following questions have the image context.
+func (i *ImageUsecase) GenerateImage(
+	ctx context.Context,
+	chatID uuid.UUID,
+	request model.ImageRequest,
+) (model.GeneratedImage, error) {
+	generateCtx, cancel := context.WithTimeout(ctx, i.cfg.Timeout)
+	defer cancel()
+
+	image, err := i.Generator.GenerateImage(generateCtx, request)
+	if err != nil {
+		return model.GeneratedImage{}, fmt.Errorf("failed to generate image: %w", err)
+	}
+
+	userMessage := fmt.Sprintf("Generate an image: %s", request.Prompt)
+	if err = i.AIChat.AddMessageToChat(ctx, chatID, userMessage, model.MessageSourceUser); err != nil {
+		return model.GeneratedImage{}, fmt.Errorf("failed to add image prompt to chat: %w", err)
+	}
+	description := request.Prompt
+	if len(image.RevisedPrompt) != 0 {
+		description = image.RevisedPrompt
+	}
+	assistantMessage := fmt.Sprintf(
+		"[Image generated by %s, size %s] %s", request.Model, request.Size, description,
+	)
+	if err = i.AIChat.AddMessageToChat(ctx, chatID, assistantMessage, model.MessageSourceAssistant); err != nil {
+		return model.GeneratedImage{}, fmt.Errorf("failed to add image result to chat: %w", err)
+	}
+	return image, nil
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInlineRateLimited     = errors.New("inline queries rate limit exceeded")
+	ErrInlineQuerySuperseded = errors.New("inline query superseded by a newer one")
+)
+
+type InlineUsecaseDeps struct {
+	OpenAI     *OpenAIUsecase
+	Moderation *ModerationUsecase
+	Quota      *QuotaUsecase
+}
+
+type inlineAnswer struct {
+	text      string
+	expiresAt time.Time
+}
+
+// InlineUsecase answers inline queries with short answers of the configured model. Answers are cached by
+// query text and users are limited in the number of queries sent to the model.
+type InlineUsecase struct {
+	InlineUsecaseDeps
+	cfg config.Inline
+
+	mu       sync.Mutex
+	cache    map[string]inlineAnswer
+	requests map[int64][]time.Time
+	// latestQueries keeps the last query ID of every user, Telegram sends a new query on every typed character.
+	latestQueries map[int64]string
+}
+
+func NewInlineUsecase(deps InlineUsecaseDeps, cfg config.Inline) *InlineUsecase {
+	return &InlineUsecase{
+		InlineUsecaseDeps: deps,
+		cfg:               cfg,
+		cache:             make(map[string]inlineAnswer),
+		requests:          make(map[int64][]time.Time),
+		latestQueries:     make(map[int64]string),
+	}
+}
+
+func (i *InlineUsecase) Enabled() bool {
+	return i.cfg.Enabled
+}
+
+func (i *InlineUsecase) CacheTTL() time.Duration {
+	return i.cfg.CacheTTL
+}
+
+// IsAnswerable reports whether the query is long enough to be sent to the model.
+func (i *InlineUsecase) IsAnswerable(query string) bool {
+	return len([]rune(normalizeInlineQuery(query))) >= i.cfg.MinQueryLength
+}
+
+// Answer returns the model answer to the query of the user. The query is answered after the debounce delay, so
+// queries replaced while typing return ErrInlineQuerySuperseded. Cached answers don't count against the rate limit,
+// queries blocked by moderation return ErrPromptBlocked. Answers are counted in quotas of the user like chat
+// answers, QuotaExceededError is returned if a quota is used up.
+func (i *InlineUsecase) Answer(ctx context.Context, user model.User, queryID, query string) (string, error) {
+	telegramUserID := user.TelegramID
+	key := normalizeInlineQuery(query)
+	if answer, ok := i.getCachedAnswer(key); ok {
+		return answer, nil
+	}
+
+	i.mu.Lock()
+	i.latestQueries[telegramUserID] = queryID
+	i.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(i.cfg.Debounce):
+	}
+	i.mu.Lock()
+	isLatest := i.latestQueries[telegramUserID] == queryID
+	if isLatest {
+		delete(i.latestQueries, telegramUserID)
+	}
+	i.mu.Unlock()
+	if !isLatest {
+		return "", ErrInlineQuerySuperseded
+	}
+
+	if answer, ok := i.getCachedAnswer(key); ok {
+		return answer, nil
+	}
+	if !i.allowRequest(telegramUserID) {
+		return "", ErrInlineRateLimited
+	}
+	if err := i.Moderation.CheckPrompt(ctx, telegramUserID, query); err != nil {
+		return "", fmt.Errorf("failed to check inline query: %w", err)
+	}
+	if err := i.Quota.ReserveRequest(ctx, user); err != nil {
+		return "", fmt.Errorf("failed to reserve inline request: %w", err)
+	}
+
+	completeCtx, cancel := context.WithTimeout(ctx, i.cfg.Timeout)
+	defer cancel()
+	answer, usage, err := i.OpenAI.Complete(completeCtx, i.cfg.Model, i.cfg.SystemPrompt, query, i.cfg.MaxTokens)
+	if err != nil {
+		return "", fmt.Errorf("failed to complete inline query: %w", err)
+	}
+	if err = i.Quota.RecordUsage(ctx, user, usage); err != nil {
+		log.Printf("failed to record inline usage of user %s: %v\n", user.UserID, err)
+	}
+	answer = strings.TrimSpace(answer)
+	i.cacheAnswer(key, answer)
+	return answer, nil
+}
+
+func (i *InlineUsecase) getCachedAnswer(key string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	answer, ok := i.cache[key]
+	if !ok || time.Now().After(answer.expiresAt) {
+		return "", false
+	}
+	return answer.text, true
+}
+
+func (i *InlineUsecase) cacheAnswer(key, text string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	for cachedKey, answer := range i.cache {
+		if now.After(answer.expiresAt) {
+			delete(i.cache, cachedKey)
+		}
+	}
+	i.cache[key] = inlineAnswer{
+		text:      text,
+		expiresAt: now.Add(i.cfg.CacheTTL),
+	}
+}
+
+// allowRequest counts the request of the user, if the user hasn't reached the limit for the rate limit period.
+func (i *InlineUsecase) allowRequest(telegramUserID int64) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	since := now.Add(-i.cfg.RateLimitPeriod)
+	requests := i.requests[telegramUserID]
+	for len(requests) != 0 && requests[0].Before(since) {
+		requests = requests[1:]
+	}
+	if len(requests) >= i.cfg.RateLimit {
+		i.requests[telegramUserID] = requests
+		return false
+	}
+	i.requests[telegramUserID] = append(requests, now)
+	return true
+}
+
+func normalizeInlineQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
@@ -0,0 +1,179 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInviteInvalid         = errors.New("invite is invalid")
+	ErrInvalidInviteUses     = errors.New("invalid invite uses")
+	ErrInvalidInviteDuration = errors.New("invalid invite duration")
+)
+
+const (
+	inviteCodeLength = 12
+	// inviteCodeAlphabet has only characters allowed in /start deep link parameters, which don't need escaping
+	// in markdown.
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+)
+
+type InviteStorage interface {
+	CreateInvite(ctx context.Context, invite model.Invite) error
+	GetInvite(ctx context.Context, code string) (model.Invite, error)
+	ListInvites(ctx context.Context) ([]model.Invite, error)
+	UseInvite(ctx context.Context, invite model.Invite) (bool, error)
+	DeleteInvite(ctx context.Context, code string) error
+}
+
+type InviteUsecaseDeps struct {
+	InviteStorage InviteStorage
+	User          *UserUsecase
+}
+
+type InviteUsecase struct {
+	InviteUsecaseDeps
+}
+
+func NewInviteUsecase(deps InviteUsecaseDeps) *InviteUsecase {
+	return &InviteUsecase{
+		InviteUsecaseDeps: deps,
+	}
+}
+
+// CreateInvite creates an invite granting the role. Zero uses allow any number of users, zero duration makes
+// the invite valid until it is revoked.
+func (i *InviteUsecase) CreateInvite(
+	ctx context.Context,
+	creatorTelegramID int64,
+	role model.UserRole,
+	uses int,
+	duration time.Duration,
+) (model.Invite, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return model.Invite{}, fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	now := time.Now()
+	invite := model.Invite{
+		Code:      code,
+		Role:      role,
+		Uses:      uses,
+		CreatedBy: creatorTelegramID,
+		CreatedAt: now,
+	}
+	if duration != 0 {
+		invite.ExpiresAt = now.Add(duration)
+	}
+	if err = i.InviteStorage.CreateInvite(ctx, invite); err != nil {
+		return model.Invite{}, fmt.Errorf("failed to create invite: %w", err)
+	}
+	return invite, nil
+}
+
+func (i *InviteUsecase) ListInvites(ctx context.Context) ([]model.Invite, error) {
+	return i.InviteStorage.ListInvites(ctx)
+}
+
+func (i *InviteUsecase) RevokeInvite(ctx context.Context, code string) error {
+	if _, err := i.InviteStorage.GetInvite(ctx, code); err != nil {
+		return err
+	}
+	return i.InviteStorage.DeleteInvite(ctx, code)
+}
+
+// RedeemInvite grants the role of the invite to the Telegram user. Uses aren't spent by users who already have
+// the role, ErrUserAlreadyHasRole is returned for them.
+func (i *InviteUsecase) RedeemInvite(ctx context.Context, userTelegramID int64, code string) (model.Invite, error) {
+	invite, err := i.InviteStorage.GetInvite(ctx, code)
+	if err != nil {
+		if errors.Is(err, model.ErrInviteDoesNotExist) {
+			return model.Invite{}, ErrInviteInvalid
+		}
+		return model.Invite{}, fmt.Errorf("failed to get invite: %w", err)
+	}
+	if invite.IsExpired(time.Now()) || invite.IsUsedUp() {
+		return model.Invite{}, ErrInviteInvalid
+	}
+	user, err := i.User.GetUserInfoForTelegramUser(ctx, userTelegramID)
+	if err != nil {
+		return model.Invite{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	if slices.Contains(user.Roles, invite.Role) {
+		return invite, ErrUserAlreadyHasRole
+	}
+
+	ok, err := i.InviteStorage.UseInvite(ctx, invite)
+	if err != nil {
+		return model.Invite{}, fmt.Errorf("failed to use invite: %w", err)
+	}
+	if !ok {
+		return model.Invite{}, ErrInviteInvalid
+	}
+	if _, err = i.User.GrantRole(ctx, userTelegramID, invite.Role); err != nil {
+		return model.Invite{}, fmt.Errorf("failed to grant invite role: %w", err)
+	}
+	return invite, nil
+}
+
+// ParseInviteArgs parses "<uses> <duration>" arguments of an invite, both are optional. A single invite is
+// created by default.
+func ParseInviteArgs(args string) (int, time.Duration, error) {
+	usesArg, durationArg := cutWord(args)
+	uses := 1
+	if len(usesArg) != 0 {
+		var err error
+		if uses, err = strconv.Atoi(usesArg); err != nil || uses < 0 {
+			return 0, 0, fmt.Errorf("%w: %s", ErrInvalidInviteUses, usesArg)
+		}
+	}
+	var duration time.Duration
+	if len(durationArg) != 0 {
+		var err error
+		if duration, err = parseDuration(durationArg); err != nil || duration <= 0 {
+			return 0, 0, fmt.Errorf("%w: %s", ErrInvalidInviteDuration, durationArg)
+		}
+	}
+	return uses, duration, nil
+}
+
+// parseDuration parses durations like time.ParseDuration, but days are allowed too, e.g. "7d" or "1d12h".
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	days, rest, found := strings.Cut(s, "d")
+	if !found {
+		return time.ParseDuration(s)
+	}
+	daysCount, err := strconv.Atoi(days)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number of days %s: %w", days, err)
+	}
+	duration := time.Duration(daysCount) * 24 * time.Hour
+	if len(rest) == 0 {
+		return duration, nil
+	}
+	restDuration, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, err
+	}
+	return duration + restDuration, nil
+}
+
+func generateInviteCode() (string, error) {
+	randomBytes := make([]byte, inviteCodeLength)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	code := make([]byte, inviteCodeLength)
+	for index, randomByte := range randomBytes {
+		code[index] = inviteCodeAlphabet[int(randomByte)%len(inviteCodeAlphabet)]
+	}
+	return string(code), nil
+}
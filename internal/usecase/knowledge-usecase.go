@@ -0,0 +1,213 @@
+package usecase
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/document"
+	openai_tools "github.com/iamvkosarev/ai-telegram-bot/pkg/openai-tools"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrKnowledgeBaseFull          = errors.New("knowledge base is full")
+	ErrKnowledgeDocumentTooLarge  = errors.New("knowledge document is too large")
+	ErrKnowledgeDocumentEmptyText = errors.New("knowledge document has no text")
+)
+
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+type KnowledgeStorage interface {
+	CreateKnowledgeDocument(
+		ctx context.Context, userID uuid.UUID, title string,
+		chunks []model.KnowledgeChunk,
+	) (model.KnowledgeDocument, error)
+	ListKnowledgeDocuments(ctx context.Context, userID uuid.UUID) ([]model.KnowledgeDocument, error)
+	ListKnowledgeChunks(ctx context.Context, userID uuid.UUID) ([]model.KnowledgeChunk, error)
+	DeleteKnowledgeDocument(ctx context.Context, userID, documentID uuid.UUID) error
+}
+
+type KnowledgeUsecaseDeps struct {
+	Embedder         Embedder
+	KnowledgeStorage KnowledgeStorage
+}
+
+type KnowledgeUsecase struct {
+	KnowledgeUsecaseDeps
+	cfg              config.KnowledgeBase
+	roleMaxDocuments map[model.UserRole]int
+}
+
+func NewKnowledgeUsecase(
+	deps KnowledgeUsecaseDeps,
+	cfg config.KnowledgeBase,
+	roles []config.Role,
+) *KnowledgeUsecase {
+	roleMaxDocuments := make(map[model.UserRole]int)
+	for _, role := range roles {
+		roleMaxDocuments[model.ParseUserRole(role.Role)] = role.Limits.MaxKnowledgeDocuments
+	}
+	return &KnowledgeUsecase{
+		KnowledgeUsecaseDeps: deps,
+		cfg:                  cfg,
+		roleMaxDocuments:     roleMaxDocuments,
+	}
+}
+
+func (k *KnowledgeUsecase) SearchTimeout() time.Duration {
+	return k.cfg.SearchTimeout
+}
+
+func (k *KnowledgeUsecase) MaxDocuments(user model.User) int {
+	return getRoleLimit(user, k.roleMaxDocuments, k.cfg.MaxDocuments)
+}
+
+// AddFile extracts the text of the file and adds it to the user knowledge base.
+func (k *KnowledgeUsecase) AddFile(
+	ctx context.Context,
+	user model.User,
+	fileName string,
+	mimeType string,
+	data []byte,
+) (model.KnowledgeDocument, error) {
+	text, err := document.ExtractText(fileName, mimeType, data)
+	if err != nil {
+		return model.KnowledgeDocument{}, fmt.Errorf("failed to extract document text: %w", err)
+	}
+	return k.AddDocument(ctx, user, fileName, text)
+}
+
+// AddDocument splits the text into chunks, embeds them and stores the document in the user knowledge base.
+func (k *KnowledgeUsecase) AddDocument(
+	ctx context.Context,
+	user model.User,
+	title string,
+	text string,
+) (model.KnowledgeDocument, error) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return model.KnowledgeDocument{}, ErrKnowledgeDocumentEmptyText
+	}
+	documents, err := k.KnowledgeStorage.ListKnowledgeDocuments(ctx, user.UserID)
+	if err != nil {
+		return model.KnowledgeDocument{}, fmt.Errorf("failed to list knowledge documents: %w", err)
+	}
+	if len(documents) >= k.MaxDocuments(user) {
+		return model.KnowledgeDocument{}, ErrKnowledgeBaseFull
+	}
+
+	texts, err := openai_tools.ChunkText(text, k.cfg.EmbeddingModel, k.cfg.ChunkTokens)
+	if err != nil {
+		return model.KnowledgeDocument{}, fmt.Errorf("failed to chunk document text: %w", err)
+	}
+	if len(texts) > k.cfg.MaxChunks {
+		return model.KnowledgeDocument{}, ErrKnowledgeDocumentTooLarge
+	}
+	embeddings, err := k.Embedder.Embed(ctx, texts)
+	if err != nil {
+		return model.KnowledgeDocument{}, fmt.Errorf("failed to embed document: %w", err)
+	}
+
+	chunks := make([]model.KnowledgeChunk, 0, len(texts))
+	for i, chunkText := range texts {
+		chunks = append(
+			chunks, model.KnowledgeChunk{
+				Title:     title,
+				Index:     i,
+				Text:      chunkText,
+				Embedding: embeddings[i],
+			},
+		)
+	}
+	return k.KnowledgeStorage.CreateKnowledgeDocument(ctx, user.UserID, title, chunks)
+}
+
+func (k *KnowledgeUsecase) ListDocuments(ctx context.Context, userID uuid.UUID) ([]model.KnowledgeDocument, error) {
+	return k.KnowledgeStorage.ListKnowledgeDocuments(ctx, userID)
+}
+
+func (k *KnowledgeUsecase) DeleteDocument(ctx context.Context, userID, documentID uuid.UUID) error {
+	return k.KnowledgeStorage.DeleteKnowledgeDocument(ctx, userID, documentID)
+}
+
+// Search returns the chunks of the user knowledge base closest to the query, at most top_k of them and
+// only those scoring at least min_score.
+func (k *KnowledgeUsecase) Search(ctx context.Context, userID uuid.UUID, query string) (
+	[]model.KnowledgeMatch,
+	error,
+) {
+	chunks, err := k.KnowledgeStorage.ListKnowledgeChunks(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list knowledge chunks: %w", err)
+	}
+	if len(chunks) == 0 || len(strings.TrimSpace(query)) == 0 {
+		return nil, nil
+	}
+	embeddings, err := k.Embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+
+	matches := make([]model.KnowledgeMatch, 0, len(chunks))
+	for _, chunk := range chunks {
+		score := cosineSimilarity(embeddings[0], chunk.Embedding)
+		if score < k.cfg.MinScore {
+			continue
+		}
+		matches = append(matches, model.KnowledgeMatch{Chunk: chunk, Score: score})
+	}
+	slices.SortFunc(
+		matches, func(a, b model.KnowledgeMatch) int {
+			return cmp.Compare(b.Score, a.Score)
+		},
+	)
+	if len(matches) > k.cfg.TopK {
+		matches = matches[:k.cfg.TopK]
+	}
+	return matches, nil
+}
+
+// BuildContext renders the matches as a system prompt. Sources are numbered, so the model can cite them
+// as [n].
+func (k *KnowledgeUsecase) BuildContext(matches []model.KnowledgeMatch) string {
+	result := strings.Builder{}
+	result.WriteString(
+		"Use the following excerpts from the user's knowledge base when they are relevant to the question. " +
+			"Cite the excerpts you use as [n]. If the excerpts don't contain the answer, say so and answer " +
+			"from your own knowledge.\n",
+	)
+	for i, match := range matches {
+		result.WriteString(
+			fmt.Sprintf(
+				"\n[%d] \"%s\" (part %d):\n%s\n", i+1, match.Chunk.Title, match.Chunk.Index+1,
+				strings.TrimSpace(match.Chunk.Text),
+			),
+		)
+	}
+	return result.String()
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
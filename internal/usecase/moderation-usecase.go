@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPromptBlocked = errors.New("prompt is blocked by moderation")
+)
+
+type Moderator interface {
+	// Moderate returns categories the text is flagged with.
+	Moderate(ctx context.Context, text string) ([]string, error)
+}
+
+type ModerationUsecaseDeps struct {
+	Moderator Moderator
+}
+
+type ModerationUsecase struct {
+	ModerationUsecaseDeps
+	cfg config.Moderation
+}
+
+func NewModerationUsecase(deps ModerationUsecaseDeps, cfg config.Moderation) *ModerationUsecase {
+	return &ModerationUsecase{
+		ModerationUsecaseDeps: deps,
+		cfg:                   cfg,
+	}
+}
+
+func (m *ModerationUsecase) Timeout() time.Duration {
+	return m.cfg.Timeout
+}
+
+// CheckPrompt returns ErrPromptBlocked if the prompt of the Telegram user is flagged with a blocking category,
+// the incident is logged. Every prompt is allowed if moderation is turned off.
+func (m *ModerationUsecase) CheckPrompt(ctx context.Context, telegramUserID int64, prompt string) error {
+	if !m.cfg.Enabled || len(strings.TrimSpace(prompt)) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+	categories, err := m.Moderator.Moderate(ctx, prompt)
+	if err != nil {
+		return fmt.Errorf("failed to moderate prompt: %w", err)
+	}
+	if len(m.cfg.Categories) != 0 {
+		categories = slices.DeleteFunc(
+			categories, func(category string) bool {
+				return !slices.Contains(m.cfg.Categories, category)
+			},
+		)
+	}
+	if len(categories) == 0 {
+		return nil
+	}
+	slices.Sort(categories)
+	log.Printf(
+		"moderation blocked prompt of telegram user %v (%d characters): %s\n", telegramUserID, len([]rune(prompt)),
+		strings.Join(slices.Compact(categories), ", "),
+	)
+	return ErrPromptBlocked
+}
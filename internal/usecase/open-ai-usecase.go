@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"github.com/iamvkosarev/ai-telegram-bot/config"
 	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/tool"
 	openai_tools "github.com/iamvkosarev/ai-telegram-bot/pkg/openai-tools"
 	"io"
 	"log"
+	"slices"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
@@ -17,11 +19,14 @@ import (
 const (
 	OpenAIRoleUser      = "user"
 	OpenAIRoleAssistant = "assistant"
+	OpenAIRoleSystem    = "system"
+	OpenAIRoleTool      = "tool"
 	OpenAIRoleUnknown   = "unknown"
 )
 
 type OpenAIUsecase struct {
-	cfg config.OpenAI
+	cfg   config.OpenAI
+	tools *tool.Registry
 }
 
 type UserState struct {
@@ -31,14 +36,28 @@ type UserState struct {
 	SelectedModel  string
 }
 
-func NewOpenAIUsecase(cfg config.OpenAI) *OpenAIUsecase {
+func NewOpenAIUsecase(cfg config.OpenAI, tools *tool.Registry) *OpenAIUsecase {
 	return &OpenAIUsecase{
-		cfg: cfg,
+		cfg:   cfg,
+		tools: tools,
 	}
 }
 
-func (gpt *OpenAIUsecase) SendMessage(msg string, chat model.AIChat, answerChan chan<- string) (bool, error) {
-	messageHistory := make([]openai.ChatCompletionMessage, 0, len(chat.Messages)+1)
+// SendMessage streams the model answer to answerChan and returns the final answer text with tokens and cost
+// of all completions of the answer. When the model calls tools from toolNames, they are executed and the
+// completion continues with their results. contextMessages are sent as system messages right before msg and
+// are not stored in the chat.
+func (gpt *OpenAIUsecase) SendMessage(
+	ctx context.Context,
+	msg string,
+	chat model.AIChat,
+	contextMessages []string,
+	toolNames []string,
+	answerChan chan<- model.AnswerProgress,
+) (string, model.Usage, bool, error) {
+	defer close(answerChan)
+
+	messageHistory := make([]openai.ChatCompletionMessage, 0, len(chat.Messages)+len(contextMessages)+1)
 	for _, message := range chat.Messages {
 		messageHistory = append(
 			messageHistory, openai.ChatCompletionMessage{
@@ -47,6 +66,14 @@ func (gpt *OpenAIUsecase) SendMessage(msg string, chat model.AIChat, answerChan
 			},
 		)
 	}
+	for _, contextMessage := range contextMessages {
+		messageHistory = append(
+			messageHistory, openai.ChatCompletionMessage{
+				Role:    OpenAIRoleSystem,
+				Content: contextMessage,
+			},
+		)
+	}
 	messageHistory = append(
 		messageHistory, openai.ChatCompletionMessage{
 			Role:    OpenAIRoleUser,
@@ -58,8 +85,18 @@ func (gpt *OpenAIUsecase) SendMessage(msg string, chat model.AIChat, answerChan
 		messageHistory = messageHistory[1:]
 		fmt.Println("History trimmed due to token limit")
 	}
+	// The system prompt of the chat persona is never trimmed.
+	systemMessages := make([]openai.ChatCompletionMessage, 0, 1)
+	if len(chat.SystemPrompt) != 0 {
+		systemMessages = append(
+			systemMessages, openai.ChatCompletionMessage{
+				Role:    OpenAIRoleSystem,
+				Content: chat.SystemPrompt,
+			},
+		)
+	}
 	for len(messageHistory) > 0 {
-		tokenCount, err := openai_tools.CountToken(messageHistory, chat.Model)
+		tokenCount, err := openai_tools.CountToken(append(systemMessages, messageHistory...), chat.Model)
 		if err != nil {
 			fmt.Println("count token error:", err)
 
@@ -74,10 +111,11 @@ func (gpt *OpenAIUsecase) SendMessage(msg string, chat model.AIChat, answerChan
 		trimHistory()
 	}
 
+	messageHistory = append(systemMessages, messageHistory...)
+
 	clientConfig := openai.DefaultConfig(gpt.cfg.OpenAIAPIKey)
 	clientConfig.BaseURL = gpt.cfg.OpenAIBaseURL
 	c := openai.NewClientWithConfig(clientConfig)
-	ctx := context.Background()
 
 	req := openai.ChatCompletionRequest{
 		Model:       chat.Model,
@@ -87,36 +125,272 @@ func (gpt *OpenAIUsecase) SendMessage(msg string, chat model.AIChat, answerChan
 		// TODO: add to config or Roles
 		// PresencePenalty:  0.2,
 		// FrequencyPenalty: 0.2,
-		Messages: messageHistory,
-		Stream:   true,
+		Stream: true,
+		StreamOptions: &openai.StreamOptions{
+			IncludeUsage: true,
+		},
+	}
+	for _, t := range gpt.tools.List(toolNames) {
+		req.Tools = append(
+			req.Tools, openai.Tool{
+				Type: openai.ToolTypeFunction,
+				Function: &openai.FunctionDefinition{
+					Name:        t.Name,
+					Description: t.Description,
+					Parameters:  t.Parameters,
+				},
+			},
+		)
+	}
+
+	var progress model.AnswerProgress
+	var usage model.Usage
+	for iteration := 0; ; iteration++ {
+		// The last iteration is sent without tools, so the model has to answer with text.
+		if iteration == gpt.cfg.MaxToolIterations {
+			req.Tools = nil
+		}
+		req.Messages = messageHistory
+
+		answer, toolCalls, completionUsage, err := gpt.streamCompletion(ctx, c, req, progress, answerChan)
+		usage = usage.Add(gpt.addCost(chat.Model, completionUsage))
+		if err != nil {
+			return "", usage, false, err
+		}
+		// Text answered before tool calls is kept, so the answer has the text of all iterations.
+		progress.Text = joinAnswerText(progress.Text, answer)
+		if len(toolCalls) == 0 {
+			break
+		}
+
+		messageHistory = append(
+			messageHistory, openai.ChatCompletionMessage{
+				Role:      OpenAIRoleAssistant,
+				Content:   answer,
+				ToolCalls: toolCalls,
+			},
+		)
+		for _, toolCall := range toolCalls {
+			progress.ToolCalls = append(
+				progress.ToolCalls, model.ToolCall{
+					Name:   toolCall.Function.Name,
+					Status: model.ToolCallStatusRunning,
+				},
+			)
+			answerChan <- copyAnswerProgress(progress)
+
+			result, status := gpt.callTool(ctx, toolNames, toolCall)
+			progress.ToolCalls[len(progress.ToolCalls)-1].Status = status
+			answerChan <- copyAnswerProgress(progress)
+
+			messageHistory = append(
+				messageHistory, openai.ChatCompletionMessage{
+					Role:       OpenAIRoleTool,
+					Content:    result,
+					ToolCallID: toolCall.ID,
+				},
+			)
+		}
+	}
+	return progress.Text, usage, false, nil
+}
+
+// Complete returns a single answer of aiModel to msg without chat history, streaming and tools, and its usage. The
+// answer is limited by maxTokens.
+func (gpt *OpenAIUsecase) Complete(
+	ctx context.Context,
+	aiModel string,
+	systemPrompt string,
+	msg string,
+	maxTokens int,
+) (string, model.Usage, error) {
+	messages := make([]openai.ChatCompletionMessage, 0, 2)
+	if len(systemPrompt) != 0 {
+		messages = append(
+			messages, openai.ChatCompletionMessage{
+				Role:    OpenAIRoleSystem,
+				Content: systemPrompt,
+			},
+		)
+	}
+	messages = append(
+		messages, openai.ChatCompletionMessage{
+			Role:    OpenAIRoleUser,
+			Content: msg,
+		},
+	)
+
+	clientConfig := openai.DefaultConfig(gpt.cfg.OpenAIAPIKey)
+	clientConfig.BaseURL = gpt.cfg.OpenAIBaseURL
+	c := openai.NewClientWithConfig(clientConfig)
+
+	req := openai.ChatCompletionRequest{
+		Model:     aiModel,
+		Messages:  messages,
+		MaxTokens: maxTokens,
+		N:         1,
+	}
+	response, err := c.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", model.Usage{}, fmt.Errorf("failed to create chat completion: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", model.Usage{}, errors.New("empty chat completion")
+	}
+	answer := response.Choices[0].Message.Content
+	usage := model.Usage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
 	}
+	if usage.TotalTokens() == 0 {
+		usage = countUsage(req, answer, nil)
+	}
+	return answer, gpt.addCost(aiModel, usage), nil
+}
 
+// streamCompletion streams one completion. Text is sent to answerChan after the text of the progress as it
+// arrives, tool calls are collected from their deltas and returned when the stream ends with the usage from the
+// last chunk. Servers ignoring stream_options don't send the usage, then it is counted by the tokenizer. If the
+// stream breaks, the error is returned with the usage of the received part.
+func (gpt *OpenAIUsecase) streamCompletion(
+	ctx context.Context,
+	c *openai.Client,
+	req openai.ChatCompletionRequest,
+	progress model.AnswerProgress,
+	answerChan chan<- model.AnswerProgress,
+) (string, []openai.ToolCall, model.Usage, error) {
 	stream, err := c.CreateChatCompletionStream(ctx, req)
 	if err != nil {
 		log.Print(err)
-		return false, err
+		return "", nil, model.Usage{}, err
 	}
+	defer stream.Close()
 
+	previousText := progress.Text
 	var currentAnswer string
-
-	defer stream.Close()
+	var usage model.Usage
+	toolCalls := make([]openai.ToolCall, 0)
 	for {
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			close(answerChan)
 			break
 		}
 
 		if err != nil {
-			fmt.Printf("Stream error: %v\n", err)
-			close(answerChan)
-			break
+			if usage.TotalTokens() == 0 {
+				usage = countUsage(req, currentAnswer, toolCalls)
+			}
+			return "", nil, usage, fmt.Errorf("failed to receive completion stream: %w", err)
+		}
+		// The usage comes in the last chunk without choices.
+		if response.Usage != nil {
+			usage.PromptTokens = response.Usage.PromptTokens
+			usage.CompletionTokens = response.Usage.CompletionTokens
+		}
+		if len(response.Choices) == 0 {
+			continue
+		}
+
+		delta := response.Choices[0].Delta
+		for _, toolCallDelta := range delta.ToolCalls {
+			index := len(toolCalls)
+			if toolCallDelta.Index != nil {
+				index = *toolCallDelta.Index
+			}
+			for len(toolCalls) <= index {
+				toolCalls = append(toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+			}
+			if len(toolCallDelta.ID) != 0 {
+				toolCalls[index].ID = toolCallDelta.ID
+			}
+			toolCalls[index].Function.Name += toolCallDelta.Function.Name
+			toolCalls[index].Function.Arguments += toolCallDelta.Function.Arguments
 		}
 
-		currentAnswer += response.Choices[0].Delta.Content
-		answerChan <- currentAnswer
+		if len(delta.Content) != 0 {
+			currentAnswer += delta.Content
+			progress.Text = joinAnswerText(previousText, currentAnswer)
+			answerChan <- copyAnswerProgress(progress)
+		}
+	}
+	if usage.TotalTokens() == 0 {
+		usage = countUsage(req, currentAnswer, toolCalls)
+	}
+	return currentAnswer, toolCalls, usage, nil
+}
+
+// joinAnswerText appends the text of the answer to the text answered before tool calls.
+func joinAnswerText(text, answer string) string {
+	if len(text) == 0 || len(answer) == 0 {
+		return text + answer
 	}
-	return false, nil
+	return text + "\n\n" + answer
+}
+
+// countUsage counts tokens of the completion request and the answer, when the server hasn't sent them.
+func countUsage(req openai.ChatCompletionRequest, answer string, toolCalls []openai.ToolCall) model.Usage {
+	promptTokens, err := openai_tools.CountToken(req.Messages, req.Model)
+	if err != nil {
+		log.Printf("failed to count prompt tokens: %v\n", err)
+		return model.Usage{}
+	}
+	answerMessages := []openai.ChatCompletionMessage{{Role: OpenAIRoleAssistant, Content: answer}}
+	for _, toolCall := range toolCalls {
+		answerMessages = append(
+			answerMessages, openai.ChatCompletionMessage{
+				Role:    OpenAIRoleAssistant,
+				Content: toolCall.Function.Name + toolCall.Function.Arguments,
+			},
+		)
+	}
+	completionTokens, err := openai_tools.CountToken(answerMessages, req.Model)
+	if err != nil {
+		log.Printf("failed to count completion tokens: %v\n", err)
+		return model.Usage{}
+	}
+	return model.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	}
+}
+
+// addCost sets the cost of the usage from the prices config, usage of models without price costs nothing.
+func (gpt *OpenAIUsecase) addCost(aiModel string, usage model.Usage) model.Usage {
+	price, ok := gpt.cfg.Prices[aiModel]
+	if !ok {
+		return usage
+	}
+	usage.Cost = (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) /
+		1_000_000
+	return usage
+}
+
+// callTool executes the tool call and returns the result for the model. Errors are returned to the
+// model as the result, so it can recover from them.
+func (gpt *OpenAIUsecase) callTool(
+	ctx context.Context,
+	toolNames []string,
+	toolCall openai.ToolCall,
+) (string, model.ToolCallStatus) {
+	name := toolCall.Function.Name
+	if !slices.Contains(toolNames, name) {
+		return fmt.Sprintf("error: tool %s is not available", name), model.ToolCallStatusFailed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gpt.cfg.ToolCallTimeout)
+	defer cancel()
+
+	result, err := gpt.tools.Call(ctx, name, toolCall.Function.Arguments)
+	if err != nil {
+		log.Printf("tool %s call failed: %v\n", name, err)
+		return fmt.Sprintf("error: %v", err), model.ToolCallStatusFailed
+	}
+	return result, model.ToolCallStatusDone
+}
+
+func copyAnswerProgress(progress model.AnswerProgress) model.AnswerProgress {
+	progress.ToolCalls = slices.Clone(progress.ToolCalls)
+	return progress
 }
 
 func parseMessageSourceToRole(source model.MessageSource) string {
@@ -125,6 +399,8 @@ func parseMessageSourceToRole(source model.MessageSource) string {
 		return OpenAIRoleUser
 	case model.MessageSourceAssistant:
 		return OpenAIRoleAssistant
+	case model.MessageSourceContext:
+		return OpenAIRoleSystem
 	default:
 		return OpenAIRoleUnknown
 	}
@@ -0,0 +1,213 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"time"
+)
+
+// PaymentCurrency is the currency of payments in Telegram Stars.
+const PaymentCurrency = "XTR"
+
+var (
+	ErrProductDoesNotExist     = errors.New("product doesn't exist")
+	ErrInvalidPaymentAmount    = errors.New("invalid payment amount")
+	ErrPaymentAlreadyCompleted = errors.New("payment already completed")
+	ErrPaymentAlreadyRefunded  = errors.New("payment already refunded")
+)
+
+type PaymentStorage interface {
+	CreatePayment(ctx context.Context, payment model.Payment) (bool, error)
+	GetPayment(ctx context.Context, chargeID string) (model.Payment, error)
+	ListUserPayments(ctx context.Context, telegramID int64) ([]model.Payment, error)
+	MarkPaymentRefunded(ctx context.Context, chargeID string, refundedAt time.Time) (bool, error)
+}
+
+type PaymentUsecaseDeps struct {
+	PaymentStorage PaymentStorage
+	User           *UserUsecase
+	Quota          *QuotaUsecase
+}
+
+// CompletedPayment is the payment with the result of the purchase: the expiry of the bought role or the token
+// credits of the user.
+type CompletedPayment struct {
+	Payment       model.Payment
+	RoleExpiresAt time.Time
+	Credits       int
+}
+
+// PaymentUsecase sells products from the payments config for Telegram Stars.
+type PaymentUsecase struct {
+	PaymentUsecaseDeps
+	cfg config.Payments
+}
+
+func NewPaymentUsecase(deps PaymentUsecaseDeps, cfg config.Payments) *PaymentUsecase {
+	return &PaymentUsecase{
+		PaymentUsecaseDeps: deps,
+		cfg:                cfg,
+	}
+}
+
+func (p *PaymentUsecase) Enabled() bool {
+	return p.cfg.Enabled && len(p.cfg.Products) != 0
+}
+
+func (p *PaymentUsecase) Products() []config.Product {
+	return p.cfg.Products
+}
+
+func (p *PaymentUsecase) GetProduct(id string) (config.Product, error) {
+	index := slices.IndexFunc(
+		p.cfg.Products, func(product config.Product) bool {
+			return product.ID == id
+		},
+	)
+	if !p.Enabled() || index < 0 {
+		return config.Product{}, ErrProductDoesNotExist
+	}
+	return p.cfg.Products[index], nil
+}
+
+// SellsRole reports whether the role can be bought.
+func (p *PaymentUsecase) SellsRole(role model.UserRole) bool {
+	return p.Enabled() && slices.ContainsFunc(
+		p.cfg.Products, func(product config.Product) bool {
+			return model.ParseUserRole(product.Role) == role
+		},
+	)
+}
+
+// CheckPreCheckout checks the order before the user pays. The payload of invoices is the product ID.
+// ErrUserAlreadyHasRole is returned with the product if the user has the role of the product permanently.
+func (p *PaymentUsecase) CheckPreCheckout(
+	ctx context.Context,
+	telegramID int64,
+	payload, currency string,
+	amount int,
+) (config.Product, error) {
+	product, err := p.GetProduct(payload)
+	if err != nil {
+		return config.Product{}, err
+	}
+	if currency != PaymentCurrency || amount != product.Stars {
+		return config.Product{}, ErrInvalidPaymentAmount
+	}
+	if len(product.Role) == 0 {
+		return product, nil
+	}
+	user, err := p.User.GetUserInfoForTelegramUser(ctx, telegramID)
+	if err != nil {
+		return config.Product{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	role := model.ParseUserRole(product.Role)
+	if _, hasExpiry := user.RoleExpiresAt[role]; slices.Contains(user.Roles, role) && !hasExpiry {
+		return product, ErrUserAlreadyHasRole
+	}
+	return product, nil
+}
+
+// CompletePayment gives the product to the user. Repeated payments return ErrPaymentAlreadyCompleted. If the
+// user got the role of the product permanently after the pre-checkout, the payment is saved and
+// ErrUserAlreadyHasRole is returned, so it can be refunded.
+func (p *PaymentUsecase) CompletePayment(
+	ctx context.Context,
+	telegramID int64,
+	chargeID, payload string,
+	amount int,
+) (CompletedPayment, error) {
+	product, err := p.GetProduct(payload)
+	if err != nil {
+		return CompletedPayment{}, err
+	}
+	payment := model.Payment{
+		ChargeID:   chargeID,
+		TelegramID: telegramID,
+		ProductID:  product.ID,
+		Stars:      amount,
+		Role:       model.ParseUserRole(product.Role),
+		Duration:   product.Duration,
+		Tokens:     product.Tokens,
+		CreatedAt:  time.Now(),
+	}
+	created, err := p.PaymentStorage.CreatePayment(ctx, payment)
+	if err != nil {
+		return CompletedPayment{}, fmt.Errorf("failed to create payment: %w", err)
+	}
+	if !created {
+		return CompletedPayment{}, ErrPaymentAlreadyCompleted
+	}
+	fmt.Printf("telegram user %v paid %v stars for product %s, charge %s\n", telegramID, amount, product.ID, chargeID)
+
+	completed := CompletedPayment{Payment: payment}
+	if len(payment.Role) != 0 {
+		completed.RoleExpiresAt, err = p.User.ExtendRole(ctx, telegramID, payment.Role, payment.Duration)
+		if err != nil {
+			return completed, fmt.Errorf("failed to extend role: %w", err)
+		}
+		return completed, nil
+	}
+	user, err := p.User.GetUserInfoForTelegramUser(ctx, telegramID)
+	if err != nil {
+		return completed, fmt.Errorf("failed to get user: %w", err)
+	}
+	if completed.Credits, err = p.Quota.AddCredits(ctx, user, payment.Tokens); err != nil {
+		return completed, err
+	}
+	return completed, nil
+}
+
+// RevertPayment marks the refunded payment and takes back what it gave: the duration of the role or the token
+// credits. ErrPaymentAlreadyRefunded is returned if the payment was already reverted.
+func (p *PaymentUsecase) RevertPayment(ctx context.Context, chargeID string) (model.Payment, error) {
+	payment, err := p.PaymentStorage.GetPayment(ctx, chargeID)
+	if err != nil {
+		return model.Payment{}, err
+	}
+	payment.RefundedAt = time.Now()
+	marked, err := p.PaymentStorage.MarkPaymentRefunded(ctx, chargeID, payment.RefundedAt)
+	if err != nil {
+		return model.Payment{}, err
+	}
+	if !marked {
+		return model.Payment{}, ErrPaymentAlreadyRefunded
+	}
+	fmt.Printf("payment %s of telegram user %v was refunded\n", chargeID, payment.TelegramID)
+
+	if len(payment.Role) != 0 {
+		if err = p.User.ShortenRole(ctx, payment.TelegramID, payment.Role, payment.Duration); err != nil {
+			return payment, fmt.Errorf("failed to shorten role: %w", err)
+		}
+		return payment, nil
+	}
+	user, err := p.User.GetUserInfoForTelegramUser(ctx, payment.TelegramID)
+	if err != nil {
+		return payment, fmt.Errorf("failed to get user: %w", err)
+	}
+	if _, err = p.Quota.AddCredits(ctx, user, -payment.Tokens); err != nil {
+		return payment, err
+	}
+	return payment, nil
+}
+
+// GetProductTitle returns the title of the product, or its ID if the product was removed from the config.
+func (p *PaymentUsecase) GetProductTitle(id string) string {
+	product, err := p.GetProduct(id)
+	if err != nil {
+		return id
+	}
+	return product.Title
+}
+
+func (p *PaymentUsecase) GetPayment(ctx context.Context, chargeID string) (model.Payment, error) {
+	return p.PaymentStorage.GetPayment(ctx, chargeID)
+}
+
+func (p *PaymentUsecase) ListUserPayments(ctx context.Context, telegramID int64) ([]model.Payment, error) {
+	return p.PaymentStorage.ListUserPayments(ctx, telegramID)
+}
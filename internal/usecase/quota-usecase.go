@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"log"
+	"time"
+)
+
+type QuotaKind string
+
+const (
+	QuotaRequests QuotaKind = "requests"
+	QuotaTokens   QuotaKind = "tokens"
+	QuotaSpend    QuotaKind = "spend"
+)
+
+var (
+	// requestsWindow counts requests of the last day, spendWindow counts tokens and spend of the last 30 days.
+	requestsWindow = usageWindow{period: 24 * time.Hour, bucket: time.Hour}
+	spendWindow    = usageWindow{period: 30 * 24 * time.Hour, bucket: 24 * time.Hour}
+)
+
+// QuotaExceededError is returned when the user has used up the quota before it resets.
+type QuotaExceededError struct {
+	Kind    QuotaKind
+	Quota   model.Quota
+	ResetAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded until %s", e.Kind, e.ResetAt.Format(time.RFC3339))
+}
+
+type UsageStorage interface {
+	IncrementRequests(ctx context.Context, userID uuid.UUID, buckets []time.Time, ttl time.Duration) ([]int, error)
+	DecrementRequests(ctx context.Context, userID uuid.UUID, bucket time.Time) error
+	AddUsage(
+		ctx context.Context,
+		userID uuid.UUID,
+		buckets []time.Time,
+		usage model.Usage,
+		ttl time.Duration,
+	) ([]int, error)
+	GetUsage(ctx context.Context, userID uuid.UUID, requestBuckets, spendBuckets []time.Time) (
+		[]int,
+		[]int,
+		[]float64,
+		error,
+	)
+	GetTotalUsage(ctx context.Context, userID uuid.UUID) (model.Usage, error)
+	AddCredits(ctx context.Context, userID uuid.UUID, tokens int) (int, error)
+	GetCredits(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+type QuotaUsecaseDeps struct {
+	UsageStorage UsageStorage
+}
+
+// QuotaUsecase limits requests per day, tokens and spend per month of chat models. Periods are rolling: requests
+// are counted for the last 24 hours, tokens and spend for the last 30 days. Token credits are spent when the month
+// tokens are used up.
+type QuotaUsecase struct {
+	QuotaUsecaseDeps
+	roleQuotas map[model.UserRole]config.RoleQuotas
+}
+
+func NewQuotaUsecase(deps QuotaUsecaseDeps, roles []config.Role) *QuotaUsecase {
+	roleQuotas := make(map[model.UserRole]config.RoleQuotas)
+	for _, role := range roles {
+		roleQuotas[model.ParseUserRole(role.Role)] = role.Quotas
+	}
+	return &QuotaUsecase{
+		QuotaUsecaseDeps: deps,
+		roleQuotas:       roleQuotas,
+	}
+}
+
+// GetQuota returns the largest quotas of the user roles. A quota is unlimited if a role has negative quota or
+// no role sets it.
+func (q *QuotaUsecase) GetQuota(user model.User) model.Quota {
+	requests := make([]float64, 0, len(user.Roles))
+	tokens := make([]float64, 0, len(user.Roles))
+	spend := make([]float64, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roleQuotas := q.roleQuotas[role]
+		requests = append(requests, float64(roleQuotas.RequestsPerDay))
+		tokens = append(tokens, float64(roleQuotas.TokensPerMonth))
+		spend = append(spend, roleQuotas.SpendPerMonth)
+	}
+	return model.Quota{
+		RequestsPerDay: int(getLargestQuota(requests)),
+		TokensPerMonth: int(getLargestQuota(tokens)),
+		SpendPerMonth:  getLargestQuota(spend),
+	}
+}
+
+// ReserveRequest counts a request of the user. QuotaExceededError is returned, and the request isn't counted,
+// if any quota is used up.
+func (q *QuotaUsecase) ReserveRequest(ctx context.Context, user model.User) error {
+	quota := q.GetQuota(user)
+	now := time.Now()
+	usage, err := q.getUsage(ctx, user, now)
+	if err != nil {
+		return err
+	}
+	if quota.TokensPerMonth != 0 && usage.Tokens >= quota.TokensPerMonth && usage.Credits <= 0 {
+		return &QuotaExceededError{Kind: QuotaTokens, Quota: quota, ResetAt: usage.SpendResetAt}
+	}
+	if quota.SpendPerMonth != 0 && usage.Spend >= quota.SpendPerMonth {
+		return &QuotaExceededError{Kind: QuotaSpend, Quota: quota, ResetAt: usage.SpendResetAt}
+	}
+
+	buckets := requestsWindow.buckets(now)
+	requests, err := q.UsageStorage.IncrementRequests(ctx, user.UserID, buckets, requestsWindow.ttl())
+	if err != nil {
+		return fmt.Errorf("failed to count request: %w", err)
+	}
+	if quota.RequestsPerDay != 0 && sum(requests) > quota.RequestsPerDay {
+		if err = q.UsageStorage.DecrementRequests(ctx, user.UserID, buckets[len(buckets)-1]); err != nil {
+			log.Printf("failed to return refused request: %v\n", err)
+		}
+		resetAt := requestsWindow.resetAt(
+			buckets, func(i int) bool {
+				return requests[i] != 0
+			},
+		)
+		return &QuotaExceededError{Kind: QuotaRequests, Quota: quota, ResetAt: resetAt}
+	}
+	return nil
+}
+
+// RecordUsage adds tokens and cost of the answer to the usage of the user. Tokens over the month quota are taken
+// from the credits.
+func (q *QuotaUsecase) RecordUsage(ctx context.Context, user model.User, usage model.Usage) error {
+	buckets := spendWindow.buckets(time.Now())
+	bucketTokens, err := q.UsageStorage.AddUsage(ctx, user.UserID, buckets, usage, spendWindow.ttl())
+	if err != nil {
+		return fmt.Errorf("failed to add usage: %w", err)
+	}
+	tokens := sum(bucketTokens)
+	quota := q.GetQuota(user)
+	if quota.TokensPerMonth == 0 || tokens <= quota.TokensPerMonth {
+		return nil
+	}
+	overflow := min(tokens-quota.TokensPerMonth, usage.TotalTokens())
+	if _, err = q.UsageStorage.AddCredits(ctx, user.UserID, -overflow); err != nil {
+		return fmt.Errorf("failed to spend credits: %w", err)
+	}
+	return nil
+}
+
+// AddCredits adds bought tokens to the credits of the user. Negative tokens are taken back.
+func (q *QuotaUsecase) AddCredits(ctx context.Context, user model.User, tokens int) (int, error) {
+	credits, err := q.UsageStorage.AddCredits(ctx, user.UserID, tokens)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add credits: %w", err)
+	}
+	return credits, nil
+}
+
+func (q *QuotaUsecase) GetUsage(ctx context.Context, user model.User) (model.QuotaUsage, error) {
+	return q.getUsage(ctx, user, time.Now())
+}
+
+func (q *QuotaUsecase) getUsage(ctx context.Context, user model.User, now time.Time) (model.QuotaUsage, error) {
+	requestBuckets := requestsWindow.buckets(now)
+	spendBuckets := spendWindow.buckets(now)
+	requests, tokens, spend, err := q.UsageStorage.GetUsage(ctx, user.UserID, requestBuckets, spendBuckets)
+	if err != nil {
+		return model.QuotaUsage{}, fmt.Errorf("failed to get usage: %w", err)
+	}
+	total, err := q.UsageStorage.GetTotalUsage(ctx, user.UserID)
+	if err != nil {
+		return model.QuotaUsage{}, fmt.Errorf("failed to get total usage: %w", err)
+	}
+	credits, err := q.UsageStorage.GetCredits(ctx, user.UserID)
+	if err != nil {
+		return model.QuotaUsage{}, fmt.Errorf("failed to get credits: %w", err)
+	}
+	return model.QuotaUsage{
+		Requests: sum(requests),
+		Tokens:   sum(tokens),
+		Spend:    sum(spend),
+		RequestsResetAt: requestsWindow.resetAt(
+			requestBuckets, func(i int) bool {
+				return requests[i] != 0
+			},
+		),
+		SpendResetAt: spendWindow.resetAt(
+			spendBuckets, func(i int) bool {
+				return tokens[i] != 0 || spend[i] != 0
+			},
+		),
+		Total:   total,
+		Credits: credits,
+	}, nil
+}
+
+// usageWindow is a rolling quota period. Usage is counted in buckets, so the window moves by a bucket at a time.
+type usageWindow struct {
+	period time.Duration
+	bucket time.Duration
+}
+
+// buckets returns starts of buckets in the window, from the oldest one to the current one.
+func (w usageWindow) buckets(now time.Time) []time.Time {
+	count := int(w.period / w.bucket)
+	current := now.UTC().Truncate(w.bucket)
+	buckets := make([]time.Time, 0, count)
+	for i := count - 1; i >= 0; i-- {
+		buckets = append(buckets, current.Add(-time.Duration(i)*w.bucket))
+	}
+	return buckets
+}
+
+// ttl is how long usage of a bucket is kept, the bucket leaves the window after the period since its start.
+func (w usageWindow) ttl() time.Duration {
+	return w.period + w.bucket
+}
+
+// resetAt returns when the oldest used bucket leaves the window, so the usage starts to go down. The window of
+// the current bucket is returned if nothing is used.
+func (w usageWindow) resetAt(buckets []time.Time, isUsed func(i int) bool) time.Time {
+	for i, bucket := range buckets {
+		if isUsed(i) {
+			return bucket.Add(w.period)
+		}
+	}
+	return buckets[len(buckets)-1].Add(w.period)
+}
+
+func sum[T int | float64](values []T) T {
+	var total T
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func getLargestQuota(quotas []float64) float64 {
+	largest := 0.0
+	for _, quota := range quotas {
+		if quota < 0 {
+			return 0
+		}
+		largest = max(largest, quota)
+	}
+	return largest
+}
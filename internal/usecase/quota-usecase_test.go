@@ -0,0 +1,49 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageWindowBuckets(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 15, 42, 7, 0, time.UTC)
+	buckets := requestsWindow.buckets(now)
+	if len(buckets) != 24 {
+		t.Fatalf("got %v buckets, want 24", len(buckets))
+	}
+	if want := time.Date(2024, time.March, 9, 16, 0, 0, 0, time.UTC); !buckets[0].Equal(want) {
+		t.Errorf("oldest bucket = %v, want %v", buckets[0], want)
+	}
+	if want := time.Date(2024, time.March, 10, 15, 0, 0, 0, time.UTC); !buckets[len(buckets)-1].Equal(want) {
+		t.Errorf("current bucket = %v, want %v", buckets[len(buckets)-1], want)
+	}
+
+	buckets = spendWindow.buckets(now)
+	if len(buckets) != 30 {
+		t.Fatalf("got %v buckets, want 30", len(buckets))
+	}
+	if want := time.Date(2024, time.February, 10, 0, 0, 0, 0, time.UTC); !buckets[0].Equal(want) {
+		t.Errorf("oldest bucket = %v, want %v", buckets[0], want)
+	}
+}
+
+func TestUsageWindowResetAt(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 15, 42, 7, 0, time.UTC)
+	buckets := requestsWindow.buckets(now)
+	requests := make([]int, len(buckets))
+	isUsed := func(i int) bool {
+		return requests[i] != 0
+	}
+
+	want := time.Date(2024, time.March, 11, 15, 0, 0, 0, time.UTC)
+	if got := requestsWindow.resetAt(buckets, isUsed); !got.Equal(want) {
+		t.Errorf("resetAt() without usage = %v, want %v", got, want)
+	}
+	requests[3] = 2
+	requests[10] = 1
+	// The bucket started at 19:00 yesterday leaves the window at 19:00 today.
+	want = time.Date(2024, time.March, 10, 19, 0, 0, 0, time.UTC)
+	if got := requestsWindow.resetAt(buckets, isUsed); !got.Equal(want) {
+		t.Errorf("resetAt() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"sync"
+	"time"
+)
+
+var (
+	ErrGenerationInProgress = errors.New("generation already in progress")
+)
+
+// RateLimitedError is returned when the user sends messages faster than the rate limit of the user roles.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+// tokenBucket gets rate tokens per second up to burst tokens, every message takes one.
+type tokenBucket struct {
+	tokens    float64
+	rate      float64
+	burst     float64
+	updatedAt time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.updatedAt).Seconds()*b.rate)
+	b.updatedAt = now
+}
+
+// RateLimitUsecase limits messages of Telegram users with token buckets and doesn't let AI chats answer more
+// than one message at a time. Limits are kept in memory, so they are reset on restart.
+type RateLimitUsecase struct {
+	cfg        config.RateLimit
+	roleLimits map[model.UserRole]config.RoleRateLimit
+
+	mu      sync.Mutex
+	buckets map[int64]*tokenBucket
+	// generations are AI chats answering a message.
+	generations map[uuid.UUID]struct{}
+}
+
+func NewRateLimitUsecase(cfg config.RateLimit, roles []config.Role) *RateLimitUsecase {
+	roleLimits := make(map[model.UserRole]config.RoleRateLimit)
+	for _, role := range roles {
+		roleLimits[model.ParseUserRole(role.Role)] = role.RateLimit
+	}
+	return &RateLimitUsecase{
+		cfg:         cfg,
+		roleLimits:  roleLimits,
+		buckets:     make(map[int64]*tokenBucket),
+		generations: make(map[uuid.UUID]struct{}),
+	}
+}
+
+// GetRateLimit returns the fastest rate limit of the user roles. False is returned if the user is unlimited.
+func (r *RateLimitUsecase) GetRateLimit(user model.User) (config.RoleRateLimit, bool) {
+	var limit config.RoleRateLimit
+	for _, role := range user.Roles {
+		roleLimit := r.roleLimits[role]
+		if roleLimit.MessagesPerMinute < 0 {
+			return config.RoleRateLimit{}, false
+		}
+		limit.MessagesPerMinute = max(limit.MessagesPerMinute, roleLimit.MessagesPerMinute)
+		limit.Burst = max(limit.Burst, roleLimit.Burst)
+	}
+	if limit.MessagesPerMinute == 0 {
+		return config.RoleRateLimit{}, false
+	}
+	limit.Burst = max(limit.Burst, 1)
+	return limit, true
+}
+
+// Wait takes a token of the Telegram user. If there are no tokens, the message waits for its turn up to the
+// queue timeout, RateLimitedError is returned if it would wait longer.
+func (r *RateLimitUsecase) Wait(ctx context.Context, telegramUserID int64, user model.User) error {
+	limit, limited := r.GetRateLimit(user)
+	if !limited {
+		return nil
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	r.removeFullBuckets(now)
+	bucket, ok := r.buckets[telegramUserID]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updatedAt: now}
+		r.buckets[telegramUserID] = bucket
+	}
+	// Roles of the user may have changed since the last message.
+	bucket.rate = limit.MessagesPerMinute / 60
+	bucket.burst = float64(limit.Burst)
+	bucket.refill(now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		r.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+	if wait > r.cfg.QueueTimeout {
+		r.mu.Unlock()
+		return &RateLimitedError{RetryAfter: wait}
+	}
+	// The token is taken in advance, so queued messages go in turn.
+	bucket.tokens--
+	r.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		r.mu.Lock()
+		bucket.tokens++
+		r.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// removeFullBuckets forgets users whose buckets have refilled, they start with full buckets anyway.
+func (r *RateLimitUsecase) removeFullBuckets(now time.Time) {
+	for telegramUserID, bucket := range r.buckets {
+		if bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*bucket.rate >= bucket.burst {
+			delete(r.buckets, telegramUserID)
+		}
+	}
+}
+
+// StartGeneration marks the AI chat answering a message until the returned function is called.
+// ErrGenerationInProgress is returned if the AI chat is already answering.
+func (r *RateLimitUsecase) StartGeneration(aiChatID uuid.UUID) (func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.generations[aiChatID]; ok {
+		return nil, ErrGenerationInProgress
+	}
+	r.generations[aiChatID] = struct{}{}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.generations, aiChatID)
+	}, nil
+}
@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"log"
+	"time"
+)
+
+// roleExpiryUserTimeout limits the check of roles of one user, so slow users don't take the time of others.
+const roleExpiryUserTimeout = 5 * time.Second
+
+type RoleExpiryStorage interface {
+	GetUserInfo(ctx context.Context, userID uuid.UUID) (model.User, error)
+	ListUsersWithExpiringRoles(ctx context.Context, until time.Time) ([]uuid.UUID, error)
+	RemoveRoleExpiries(ctx context.Context, userID uuid.UUID) error
+	MarkRoleExpiryNotified(ctx context.Context, userID uuid.UUID, role model.UserRole, expiresAt time.Time) (
+		bool,
+		error,
+	)
+}
+
+type RoleExpiryUsecaseDeps struct {
+	RoleExpiryStorage RoleExpiryStorage
+	User              *UserUsecase
+}
+
+// RoleExpiryEvent is a role of the user which has expired or expires soon.
+type RoleExpiryEvent struct {
+	User      model.User
+	Role      model.UserRole
+	ExpiresAt time.Time
+	Expired   bool
+}
+
+// RoleExpiryUsecase removes roles given for a time when they expire and finds users to notify before that.
+type RoleExpiryUsecase struct {
+	RoleExpiryUsecaseDeps
+	cfg config.RoleExpiry
+}
+
+func NewRoleExpiryUsecase(deps RoleExpiryUsecaseDeps, cfg config.RoleExpiry) *RoleExpiryUsecase {
+	return &RoleExpiryUsecase{
+		RoleExpiryUsecaseDeps: deps,
+		cfg:                   cfg,
+	}
+}
+
+func (r *RoleExpiryUsecase) CheckInterval() time.Duration {
+	return r.cfg.CheckInterval
+}
+
+// CheckRoles removes roles expired by the time and returns them with roles expiring within the notify period.
+// Every expiry is returned once, so the users are notified once. Users whose roles fail to be checked are logged
+// and retried on the next check, users which don't exist anymore are forgotten.
+func (r *RoleExpiryUsecase) CheckRoles(ctx context.Context, now time.Time) ([]RoleExpiryEvent, error) {
+	listCtx, cancel := context.WithTimeout(ctx, roleExpiryUserTimeout)
+	userIDs, err := r.RoleExpiryStorage.ListUsersWithExpiringRoles(listCtx, now.Add(r.cfg.NotifyBefore))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]RoleExpiryEvent, 0, len(userIDs))
+	for _, userID := range userIDs {
+		userEvents, err := r.checkUserRoles(ctx, userID, now)
+		if err != nil {
+			log.Printf("failed to check roles of user %s: %v\n", userID, err)
+		}
+		events = append(events, userEvents...)
+	}
+	return events, nil
+}
+
+// checkUserRoles returns expiry events of the user, events found before a failure are returned with the error.
+func (r *RoleExpiryUsecase) checkUserRoles(ctx context.Context, userID uuid.UUID, now time.Time) (
+	[]RoleExpiryEvent,
+	error,
+) {
+	ctx, cancel := context.WithTimeout(ctx, roleExpiryUserTimeout)
+	defer cancel()
+
+	user, err := r.RoleExpiryStorage.GetUserInfo(ctx, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrUserDoesNotExist) {
+			return nil, r.RoleExpiryStorage.RemoveRoleExpiries(ctx, userID)
+		}
+		return nil, fmt.Errorf("failed to get user %s: %w", userID, err)
+	}
+	var events []RoleExpiryEvent
+	for role, expiresAt := range user.RoleExpiresAt {
+		event := RoleExpiryEvent{User: user, Role: role, ExpiresAt: expiresAt, Expired: !expiresAt.After(now)}
+		if event.Expired {
+			expired, err := r.User.ExpireRole(ctx, userID, role, expiresAt)
+			if err != nil {
+				return events, fmt.Errorf("failed to expire role %s: %w", role, err)
+			}
+			if !expired {
+				continue
+			}
+			fmt.Printf("role %s of user %s (Telegram:%v) expired\n", role, userID, user.TelegramID)
+			events = append(events, event)
+			continue
+		}
+		if r.cfg.NotifyBefore == 0 || expiresAt.Sub(now) > r.cfg.NotifyBefore {
+			continue
+		}
+		marked, err := r.RoleExpiryStorage.MarkRoleExpiryNotified(ctx, userID, role, expiresAt)
+		if err != nil {
+			return events, err
+		}
+		if marked {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"testing"
+	"time"
+)
+
+type fakeRoleExpiryStorage struct {
+	*fakeUserStorage
+	// deleted are listed users which don't exist anymore.
+	deleted []uuid.UUID
+	// broken are users failing to be read.
+	broken []uuid.UUID
+}
+
+func (f *fakeRoleExpiryStorage) GetUserInfo(ctx context.Context, userID uuid.UUID) (model.User, error) {
+	for _, brokenID := range f.broken {
+		if brokenID == userID {
+			return model.User{}, errors.New("failed to unmarshal user")
+		}
+	}
+	return f.fakeUserStorage.GetUserInfo(ctx, userID)
+}
+
+func (f *fakeRoleExpiryStorage) ListUsersWithExpiringRoles(_ context.Context, until time.Time) ([]uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Users which can't be checked come first, so they are checked before the others.
+	userIDs := append(append([]uuid.UUID(nil), f.deleted...), f.broken...)
+	for userID, user := range f.users {
+		for _, expiresAt := range user.RoleExpiresAt {
+			if !expiresAt.After(until) {
+				userIDs = append(userIDs, userID)
+				break
+			}
+		}
+	}
+	return userIDs, nil
+}
+
+func (f *fakeRoleExpiryStorage) RemoveRoleExpiries(_ context.Context, userID uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, deletedID := range f.deleted {
+		if deletedID == userID {
+			f.deleted = append(f.deleted[:i], f.deleted[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeRoleExpiryStorage) MarkRoleExpiryNotified(
+	_ context.Context,
+	_ uuid.UUID,
+	_ model.UserRole,
+	_ time.Time,
+) (bool, error) {
+	return true, nil
+}
+
+func TestRoleExpiryCheckRolesSkipsFailedUsers(t *testing.T) {
+	ctx := context.Background()
+	users := newFakeUserStorage()
+	storage := &fakeRoleExpiryStorage{
+		fakeUserStorage: users,
+		deleted:         []uuid.UUID{uuid.New()},
+		broken:          []uuid.UUID{uuid.New()},
+	}
+	userUsecase := NewUserUsecase(
+		UserUsecaseDeps{UserStorage: users}, config.Telegram{}, []config.Role{{Role: "default"}, {Role: "premium"}},
+	)
+	roleExpiry := NewRoleExpiryUsecase(
+		RoleExpiryUsecaseDeps{RoleExpiryStorage: storage, User: userUsecase}, config.RoleExpiry{},
+	)
+	if _, err := userUsecase.ExtendRole(ctx, 100, model.UserRolePremium, time.Hour); err != nil {
+		t.Fatalf("ExtendRole() error = %v", err)
+	}
+
+	events, err := roleExpiry.CheckRoles(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckRoles() error = %v", err)
+	}
+	if len(events) != 1 || events[0].User.TelegramID != 100 || !events[0].Expired {
+		t.Errorf("CheckRoles() events = %+v, want expired role of the readable user", events)
+	}
+	if len(storage.deleted) != 0 {
+		t.Errorf("deleted users are still listed: %v", storage.deleted)
+	}
+}
+
+func TestRoleExpiryRefusesExpiredModel(t *testing.T) {
+	ctx := context.Background()
+	users := newFakeUserStorage()
+	userUsecase := NewUserUsecase(
+		UserUsecaseDeps{UserStorage: users}, config.Telegram{}, []config.Role{{Role: "default"}, {Role: "premium"}},
+	)
+	roleExpiry := NewRoleExpiryUsecase(
+		RoleExpiryUsecaseDeps{RoleExpiryStorage: &fakeRoleExpiryStorage{fakeUserStorage: users}, User: userUsecase},
+		config.RoleExpiry{},
+	)
+	aiChat := newTestAiChatUsecase()
+	chat := model.AIChat{Model: "gpt-4.1"}
+
+	const telegramID = 100
+	if _, err := userUsecase.ExtendRole(ctx, telegramID, model.UserRolePremium, time.Hour); err != nil {
+		t.Fatalf("ExtendRole() error = %v", err)
+	}
+	user, err := userUsecase.GetUserInfoForTelegramUser(ctx, telegramID)
+	if err != nil {
+		t.Fatalf("GetUserInfoForTelegramUser() error = %v", err)
+	}
+	if !aiChat.CanUseChat(user, chat) {
+		t.Fatalf("premium model is refused before the role expires")
+	}
+
+	events, err := roleExpiry.CheckRoles(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckRoles() error = %v", err)
+	}
+	if len(events) != 1 || !events[0].Expired || events[0].Role != model.UserRolePremium {
+		t.Fatalf("CheckRoles() events = %+v, want expired premium role", events)
+	}
+	user, err = userUsecase.GetUserInfoForTelegramUser(ctx, telegramID)
+	if err != nil {
+		t.Fatalf("GetUserInfoForTelegramUser() error = %v", err)
+	}
+	if aiChat.CanUseChat(user, chat) {
+		t.Errorf("premium model is allowed after the role expired")
+	}
+	if !aiChat.CanUseChat(user, model.AIChat{Model: "gpt-4.1-nano"}) {
+		t.Errorf("default model is refused after the premium role expired")
+	}
+}
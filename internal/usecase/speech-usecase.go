@@ -0,0 +1,172 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEmptyTranscript = errors.New("empty transcript")
+	ErrAudioTooLarge   = errors.New("audio is too large")
+	// ErrSynthesisInProgress is returned if the answer is already being synthesized.
+	ErrSynthesisInProgress = errors.New("synthesis in progress")
+
+	DefaultVoiceSettings = model.VoiceSettings{
+		Voice:  "alloy",
+		Format: "opus",
+		Speed:  1,
+	}
+)
+
+type Transcriber interface {
+	Transcribe(ctx context.Context, fileName string, audio io.Reader) (string, error)
+}
+
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string, settings model.VoiceSettings) ([]byte, error)
+}
+
+type SpeechUsecaseDeps struct {
+	Transcriber Transcriber
+	Synthesizer Synthesizer
+}
+
+type roleVoiceSettings struct {
+	role     model.UserRole
+	settings model.VoiceSettings
+}
+
+// synthesisKey is the answer by its index in the AI chat.
+type synthesisKey struct {
+	aiChatID    uuid.UUID
+	answerIndex int
+}
+
+type SpeechUsecase struct {
+	SpeechUsecaseDeps
+	cfg config.Speech
+	// roleVoiceSettings keeps the config order of roles, the first role a user has wins.
+	roleVoiceSettings []roleVoiceSettings
+
+	mu        sync.Mutex
+	syntheses map[synthesisKey]struct{}
+}
+
+func NewSpeechUsecase(deps SpeechUsecaseDeps, cfg config.Speech, roles []config.Role) *SpeechUsecase {
+	settings := make([]roleVoiceSettings, 0, len(roles))
+	for _, role := range roles {
+		voiceSettings := DefaultVoiceSettings
+		if len(role.Voice.Voice) != 0 {
+			voiceSettings.Voice = role.Voice.Voice
+		}
+		if len(role.Voice.Format) != 0 {
+			voiceSettings.Format = role.Voice.Format
+		}
+		if role.Voice.Speed != 0 {
+			voiceSettings.Speed = role.Voice.Speed
+		}
+		settings = append(
+			settings, roleVoiceSettings{
+				role:     model.ParseUserRole(role.Role),
+				settings: voiceSettings,
+			},
+		)
+	}
+	return &SpeechUsecase{
+		SpeechUsecaseDeps: deps,
+		cfg:               cfg,
+		roleVoiceSettings: settings,
+		syntheses:         make(map[synthesisKey]struct{}),
+	}
+}
+
+func (s *SpeechUsecase) MaxFileSize() int64 {
+	return s.cfg.MaxFileSize
+}
+
+func (s *SpeechUsecase) Transcribe(ctx context.Context, fileName string, audio []byte) (string, error) {
+	if int64(len(audio)) > s.cfg.MaxFileSize {
+		return "", ErrAudioTooLarge
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.TranscriptionTimeout)
+	defer cancel()
+
+	text, err := s.Transcriber.Transcribe(ctx, fileName, bytes.NewReader(audio))
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return "", ErrEmptyTranscript
+	}
+	return text, nil
+}
+
+// StartSynthesis marks the answer of the AI chat being synthesized until the returned function is called.
+// ErrSynthesisInProgress is returned if the answer is already being synthesized.
+func (s *SpeechUsecase) StartSynthesis(aiChatID uuid.UUID, answerIndex int) (func(), error) {
+	key := synthesisKey{aiChatID: aiChatID, answerIndex: answerIndex}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.syntheses[key]; ok {
+		return nil, ErrSynthesisInProgress
+	}
+	s.syntheses[key] = struct{}{}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.syntheses, key)
+	}, nil
+}
+
+// GetTranscriptionCost returns the price of transcribing audio of the duration in USD.
+func (s *SpeechUsecase) GetTranscriptionCost(duration time.Duration) float64 {
+	return duration.Minutes() * s.cfg.TranscriptionPrice
+}
+
+// GetSpeechCost returns the price of synthesizing the text in USD, text longer than the configured limit is
+// counted as cut.
+func (s *SpeechUsecase) GetSpeechCost(text string) float64 {
+	length := min(len([]rune(text)), s.cfg.MaxSpeechLength)
+	return float64(length) * s.cfg.SpeechPrice / 1_000_000
+}
+
+func (s *SpeechUsecase) GetVoiceSettings(user model.User) model.VoiceSettings {
+	for _, roleSettings := range s.roleVoiceSettings {
+		if slices.Contains(user.Roles, roleSettings.role) {
+			return roleSettings.settings
+		}
+	}
+	return DefaultVoiceSettings
+}
+
+// Synthesize converts text to speech with the voice settings of the user role. Text longer than the
+// configured limit is cut.
+func (s *SpeechUsecase) Synthesize(ctx context.Context, user model.User, text string) (
+	[]byte,
+	model.VoiceSettings,
+	error,
+) {
+	settings := s.GetVoiceSettings(user)
+	if runes := []rune(text); len(runes) > s.cfg.MaxSpeechLength {
+		text = string(runes[:s.cfg.MaxSpeechLength])
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.SpeechTimeout)
+	defer cancel()
+
+	audio, err := s.Synthesizer.Synthesize(ctx, text, settings)
+	if err != nil {
+		return nil, model.VoiceSettings{}, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+	return audio, settings, nil
+}
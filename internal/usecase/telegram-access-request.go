@@ -0,0 +1,240 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var (
+	MessageRequestAccessButton = local.NewSet(
+		"Request access",
+		local.NewTrans(local.Rus, "Запросить доступ"),
+	)
+	MessageAccessRequested = local.NewSet(
+		"Your request was sent to admins, you will get a message when they answer.",
+		local.NewTrans(local.Rus, "Запрос отправлен администраторам, вы получите сообщение, когда они ответят."),
+	)
+	MessageAccessAlreadyRequested = local.NewSet(
+		"You have already requested access, wait for admins to answer.",
+		local.NewTrans(local.Rus, "Вы уже запросили доступ, дождитесь ответа администраторов."),
+	)
+	MessageAccessRequestDeniedUntilFormat = local.NewSet(
+		"Your access request was denied, you can request access again after %s.",
+		local.NewTrans(local.Rus, "Ваш запрос на доступ отклонён, запросить доступ снова можно после %s."),
+	)
+	MessageAccessAlreadyGranted = local.NewSet(
+		"You already have access.",
+		local.NewTrans(local.Rus, "У вас уже есть доступ."),
+	)
+	MessageAccessRequestFormat = local.NewSet(
+		"%s (`%v`) requests access.",
+		local.NewTrans(local.Rus, "%s (`%v`) запрашивает доступ."),
+	)
+	MessageAccessApproveButton = local.NewSet(
+		"Approve",
+		local.NewTrans(local.Rus, "Одобрить"),
+	)
+	MessageAccessDenyButton = local.NewSet(
+		"Deny",
+		local.NewTrans(local.Rus, "Отклонить"),
+	)
+	MessageAccessApprovedByFormat = local.NewSet(
+		"\nApproved by %s, role %s was granted.",
+		local.NewTrans(local.Rus, "\nОдобрено %s, выдана роль %s."),
+	)
+	MessageAccessDeniedByFormat = local.NewSet(
+		"\nDenied by %s.",
+		local.NewTrans(local.Rus, "\nОтклонено %s."),
+	)
+	MessageAccessRequestHandled = local.NewSet(
+		"The request was already handled.",
+		local.NewTrans(local.Rus, "Запрос уже обработан."),
+	)
+	MessageAccessApproved = local.NewSet(
+		"Your access request was approved. Use /new to create new chat.",
+		local.NewTrans(local.Rus, "Ваш запрос на доступ одобрен. Воспользуйтесь командой /new для создания нового чата."),
+	)
+	MessageAccessDenied = local.NewSet(
+		"Your access request was denied.",
+		local.NewTrans(local.Rus, "Ваш запрос на доступ отклонён."),
+	)
+)
+
+const (
+	CallbackQueryAccessRequest       = "access_request"
+	CallbackQueryPrefixAccessApprove = "access_approve_"
+	CallbackQueryPrefixAccessDeny    = "access_deny_"
+)
+
+// sendNoAccessMessage tells the user about missing access. In private chats the message has the button
+// requesting access, when requests are enabled.
+func (t *TelegramUsecase) sendNoAccessMessage(message *api.Message) {
+	chatID := message.Chat.ID
+	from := message.From
+	if !t.AccessRequest.Enabled() || !message.Chat.IsPrivate() {
+		t.sendMessageAndHandleErr(chatID, from, MessageUserNoAccess)
+		return
+	}
+	msg := api.NewMessage(chatID, getLocalText(from, MessageUserNoAccess))
+	msg.ReplyMarkup = api.NewInlineKeyboardMarkup(
+		api.NewInlineKeyboardRow(
+			api.NewInlineKeyboardButtonData(getLocalText(from, MessageRequestAccessButton), CallbackQueryAccessRequest),
+		),
+	)
+	if _, err := t.sendToBot(msg); err != nil {
+		log.Printf("failed to send no access message: %v\n", err)
+	}
+}
+
+func (t *TelegramUsecase) handleCallbackAccessRequest(ctx context.Context, update api.Update) error {
+	chatID := update.CallbackQuery.Message.Chat.ID
+	messageID := update.CallbackQuery.Message.MessageID
+	from := update.CallbackQuery.From
+
+	callback := api.NewCallback(update.CallbackQuery.ID, "")
+	if _, err := t.Bot.Request(callback); err != nil {
+		return fmt.Errorf("failed to request callback: %w", err)
+	}
+	if !t.AccessRequest.Enabled() {
+		return nil
+	}
+
+	user, err := t.getChatUser(ctx, chatID, from)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get user info for telegram user: %w", err)
+	}
+	if t.User.HasAccess(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageAccessAlreadyGranted)
+		return nil
+	}
+
+	request := model.AccessRequest{
+		TelegramID:   from.ID,
+		Name:         getSenderName(from),
+		Username:     from.UserName,
+		LanguageCode: from.LanguageCode,
+	}
+	if err = t.AccessRequest.RequestAccess(ctx, request); err != nil {
+		var deniedErr *AccessRequestDeniedError
+		switch {
+		case errors.Is(err, ErrAccessAlreadyRequested):
+			t.sendMessageAndHandleErr(chatID, from, MessageAccessAlreadyRequested)
+			return nil
+		case errors.As(err, &deniedErr):
+			t.sendFormatMessageAndHandleErr(
+				chatID, from, MessageAccessRequestDeniedUntilFormat,
+				deniedErr.Until.In(t.User.GetUserLocation(user)).Format(roleExpiryTimeLayout),
+			)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to request access: %w", err)
+	}
+
+	idStr := strconv.FormatInt(from.ID, 10)
+	markup := api.NewInlineKeyboardMarkup(
+		api.NewInlineKeyboardRow(
+			api.NewInlineKeyboardButtonData(
+				MessageAccessApproveButton.Default, CallbackQueryPrefixAccessApprove+idStr,
+			),
+			api.NewInlineKeyboardButtonData(MessageAccessDenyButton.Default, CallbackQueryPrefixAccessDeny+idStr),
+		),
+	)
+	for _, adminID := range t.AccessRequest.Admins() {
+		msg := api.NewMessage(adminID, formatAccessRequest(nil, request))
+		msg.ParseMode = api.ModeMarkdown
+		msg.ReplyMarkup = markup
+		if _, err = t.Bot.Send(msg); err != nil {
+			log.Printf("failed to notify admin %v about access request: %v\n", adminID, err)
+		}
+	}
+	fmt.Printf("telegram user %v requested access\n", from.ID)
+
+	if _, err = t.sendEditMessage(chatID, messageID, getLocalText(from, MessageAccessRequested)); err != nil {
+		return fmt.Errorf("failed to edit access request message: %w", err)
+	}
+	return nil
+}
+
+// handleCallbackAccessDecision handles approve and deny buttons sent to admins. Every admin gets the buttons,
+// the request is handled by the first one.
+func (t *TelegramUsecase) handleCallbackAccessDecision(ctx context.Context, update api.Update) error {
+	chatID := update.CallbackQuery.Message.Chat.ID
+	messageID := update.CallbackQuery.Message.MessageID
+	data := update.CallbackQuery.Data
+	from := update.CallbackQuery.From
+
+	user, err := t.getChatUser(ctx, chatID, from)
+	if err != nil {
+		return fmt.Errorf("failed to get user info for telegram user: %w", err)
+	}
+	if !isAdmin(user) {
+		return nil
+	}
+
+	approve := strings.HasPrefix(data, CallbackQueryPrefixAccessApprove)
+	idStr := strings.TrimPrefix(strings.TrimPrefix(data, CallbackQueryPrefixAccessApprove), CallbackQueryPrefixAccessDeny)
+	requestTelegramID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse access request user ID: %w", err)
+	}
+
+	var request model.AccessRequest
+	if approve {
+		request, err = t.AccessRequest.ApproveRequest(ctx, requestTelegramID)
+	} else {
+		request, err = t.AccessRequest.DenyRequest(ctx, requestTelegramID)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrAccessRequestDoesNotExist) {
+			callback := api.NewCallback(update.CallbackQuery.ID, getLocalText(from, MessageAccessRequestHandled))
+			if _, err = t.Bot.Request(callback); err != nil {
+				return fmt.Errorf("failed to request callback: %w", err)
+			}
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to handle access request: %w", err)
+	}
+	if _, err = t.Bot.Request(api.NewCallback(update.CallbackQuery.ID, "")); err != nil {
+		return fmt.Errorf("failed to request callback: %w", err)
+	}
+
+	requester := &api.User{LanguageCode: request.LanguageCode}
+	decision := getLocalFormatText(from, MessageAccessDeniedByFormat, api.EscapeText(api.ModeMarkdown, getSenderName(from)))
+	userText := getLocalText(requester, MessageAccessDenied)
+	if approve {
+		decision = getLocalFormatText(
+			from, MessageAccessApprovedByFormat, api.EscapeText(api.ModeMarkdown, getSenderName(from)),
+			t.AccessRequest.Role(),
+		)
+		userText = getLocalText(requester, MessageAccessApproved)
+	}
+	fmt.Printf("access request of telegram user %v was handled by %v: %s\n", request.TelegramID, from.ID, decision)
+
+	if _, err = t.sendEditMessage(chatID, messageID, formatAccessRequest(from, request)+decision); err != nil {
+		log.Printf("failed to edit access request message: %v\n", err)
+	}
+	msg := api.NewMessage(request.TelegramID, userText)
+	msg.ParseMode = api.ModeMarkdown
+	if _, err = t.Bot.Send(msg); err != nil {
+		return fmt.Errorf("failed to notify user about access request: %w", err)
+	}
+	return nil
+}
+
+func formatAccessRequest(user *api.User, request model.AccessRequest) string {
+	name := api.EscapeText(api.ModeMarkdown, request.Name)
+	if len(request.Username) != 0 {
+		name += " @" + api.EscapeText(api.ModeMarkdown, request.Username)
+	}
+	return getLocalFormatText(user, MessageAccessRequestFormat, name, request.TelegramID)
+}
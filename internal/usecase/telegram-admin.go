@@ -0,0 +1,233 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	MessageAdminUsage = local.NewSet(
+		"Admin commands:\n"+
+			"`/grant <user ID> <role> [duration]` - grant a role, for a time if the duration is set, e.g. `7d`\n"+
+			"`/revoke <user ID> <role>` - revoke a role\n"+
+			"`/whois <user ID>` - show user info\n"+
+			"`/ban <user ID> [reason] [duration]` - ban a user, for a time if the duration is set\n"+
+			"`/unban <user ID>` - unban a user\n"+
+			"`/broadcast [role]` - send a message to all users or users with the role\n"+
+			"Reply to a message of the user to omit the ID.",
+		local.NewTrans(
+			local.Rus, "Команды администратора:\n"+
+				"`/grant <ID пользователя> <роль> [срок]` - выдать роль, на время, если задан срок, например `7d`\n"+
+				"`/revoke <ID пользователя> <роль>` - отозвать роль\n"+
+				"`/whois <ID пользователя>` - показать информацию о пользователе\n"+
+				"`/ban <ID пользователя> [причина] [срок]` - заблокировать пользователя, на время, если задан срок\n"+
+				"`/unban <ID пользователя>` - разблокировать пользователя\n"+
+				"`/broadcast [роль]` - отправить сообщение всем пользователям или пользователям с ролью\n"+
+				"Ответьте на сообщение пользователя, чтобы не указывать ID.",
+		),
+	)
+	MessageRoleGrantedFormat = local.NewSet(
+		"Role %s was granted to `%v`.",
+		local.NewTrans(local.Rus, "Роль %s выдана `%v`."),
+	)
+	MessageRoleGrantedUntilFormat = local.NewSet(
+		"Role %s was granted to `%v` until %s.",
+		local.NewTrans(local.Rus, "Роль %s выдана `%v` до %s."),
+	)
+	MessageRoleRevokedFormat = local.NewSet(
+		"Role %s was revoked from `%v`.",
+		local.NewTrans(local.Rus, "Роль %s отозвана у `%v`."),
+	)
+	MessageUserAlreadyHasRoleFormat = local.NewSet(
+		"`%v` already has role %s.",
+		local.NewTrans(local.Rus, "У `%v` уже есть роль %s."),
+	)
+	MessageUserHasNoRoleFormat = local.NewSet(
+		"`%v` doesn't have role %s.",
+		local.NewTrans(local.Rus, "У `%v` нет роли %s."),
+	)
+	MessageRoleIsConfiguredFormat = local.NewSet(
+		"Role %s of `%v` is set by the environment, remove the user from the list to revoke it.",
+		local.NewTrans(local.Rus, "Роль %s у `%v` задана окружением, уберите пользователя из списка, чтобы отозвать её."),
+	)
+	MessageUnknownRoleFormat = local.NewSet(
+		"Unknown role %s. Roles: %s.",
+		local.NewTrans(local.Rus, "Неизвестная роль %s. Роли: %s."),
+	)
+	MessageTelegramUserNotFoundFormat = local.NewSet(
+		"User `%v` hasn't used the bot yet.",
+		local.NewTrans(local.Rus, "Пользователь `%v` ещё не пользовался ботом."),
+	)
+	MessageWhoisFormat = local.NewSet(
+		"User `%v`\nRoles: %s\nChats: %v\nTimezone: %s",
+		local.NewTrans(local.Rus, "Пользователь `%v`\nРоли: %s\nЧатов: %v\nЧасовой пояс: %s"),
+	)
+	MessageRoleUntilFormat = local.NewSet(
+		"%s until %s",
+		local.NewTrans(local.Rus, "%s до %s"),
+	)
+)
+
+const (
+	CommandGrant  = "grant"
+	CommandRevoke = "revoke"
+	CommandWhois  = "whois"
+)
+
+func isAdmin(user model.User) bool {
+	return slices.Contains(user.Roles, model.UserRoleAdmin)
+}
+
+// handleCommandAdmin handles commands managing users. They are unknown for users who aren't admins.
+func (t *TelegramUsecase) handleCommandAdmin(
+	ctx context.Context,
+	user model.User,
+	message *api.Message,
+	args string,
+) error {
+	chatID := message.Chat.ID
+	from := message.From
+	if !isAdmin(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageCommandUnknown)
+		return nil
+	}
+
+	targetID, args, ok := parseAdminTarget(message, args)
+	if !ok {
+		t.sendMessageAndHandleErr(chatID, from, MessageAdminUsage)
+		return nil
+	}
+	if message.Command() == CommandWhois {
+		return t.sendWhois(ctx, chatID, from, targetID)
+	}
+
+	roleName, rest := cutWord(args)
+	if len(roleName) == 0 {
+		t.sendMessageAndHandleErr(chatID, from, MessageAdminUsage)
+		return nil
+	}
+	role, err := t.User.ParseGrantableRole(roleName)
+	if err != nil {
+		t.sendUnknownRole(chatID, from, roleName)
+		return nil
+	}
+
+	var duration time.Duration
+	if durationArg, _ := cutWord(rest); message.Command() == CommandGrant && len(durationArg) != 0 {
+		if duration, err = parseDuration(durationArg); err != nil || duration <= 0 {
+			t.sendMessageAndHandleErr(chatID, from, MessageAdminUsage)
+			return nil
+		}
+	}
+
+	var expiresAt time.Time
+	switch {
+	case message.Command() == CommandGrant && duration != 0:
+		expiresAt, err = t.User.ExtendRole(ctx, targetID, role, duration)
+	case message.Command() == CommandGrant:
+		_, err = t.User.GrantRole(ctx, targetID, role)
+	default:
+		_, err = t.User.RevokeRole(ctx, targetID, role)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserAlreadyHasRole):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageUserAlreadyHasRoleFormat, targetID, role)
+			return nil
+		case errors.Is(err, ErrUserHasNoRole):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageUserHasNoRoleFormat, targetID, role)
+			return nil
+		case errors.Is(err, ErrRoleIsConfigured):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageRoleIsConfiguredFormat, role, targetID)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to update user roles: %w", err)
+	}
+
+	if !expiresAt.IsZero() {
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageRoleGrantedUntilFormat, role, targetID,
+			expiresAt.In(t.User.GetUserLocation(user)).Format(roleExpiryTimeLayout),
+		)
+	} else if message.Command() == CommandGrant {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageRoleGrantedFormat, role, targetID)
+	} else {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageRoleRevokedFormat, role, targetID)
+	}
+	return nil
+}
+
+// sendUnknownRole tells the admin about the unknown role with the list of roles which can be granted.
+func (t *TelegramUsecase) sendUnknownRole(chatID int64, from *api.User, roleName string) {
+	roles := make([]string, 0)
+	for _, grantableRole := range t.User.GrantableRoles() {
+		roles = append(roles, grantableRole.String())
+	}
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageUnknownRoleFormat, roleName, strings.Join(roles, ", "))
+}
+
+func (t *TelegramUsecase) sendWhois(ctx context.Context, chatID int64, from *api.User, targetID int64) error {
+	target, err := t.User.FindTelegramUser(ctx, targetID)
+	if err != nil {
+		if errors.Is(err, model.ErrTelegramUserDoesNotExists) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTelegramUserNotFoundFormat, targetID)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to find telegram user: %w", err)
+	}
+	chats, err := t.AIChat.ListUserChats(ctx, target.UserID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to list user chats: %w", err)
+	}
+
+	location := t.User.GetUserLocation(target)
+	roles := make([]string, 0, len(target.Roles))
+	for _, role := range target.Roles {
+		if expiresAt, ok := target.RoleExpiresAt[role]; ok {
+			roles = append(
+				roles,
+				getLocalFormatText(from, MessageRoleUntilFormat, role, expiresAt.In(location).Format(roleExpiryTimeLayout)),
+			)
+			continue
+		}
+		roles = append(roles, role.String())
+	}
+	banLine, err := t.formatWhoisBan(ctx, from, target)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to format ban: %w", err)
+	}
+	t.sendMessageAndHandleErrNoLocal(
+		chatID, getLocalFormatText(
+			from, MessageWhoisFormat, targetID, strings.Join(roles, ", "), len(chats), location.String(),
+		)+banLine,
+	)
+	return nil
+}
+
+// parseAdminTarget returns the Telegram user ID from the first argument or, if there is no ID, the author of
+// the replied message. The rest of the arguments is returned with the ID.
+func parseAdminTarget(message *api.Message, args string) (int64, string, bool) {
+	first, rest := cutWord(args)
+	if targetID, err := strconv.ParseInt(first, 10, 64); err == nil {
+		return targetID, rest, true
+	}
+	// Messages of forum topics reply to the topic creation message, when they don't reply to anything else.
+	replyTo := message.ReplyToMessage
+	if replyTo == nil || replyTo.From == nil || replyTo.From.IsBot ||
+		(message.IsTopicMessage && replyTo.MessageID == message.MessageThreadID) {
+		return 0, args, false
+	}
+	return replyTo.From.ID, args, true
+}
@@ -0,0 +1,287 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	MessageBroadcastWaiting = local.NewSet(
+		"Send the message to broadcast: a text, a photo or a document. `/broadcast cancel` to stop.",
+		local.NewTrans(
+			local.Rus, "Отправьте сообщение для рассылки: текст, фото или документ. `/broadcast cancel` - отменить.",
+		),
+	)
+	MessageBroadcastUnsupported = local.NewSet(
+		"Only a text, a photo or a document can be broadcast.",
+		local.NewTrans(local.Rus, "Разослать можно только текст, фото или документ."),
+	)
+	MessageBroadcastSendAllButton = local.NewSet(
+		"📢 Send to all users",
+		local.NewTrans(local.Rus, "📢 Отправить всем"),
+	)
+	MessageBroadcastSendRoleButtonFormat = local.NewSet(
+		"📢 Send to users with role %s",
+		local.NewTrans(local.Rus, "📢 Отправить пользователям с ролью %s"),
+	)
+	MessageBroadcastCancelButton = local.NewSet(
+		"Cancel",
+		local.NewTrans(local.Rus, "Отмена"),
+	)
+	MessageBroadcastCancelled = local.NewSet(
+		"Broadcast is cancelled.",
+		local.NewTrans(local.Rus, "Рассылка отменена."),
+	)
+	MessageBroadcastDraftExpired = local.NewSet(
+		"The broadcast is not available anymore, use /broadcast again.",
+		local.NewTrans(local.Rus, "Рассылка больше недоступна, воспользуйтесь командой /broadcast ещё раз."),
+	)
+	MessageBroadcastStarting = local.NewSet(
+		"Broadcast is starting...",
+		local.NewTrans(local.Rus, "Рассылка начинается..."),
+	)
+	MessageBroadcastProgressFormat = local.NewSet(
+		"Broadcast: %v/%v\nDelivered: %v, blocked: %v, failed: %v",
+		local.NewTrans(local.Rus, "Рассылка: %v/%v\nДоставлено: %v, заблокировали: %v, ошибок: %v"),
+	)
+	MessageBroadcastReportFormat = local.NewSet(
+		"Broadcast is finished in %v.\nRecipients: %v\nDelivered: %v\nBlocked the bot: %v\nFailed: %v",
+		local.NewTrans(
+			local.Rus, "Рассылка завершена за %v.\nПолучателей: %v\nДоставлено: %v\nЗаблокировали бота: %v\n"+
+				"Ошибок: %v",
+		),
+	)
+)
+
+const (
+	CommandBroadcast = "broadcast"
+
+	CallbackQueryPrefixBroadcastSend   = "broadcast_send_"
+	CallbackQueryPrefixBroadcastCancel = "broadcast_cancel_"
+
+	broadcastActionCancel = "cancel"
+	// maxBroadcastRetries limits attempts to send the message to a recipient after flood errors.
+	maxBroadcastRetries = 3
+)
+
+type broadcastResult int
+
+const (
+	broadcastDelivered broadcastResult = iota
+	broadcastBlocked
+	broadcastFailed
+)
+
+type broadcastReport struct {
+	delivered int
+	blocked   int
+	failed    int
+}
+
+func (r *broadcastReport) add(result broadcastResult) {
+	switch result {
+	case broadcastDelivered:
+		r.delivered++
+	case broadcastBlocked:
+		r.blocked++
+	default:
+		r.failed++
+	}
+}
+
+func (r *broadcastReport) sent() int {
+	return r.delivered + r.blocked + r.failed
+}
+
+// handleCommandBroadcast asks the admin for the message to broadcast to all users or users with the role from
+// the arguments.
+func (t *TelegramUsecase) handleCommandBroadcast(user model.User, message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	if !isAdmin(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageCommandUnknown)
+		return nil
+	}
+
+	args := strings.TrimSpace(message.CommandArguments())
+	if args == broadcastActionCancel {
+		t.Broadcast.CancelDraftRequest(from.ID, chatID)
+		t.sendMessageAndHandleErr(chatID, from, MessageBroadcastCancelled)
+		return nil
+	}
+	var role model.UserRole
+	if len(args) != 0 {
+		var err error
+		if role, err = t.User.ParseGrantableRole(args); err != nil {
+			t.sendUnknownRole(chatID, from, args)
+			return nil
+		}
+	}
+	t.Broadcast.RequestDraft(from.ID, chatID, role)
+	t.sendMessageAndHandleErr(chatID, from, MessageBroadcastWaiting)
+	return nil
+}
+
+// previewBroadcast copies the message the admin is asked for back to the admin with buttons to send or cancel
+// the broadcast.
+func (t *TelegramUsecase) previewBroadcast(message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	if len(message.Text) == 0 && len(message.Photo) == 0 && message.Document == nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageBroadcastUnsupported)
+		return nil
+	}
+	draft, ok := t.Broadcast.CreateDraft(from.ID, chatID, message.MessageID)
+	if !ok {
+		t.sendMessageAndHandleErr(chatID, from, MessageBroadcastDraftExpired)
+		return nil
+	}
+
+	sendText := getLocalText(from, MessageBroadcastSendAllButton)
+	if len(draft.Role) != 0 {
+		sendText = getLocalFormatText(from, MessageBroadcastSendRoleButtonFormat, draft.Role)
+	}
+	preview := api.NewCopyMessage(chatID, chatID, message.MessageID)
+	preview.ReplyMarkup = api.NewInlineKeyboardMarkup(
+		api.NewInlineKeyboardRow(api.NewInlineKeyboardButtonData(sendText, CallbackQueryPrefixBroadcastSend+draft.ID)),
+		api.NewInlineKeyboardRow(
+			api.NewInlineKeyboardButtonData(
+				getLocalText(from, MessageBroadcastCancelButton), CallbackQueryPrefixBroadcastCancel+draft.ID,
+			),
+		),
+	)
+	if _, err := t.Bot.Request(t.withThread(preview)); err != nil {
+		return fmt.Errorf("failed to send broadcast preview: %w", err)
+	}
+	return nil
+}
+
+// handleCallbackBroadcast sends or cancels the broadcast of the preview. Once the admin owning the draft is
+// checked, the buttons are removed from the preview, so it stays in the chat as the sent message.
+func (t *TelegramUsecase) handleCallbackBroadcast(ctx context.Context, update api.Update) error {
+	chatID := update.CallbackQuery.Message.Chat.ID
+	from := update.CallbackQuery.From
+
+	if _, err := t.Bot.Request(api.NewCallback(update.CallbackQuery.ID, "")); err != nil {
+		return fmt.Errorf("failed to request callback: %w", err)
+	}
+	user, err := t.User.GetUserInfoForTelegramUser(ctx, from.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get user info for telegram user: %w", err)
+	}
+	if !isAdmin(user) {
+		return nil
+	}
+
+	draftID, send := strings.CutPrefix(update.CallbackQuery.Data, CallbackQueryPrefixBroadcastSend)
+	if !send {
+		draftID = strings.TrimPrefix(update.CallbackQuery.Data, CallbackQueryPrefixBroadcastCancel)
+	}
+	draft, ok := t.Broadcast.TakeDraft(draftID, from.ID)
+	if !ok {
+		t.sendMessageAndHandleErr(chatID, from, MessageBroadcastDraftExpired)
+		return nil
+	}
+	_, err = t.Bot.Request(
+		api.NewEditMessageReplyMarkup(
+			chatID, update.CallbackQuery.Message.MessageID,
+			api.InlineKeyboardMarkup{InlineKeyboard: [][]api.InlineKeyboardButton{}},
+		),
+	)
+	if err != nil {
+		log.Printf("failed to remove broadcast preview buttons: %v\n", err)
+	}
+	if !send {
+		t.sendMessageAndHandleErr(chatID, from, MessageBroadcastCancelled)
+		return nil
+	}
+	progressMsg := t.sendMessageAndHandleErr(chatID, from, MessageBroadcastStarting)
+	go t.runBroadcast(draft, from, progressMsg.MessageID)
+	return nil
+}
+
+// runBroadcast copies the draft to its recipients at the broadcast rate, the admin sees the progress in the
+// progress message and gets the report at the end.
+func (t *TelegramUsecase) runBroadcast(draft model.BroadcastDraft, from *api.User, progressMsgID int) {
+	ctx, cancel := context.WithTimeout(context.Background(), HandleLongUpdateContextTimeout)
+	recipients, err := t.Broadcast.ListRecipients(ctx, draft.Role)
+	cancel()
+	if err != nil {
+		t.sendMessageAndHandleErr(draft.ChatID, from, MessageServerError)
+		log.Printf("failed to list broadcast recipients: %v\n", err)
+		return
+	}
+	fmt.Printf("broadcast %s of %v to %v users started\n", draft.ID, draft.AdminID, len(recipients))
+
+	var report broadcastReport
+	// Telegram refuses edits which don't change the message.
+	progressSent := -1
+	sendProgress := func() {
+		if progressSent == report.sent() {
+			return
+		}
+		progressSent = report.sent()
+		text := getLocalFormatText(
+			from, MessageBroadcastProgressFormat, report.sent(), len(recipients), report.delivered, report.blocked,
+			report.failed,
+		)
+		if _, err := t.sendEditMessage(draft.ChatID, progressMsgID, text); err != nil {
+			log.Printf("failed to update broadcast progress: %v\n", err)
+		}
+	}
+
+	startedAt := time.Now()
+	progressAt := startedAt.Add(t.Broadcast.ProgressInterval())
+	nextSendAt := startedAt
+	for _, recipientID := range recipients {
+		time.Sleep(time.Until(nextSendAt))
+		nextSendAt = time.Now().Add(t.Broadcast.Interval())
+		report.add(t.sendBroadcastMessage(draft, recipientID))
+		if time.Now().After(progressAt) {
+			sendProgress()
+			progressAt = time.Now().Add(t.Broadcast.ProgressInterval())
+		}
+	}
+	sendProgress()
+
+	duration := time.Since(startedAt).Round(time.Second)
+	fmt.Printf(
+		"broadcast %s finished in %v: delivered %v, blocked %v, failed %v\n", draft.ID, duration, report.delivered,
+		report.blocked, report.failed,
+	)
+	t.sendFormatMessageAndHandleErr(
+		draft.ChatID, from, MessageBroadcastReportFormat, duration, len(recipients), report.delivered, report.blocked,
+		report.failed,
+	)
+}
+
+// sendBroadcastMessage copies the draft to the recipient. Flood errors are waited out, users who blocked the bot
+// or never started it can't get messages.
+func (t *TelegramUsecase) sendBroadcastMessage(draft model.BroadcastDraft, recipientID int64) broadcastResult {
+	for attempt := 0; ; attempt++ {
+		_, err := t.Bot.Request(api.NewCopyMessage(recipientID, draft.ChatID, draft.MessageID))
+		if err == nil {
+			return broadcastDelivered
+		}
+		var apiErr *api.Error
+		if errors.As(err, &apiErr) {
+			if apiErr.RetryAfter > 0 && attempt < maxBroadcastRetries {
+				time.Sleep(time.Duration(apiErr.RetryAfter) * time.Second)
+				continue
+			}
+			if apiErr.Code == http.StatusForbidden {
+				return broadcastBlocked
+			}
+		}
+		log.Printf("failed to send broadcast %s to telegram user %v: %v\n", draft.ID, recipientID, err)
+		return broadcastFailed
+	}
+}
@@ -0,0 +1,163 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/document"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	MessageDocumentAttachedFormat = local.NewSet(
+		"Document \"%s\" was added to the chat (%v parts). Ask your question about it.",
+		local.NewTrans(local.Rus, "Документ \"%s\" добавлен в чат (частей: %v). Задайте вопрос по нему."),
+	)
+	MessageDocumentTruncatedFormat = local.NewSet(
+		"Document \"%s\" is too long, only the first %v of %v parts were added.",
+		local.NewTrans(local.Rus, "Документ \"%s\" слишком длинный, добавлены только первые %v из %v частей."),
+	)
+	MessageDocumentUnsupported = local.NewSet(
+		"I can read only text, markdown, source code and PDF files.",
+		local.NewTrans(local.Rus, "Я умею читать только текстовые, markdown, PDF файлы и исходный код."),
+	)
+	MessageDocumentEmpty = local.NewSet(
+		"I couldn't find any text in this document.",
+		local.NewTrans(local.Rus, "Не удалось найти текст в этом документе."),
+	)
+	MessageDocumentTooLargeFormat = local.NewSet(
+		"Document is too large. Maximum size is %v KB.",
+		local.NewTrans(local.Rus, "Документ слишком большой. Максимальный размер %v КБ."),
+	)
+	MessageChatExportFormat = local.NewSet(
+		"Chat with %s model, messages: %v.",
+		local.NewTrans(local.Rus, "Чат с моделью %s, сообщений: %v."),
+	)
+)
+
+var (
+	ErrDocumentNotAttached = errors.New("document not attached")
+	ErrFileTooLarge        = errors.New("file is too large")
+
+	FileDownloadTimeout = time.Second * 30
+)
+
+// attachDocument downloads the document of the message and adds its text to the AI chat. The returned
+// chat contains the attached context messages.
+func (t *TelegramUsecase) attachDocument(
+	ctx context.Context,
+	aiChat model.AIChat,
+	message *api.Message,
+) (model.AIChat, error) {
+	chatID := message.Chat.ID
+	from := message.From
+	doc := message.Document
+	maxFileSize := t.Document.MaxFileSize()
+
+	if doc.FileSize > maxFileSize {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageDocumentTooLargeFormat, maxFileSize/1024)
+		return model.AIChat{}, ErrDocumentNotAttached
+	}
+	data, err := t.downloadFile(doc.FileID, maxFileSize)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageDocumentTooLargeFormat, maxFileSize/1024)
+			return model.AIChat{}, ErrDocumentNotAttached
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return model.AIChat{}, fmt.Errorf("failed to download document: %w", err)
+	}
+
+	attached, err := t.Document.AttachDocument(ctx, aiChat, doc.FileName, doc.MimeType, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, document.ErrUnsupportedDocument):
+			t.sendMessageAndHandleErr(chatID, from, MessageDocumentUnsupported)
+			return model.AIChat{}, ErrDocumentNotAttached
+		case errors.Is(err, document.ErrEmptyDocument):
+			t.sendMessageAndHandleErr(chatID, from, MessageDocumentEmpty)
+			return model.AIChat{}, ErrDocumentNotAttached
+		case errors.Is(err, ErrDocumentTooLarge):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageDocumentTooLargeFormat, maxFileSize/1024)
+			return model.AIChat{}, ErrDocumentNotAttached
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return model.AIChat{}, fmt.Errorf("failed to attach document: %w", err)
+	}
+
+	if attached.Truncated {
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageDocumentTruncatedFormat, attached.FileName, attached.Parts, attached.TotalParts,
+		)
+	} else {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageDocumentAttachedFormat, attached.FileName, attached.Parts)
+	}
+
+	aiChat, err = t.AIChat.GetChat(ctx, aiChat.ChatID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return model.AIChat{}, fmt.Errorf("failed to get user ai-chat: %w", err)
+	}
+	return aiChat, nil
+}
+
+func (t *TelegramUsecase) sendChatExport(ctx context.Context, user model.User, chatID int64, from *api.User) error {
+	aiChat, err := t.getAIChat(ctx, user, chatID, from)
+	if err != nil {
+		if errors.Is(err, ErrAIChatNotCreatedYet) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user ai-chat: %w", err)
+	}
+
+	msg := api.NewDocument(
+		chatID, api.FileBytes{
+			Name:  fmt.Sprintf("chat-%s.md", aiChat.ChatID),
+			Bytes: t.AIChat.ExportChat(aiChat),
+		},
+	)
+	msg.Caption = getLocalFormatText(from, MessageChatExportFormat, aiChat.Model, len(aiChat.Messages))
+	if _, err = t.sendToBot(msg); err != nil {
+		return fmt.Errorf("failed to send chat export: %w", err)
+	}
+	return nil
+}
+
+// downloadFile downloads a file sent to the bot. Files bigger than maxSize are rejected with
+// ErrFileTooLarge.
+func (t *TelegramUsecase) downloadFile(fileID string, maxSize int64) ([]byte, error) {
+	fileURL, err := t.Bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file url: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), FileDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrFileTooLarge
+	}
+	return data, nil
+}
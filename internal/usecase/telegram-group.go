@@ -0,0 +1,50 @@
+package usecase
+
+import (
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"strings"
+)
+
+func isGroupChat(chat api.Chat) bool {
+	return chat.IsGroup() || chat.IsSuperGroup()
+}
+
+// isAddressedToBot reports whether a group message has to be answered. Private messages are always
+// answered, in groups the bot answers commands, replies to its messages and messages mentioning it.
+func (t *TelegramUsecase) isAddressedToBot(message *api.Message) bool {
+	if !isGroupChat(message.Chat) {
+		return true
+	}
+	if message.IsCommand() {
+		_, botName, found := strings.Cut(message.CommandWithAt(), "@")
+		return !found || strings.EqualFold(botName, t.Bot.Self.UserName)
+	}
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil &&
+		message.ReplyToMessage.From.ID == t.Bot.Self.ID {
+		return true
+	}
+	return t.botMention.MatchString(message.Text) || t.botMention.MatchString(message.Caption)
+}
+
+// stripBotMention removes mentions of the bot, so they don't get into the prompt.
+func (t *TelegramUsecase) stripBotMention(text string) string {
+	return strings.TrimSpace(t.botMention.ReplaceAllString(text, ""))
+}
+
+// attributeGroupMessage prefixes a group message with the sender name, so the model can tell members of
+// the shared chat apart.
+func attributeGroupMessage(message *api.Message, text string) string {
+	if !isGroupChat(message.Chat) || message.From == nil {
+		return text
+	}
+	return fmt.Sprintf("%s: %s", getSenderName(message.From), text)
+}
+
+func getSenderName(from *api.User) string {
+	name := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	if len(name) == 0 {
+		return from.UserName
+	}
+	return name
+}
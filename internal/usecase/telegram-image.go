@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"strings"
+)
+
+var (
+	MessageImageUsageFormat = local.NewSet(
+		"Use `/image [model] [size] prompt` to generate an image.\nModels: %s.\nSizes: %s.",
+		local.NewTrans(
+			local.Rus, "Воспользуйтесь `/image [модель] [размер] запрос`, чтобы сгенерировать изображение.\n"+
+				"Модели: %s.\nРазмеры: %s.",
+		),
+	)
+	MessageHaveNoImageModels = local.NewSet(
+		"You dont have access to image generation.",
+		local.NewTrans(local.Rus, "У вас нет доступа к генерации изображений."),
+	)
+
+	CommandImageInfo = local.NewSet(
+		"Generate an image",
+		local.NewTrans(local.Rus, "Сгенерировать изображение"),
+	)
+)
+
+const (
+	CommandImage = "image"
+
+	// maxCaptionLength is the Telegram limit of a media caption.
+	maxCaptionLength = 1024
+)
+
+func (t *TelegramUsecase) handleCommandImage(
+	ctx context.Context,
+	user model.User,
+	message *api.Message,
+	args string,
+) error {
+	chatID := message.Chat.ID
+	from := message.From
+	request, err := t.Image.ParseImageRequest(user, args)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserRoleHasNotAnyImageModels):
+			t.sendMessageAndHandleErr(chatID, from, MessageHaveNoImageModels)
+			return nil
+		case errors.Is(err, ErrEmptyImagePrompt):
+			models, sizes := t.Image.GetAvailableForUserOptions(user)
+			t.sendFormatMessageAndHandleErr(
+				chatID, from, MessageImageUsageFormat, strings.Join(models, ", "), strings.Join(sizes, ", "),
+			)
+			return nil
+		}
+		return fmt.Errorf("failed to parse image request: %w", err)
+	}
+
+	aiChat, err := t.getAIChat(ctx, user, chatID, from)
+	if err != nil {
+		if errors.Is(err, ErrAIChatNotCreatedYet) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user ai-chat: %w", err)
+	}
+
+	if ok, err := t.checkPrompt(chatID, from, request.Prompt); !ok {
+		return err
+	}
+	sender, err := t.getSenderUser(ctx, message, user)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get sender user: %w", err)
+	}
+	if ok, err := t.reserveQuotaRequest(ctx, sender, message); !ok {
+		return err
+	}
+	_, err = t.sendChatAction(chatID, api.ChatUploadPhoto)
+	if err != nil {
+		log.Printf("failed to send new action to bot: %v\n", err)
+	}
+	image, err := t.Image.GenerateImage(ctx, aiChat.ChatID, request)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to generate image: %w", err)
+	}
+	t.recordQuotaUsage(sender, model.Usage{Cost: t.Image.GetImageCost(request)})
+
+	photo := api.NewPhoto(chatID, api.FileBytes{Name: "image.png", Bytes: image.Data})
+	if caption := []rune(request.Prompt); len(caption) > maxCaptionLength {
+		photo.Caption = string(caption[:maxCaptionLength])
+	} else {
+		photo.Caption = request.Prompt
+	}
+	if _, err = t.sendToBot(photo); err != nil {
+		return fmt.Errorf("failed to send image: %w", err)
+	}
+	return nil
+}
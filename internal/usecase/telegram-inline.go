@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	MessageInlineAnswerTitle = local.NewSet(
+		"Send answer",
+		local.NewTrans(local.Rus, "Отправить ответ"),
+	)
+	MessageInlineAnswerFormat = local.NewSet(
+		"❓ %s\n\n%s",
+	)
+	MessageInlineRateLimited = local.NewSet(
+		"Too many questions, try again later",
+		local.NewTrans(local.Rus, "Слишком много вопросов, попробуйте позже"),
+	)
+	MessageInlinePromptBlocked = local.NewSet(
+		"The question breaks the usage rules",
+		local.NewTrans(local.Rus, "Вопрос нарушает правила использования"),
+	)
+	MessageInlineQuotaExceeded = local.NewSet(
+		"Your quota is used up, see /usage in the bot",
+		local.NewTrans(local.Rus, "Ваш лимит исчерпан, подробнее - /usage в боте"),
+	)
+	MessageInlineNoAccess = local.NewSet(
+		"You are not allowed to use this bot",
+		local.NewTrans(local.Rus, "У вас нет доступа к этому боту"),
+	)
+)
+
+const (
+	// inlineStartParameter is passed to /start when the user opens the bot from inline results.
+	inlineStartParameter = "inline"
+	// maxInlineDescriptionLength is the length of the answer preview shown in inline results.
+	maxInlineDescriptionLength = 100
+)
+
+// handleInlineQuery answers the inline query with a single article containing a short model answer.
+func (t *TelegramUsecase) handleInlineQuery(query *api.InlineQuery) error {
+	if !t.Inline.Enabled() || !t.Inline.IsAnswerable(query.Query) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HandleLongUpdateContextTimeout)
+	defer cancel()
+
+	from := query.From
+	if banned, err := t.isBanned(ctx, from.ID); banned || err != nil {
+		return err
+	}
+	user, err := t.User.GetUserInfoForTelegramUser(ctx, from.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get user info for telegram user: %w", err)
+	}
+	if !t.User.HasAccess(user) {
+		return t.answerInlineQueryWithButton(query, MessageInlineNoAccess)
+	}
+
+	answer, err := t.Inline.Answer(ctx, user, query.ID, query.Query)
+	if err != nil {
+		var quotaErr *QuotaExceededError
+		switch {
+		case errors.Is(err, ErrInlineQuerySuperseded):
+			return nil
+		case errors.Is(err, ErrInlineRateLimited):
+			return t.answerInlineQueryWithButton(query, MessageInlineRateLimited)
+		case errors.Is(err, ErrPromptBlocked):
+			return t.answerInlineQueryWithButton(query, MessageInlinePromptBlocked)
+		case errors.As(err, &quotaErr):
+			return t.answerInlineQueryWithButton(query, MessageInlineQuotaExceeded)
+		}
+		return fmt.Errorf("failed to answer inline query: %w", err)
+	}
+	if len(answer) == 0 {
+		return nil
+	}
+
+	// The answer is sent without markdown, so model formatting can't break the message.
+	article := api.NewInlineQueryResultArticle(
+		getInlineResultID(query.Query), getLocalText(from, MessageInlineAnswerTitle),
+		getLocalFormatText(from, MessageInlineAnswerFormat, strings.TrimSpace(query.Query), answer),
+	)
+	article.Description = truncateText(answer, maxInlineDescriptionLength)
+	_, err = t.Bot.Request(
+		api.InlineConfig{
+			InlineQueryID: query.ID,
+			Results:       []interface{}{article},
+			CacheTime:     int(t.Inline.CacheTTL().Seconds()),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to send inline query answer: %w", err)
+	}
+	return nil
+}
+
+// answerInlineQueryWithButton answers the inline query without results, showing the text on the button
+// opening the bot.
+func (t *TelegramUsecase) answerInlineQueryWithButton(query *api.InlineQuery, textSet local.TextSet) error {
+	_, err := t.Bot.Request(
+		api.InlineConfig{
+			InlineQueryID: query.ID,
+			Results:       []interface{}{},
+			IsPersonal:    true,
+			Button: &api.InlineQueryResultsButton{
+				Text:       getLocalText(query.From, textSet),
+				StartParam: inlineStartParameter,
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to send inline query answer: %w", err)
+	}
+	return nil
+}
+
+// getInlineResultID returns an ID fitting into 64 bytes, which is the same for the same query.
+func getInlineResultID(query string) string {
+	hash := sha1.Sum([]byte(normalizeInlineQuery(query)))
+	return hex.EncodeToString(hash[:])
+}
+
+func truncateText(text string, maxLength int) string {
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+	return string([]rune(text)[:maxLength-1]) + "…"
+}
@@ -0,0 +1,211 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"strings"
+)
+
+var (
+	MessageInviteUsage = local.NewSet(
+		"Invite commands:\n"+
+			"`/invite <role> [uses] [duration]` - create an invite, 0 uses is unlimited, e.g. `/invite premium 5 7d`\n"+
+			"`/invite list` - show invites\n"+
+			"`/invite revoke <code>` - revoke an invite",
+		local.NewTrans(
+			local.Rus, "Команды приглашений:\n"+
+				"`/invite <роль> [использований] [срок]` - создать приглашение, 0 использований - без ограничений, "+
+				"например `/invite premium 5 7d`\n"+
+				"`/invite list` - показать приглашения\n"+
+				"`/invite revoke <код>` - отозвать приглашение",
+		),
+	)
+	MessageInviteCreatedFormat = local.NewSet(
+		"Invite `%s` with role %s was created. Uses: %s, expires: %s.\n%s",
+		local.NewTrans(local.Rus, "Создано приглашение `%s` с ролью %s. Использований: %s, истекает: %s.\n%s"),
+	)
+	MessageInviteInfoFormat = local.NewSet(
+		"\n`%s` - %s, used: %v/%s, expires: %s",
+		local.NewTrans(local.Rus, "\n`%s` - %s, использовано: %v/%s, истекает: %s"),
+	)
+	MessageInvitesFormat = local.NewSet(
+		"Invites: %v",
+		local.NewTrans(local.Rus, "Приглашений: %v"),
+	)
+	MessageInviteRevokedFormat = local.NewSet(
+		"Invite `%s` was revoked.",
+		local.NewTrans(local.Rus, "Приглашение `%s` отозвано."),
+	)
+	MessageInviteNotFoundFormat = local.NewSet(
+		"Invite `%s` doesn't exist.",
+		local.NewTrans(local.Rus, "Приглашения `%s` не существует."),
+	)
+	MessageInviteNever = local.NewSet(
+		"never",
+		local.NewTrans(local.Rus, "никогда"),
+	)
+	MessageInviteUnlimited = local.NewSet(
+		"unlimited",
+		local.NewTrans(local.Rus, "без ограничений"),
+	)
+	MessageInviteRedeemedFormat = local.NewSet(
+		"Invite accepted, you got role %s.",
+		local.NewTrans(local.Rus, "Приглашение принято, вы получили роль %s."),
+	)
+	MessageInviteAlreadyHasRoleFormat = local.NewSet(
+		"You already have role %s.",
+		local.NewTrans(local.Rus, "У вас уже есть роль %s."),
+	)
+	MessageInviteInvalid = local.NewSet(
+		"The invite is invalid, expired or used up.",
+		local.NewTrans(local.Rus, "Приглашение недействительно, истекло или уже использовано."),
+	)
+)
+
+const (
+	CommandInvite = "invite"
+
+	inviteArgList   = "list"
+	inviteArgRevoke = "revoke"
+
+	inviteTimeLayout = "2006-01-02 15:04"
+)
+
+// handleCommandInvite handles commands managing invites. They are unknown for users who aren't admins.
+func (t *TelegramUsecase) handleCommandInvite(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	args string,
+) error {
+	if !isAdmin(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageCommandUnknown)
+		return nil
+	}
+
+	first, rest := cutWord(args)
+	switch first {
+	case "":
+		t.sendMessageAndHandleErr(chatID, from, MessageInviteUsage)
+		return nil
+	case inviteArgList:
+		return t.sendInvites(ctx, user, chatID, from)
+	case inviteArgRevoke:
+		code, _ := cutWord(rest)
+		if len(code) == 0 {
+			t.sendMessageAndHandleErr(chatID, from, MessageInviteUsage)
+			return nil
+		}
+		if err := t.Invite.RevokeInvite(ctx, code); err != nil {
+			if errors.Is(err, model.ErrInviteDoesNotExist) {
+				t.sendFormatMessageAndHandleErr(
+					chatID, from, MessageInviteNotFoundFormat, api.EscapeText(api.ModeMarkdown, code),
+				)
+				return nil
+			}
+			t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+			return fmt.Errorf("failed to revoke invite: %w", err)
+		}
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageInviteRevokedFormat, code)
+		return nil
+	}
+
+	role, err := t.User.ParseGrantableRole(first)
+	if err != nil {
+		roles := make([]string, 0)
+		for _, grantableRole := range t.User.GrantableRoles() {
+			roles = append(roles, grantableRole.String())
+		}
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageUnknownRoleFormat, first, strings.Join(roles, ", "))
+		return nil
+	}
+	uses, duration, err := ParseInviteArgs(rest)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageInviteUsage)
+		return nil
+	}
+	invite, err := t.Invite.CreateInvite(ctx, from.ID, role, uses, duration)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+	link := fmt.Sprintf("https://t.me/%s?start=%s", t.Bot.Self.UserName, invite.Code)
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageInviteCreatedFormat, invite.Code, invite.Role, formatInviteUses(from, invite),
+		t.formatInviteExpiry(user, from, invite), api.EscapeText(api.ModeMarkdown, link),
+	)
+	return nil
+}
+
+func (t *TelegramUsecase) sendInvites(ctx context.Context, user model.User, chatID int64, from *api.User) error {
+	invites, err := t.Invite.ListInvites(ctx)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to list invites: %w", err)
+	}
+	result := strings.Builder{}
+	result.WriteString(getLocalFormatText(from, MessageInvitesFormat, len(invites)))
+	for _, invite := range invites {
+		result.WriteString(
+			getLocalFormatText(
+				from, MessageInviteInfoFormat, invite.Code, invite.Role, invite.Used, formatInviteUses(from, invite),
+				t.formatInviteExpiry(user, from, invite),
+			),
+		)
+	}
+	t.sendMessageAndHandleErrNoLocal(chatID, result.String())
+	return nil
+}
+
+// redeemStartInvite redeems the invite code passed to /start by a deep link. It's called before the access
+// check, so users of private bots get their role first.
+func (t *TelegramUsecase) redeemStartInvite(ctx context.Context, message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	code := strings.TrimSpace(message.CommandArguments())
+
+	invite, err := t.Invite.RedeemInvite(ctx, from.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInviteInvalid):
+			t.sendMessageAndHandleErr(chatID, from, MessageInviteInvalid)
+			return nil
+		case errors.Is(err, ErrUserAlreadyHasRole):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageInviteAlreadyHasRoleFormat, invite.Role)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to redeem invite: %w", err)
+	}
+	fmt.Printf("telegram user %v redeemed invite %s with role %s\n", from.ID, invite.Code, invite.Role)
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageInviteRedeemedFormat, invite.Role)
+	return nil
+}
+
+// isInviteStart reports whether the message is /start with an invite code from a deep link.
+func isInviteStart(message *api.Message) bool {
+	if !message.Chat.IsPrivate() || message.Command() != CommandStart {
+		return false
+	}
+	args := strings.TrimSpace(message.CommandArguments())
+	return len(args) != 0 && args != inlineStartParameter
+}
+
+func formatInviteUses(from *api.User, invite model.Invite) string {
+	if invite.Uses == 0 {
+		return getLocalText(from, MessageInviteUnlimited)
+	}
+	return fmt.Sprint(invite.Uses)
+}
+
+func (t *TelegramUsecase) formatInviteExpiry(user model.User, from *api.User, invite model.Invite) string {
+	if invite.ExpiresAt.IsZero() {
+		return getLocalText(from, MessageInviteNever)
+	}
+	return invite.ExpiresAt.In(t.User.GetUserLocation(user)).Format(inviteTimeLayout)
+}
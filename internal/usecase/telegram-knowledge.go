@@ -0,0 +1,363 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/document"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	MessageKnowledgeUsage = local.NewSet(
+		"Knowledge base commands:\n"+
+			"`/kb add <text>` - add a note\n"+
+			"`/kb add` - as a caption of a document or a reply to it - add the document\n"+
+			"`/kb list` - show documents\n"+
+			"`/kb delete <number>` - delete a document\n"+
+			"`/kb on` or `/kb off` - use the knowledge base in answers of the current chat",
+		local.NewTrans(
+			local.Rus, "Команды базы знаний:\n"+
+				"`/kb add <текст>` - добавить заметку\n"+
+				"`/kb add` - в подписи к документу или ответом на него - добавить документ\n"+
+				"`/kb list` - показать документы\n"+
+				"`/kb delete <номер>` - удалить документ\n"+
+				"`/kb on` или `/kb off` - использовать базу знаний в ответах текущего чата",
+		),
+	)
+	MessageKnowledgeAddedFormat = local.NewSet(
+		"\"%s\" was added to your knowledge base (%v parts).",
+		local.NewTrans(local.Rus, "\"%s\" добавлен в вашу базу знаний (частей: %v)."),
+	)
+	MessageKnowledgeDeletedFormat = local.NewSet(
+		"\"%s\" was deleted from your knowledge base.",
+		local.NewTrans(local.Rus, "\"%s\" удалён из вашей базы знаний."),
+	)
+	MessageKnowledgeEmpty = local.NewSet(
+		"Your knowledge base is empty. Use `/kb add` to add notes and documents.",
+		local.NewTrans(local.Rus, "Ваша база знаний пуста. Воспользуйтесь `/kb add`, чтобы добавить заметки и документы."),
+	)
+	MessageKnowledgeDocumentsFormat = local.NewSet(
+		"Documents in your knowledge base: %v.",
+		local.NewTrans(local.Rus, "Документов в вашей базе знаний: %v."),
+	)
+	MessageKnowledgeDocumentInfoFormat = local.NewSet(
+		"\n%v) %s | parts: %v | %s",
+		local.NewTrans(local.Rus, "\n%v) %s | частей: %v | %s"),
+	)
+	MessageKnowledgeDocumentNotFound = local.NewSet(
+		"There is no document with this number. Use `/kb list` to see your documents.",
+		local.NewTrans(local.Rus, "Документа с таким номером нет. Воспользуйтесь `/kb list`, чтобы увидеть документы."),
+	)
+	MessageKnowledgeFullFormat = local.NewSet(
+		"Your knowledge base is full. Maximum number of documents is %v, delete some with `/kb delete`.",
+		local.NewTrans(
+			local.Rus, "Ваша база знаний заполнена. Максимум документов %v, удалите лишние через `/kb delete`.",
+		),
+	)
+	MessageKnowledgeDocumentTooLarge = local.NewSet(
+		"Document is too large for the knowledge base.",
+		local.NewTrans(local.Rus, "Документ слишком большой для базы знаний."),
+	)
+	MessageKnowledgeOn = local.NewSet(
+		"Answers in this chat will use your knowledge base.",
+		local.NewTrans(local.Rus, "Ответы в этом чате будут использовать вашу базу знаний."),
+	)
+	MessageKnowledgeOff = local.NewSet(
+		"Knowledge base is turned off for this chat.",
+		local.NewTrans(local.Rus, "База знаний выключена для этого чата."),
+	)
+	MessageKnowledgeSources = local.NewSet(
+		"📚 Sources:",
+		local.NewTrans(local.Rus, "📚 Источники:"),
+	)
+	MessageKnowledgeSourceFormat = local.NewSet(
+		"\n[%v] %s, part %v",
+		local.NewTrans(local.Rus, "\n[%v] %s, часть %v"),
+	)
+
+	CommandKnowledgeInfo = local.NewSet(
+		"Manage your knowledge base",
+		local.NewTrans(local.Rus, "Управление базой знаний"),
+	)
+)
+
+const (
+	CommandKnowledge = "kb"
+
+	knowledgeActionAdd    = "add"
+	knowledgeActionList   = "list"
+	knowledgeActionDelete = "delete"
+	knowledgeActionOn     = "on"
+	knowledgeActionOff    = "off"
+
+	knowledgeNoteTitleLength = 40
+)
+
+func (t *TelegramUsecase) handleCommandKnowledge(
+	ctx context.Context,
+	user model.User,
+	message *api.Message,
+	args string,
+) error {
+	chatID := message.Chat.ID
+	from := message.From
+
+	action, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(action) {
+	case knowledgeActionAdd:
+		if doc := getKnowledgeDocument(message); doc != nil {
+			return t.addKnowledgeFile(ctx, user, chatID, from, doc)
+		}
+		if len(rest) == 0 {
+			t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeUsage)
+			return nil
+		}
+		return t.addKnowledgeNote(ctx, user, chatID, from, rest)
+	case knowledgeActionList:
+		return t.sendKnowledgeDocuments(ctx, user, chatID, from)
+	case knowledgeActionDelete:
+		return t.deleteKnowledgeDocument(ctx, user, chatID, from, rest)
+	case knowledgeActionOn, knowledgeActionOff:
+		return t.updateChatKnowledgeBase(ctx, user, chatID, from, strings.ToLower(action) == knowledgeActionOn)
+	}
+	t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeUsage)
+	return nil
+}
+
+// isKnowledgeCaption reports whether a document is sent with the `/kb add` caption, such documents go
+// to the knowledge base instead of the current chat.
+func isKnowledgeCaption(caption string) bool {
+	fields := strings.Fields(caption)
+	if len(fields) < 2 {
+		return false
+	}
+	// Commands in groups can be sent as /kb@bot_name.
+	command, _, _ := strings.Cut(fields[0], "@")
+	return command == "/"+CommandKnowledge && strings.EqualFold(fields[1], knowledgeActionAdd)
+}
+
+func getKnowledgeDocument(message *api.Message) *api.Document {
+	if message.Document != nil {
+		return message.Document
+	}
+	if message.ReplyToMessage != nil {
+		return message.ReplyToMessage.Document
+	}
+	return nil
+}
+
+func (t *TelegramUsecase) addKnowledgeNote(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	text string,
+) error {
+	title, _, _ := strings.Cut(text, "\n")
+	if utf8.RuneCountInString(title) > knowledgeNoteTitleLength {
+		title = string([]rune(title)[:knowledgeNoteTitleLength]) + "…"
+	}
+	knowledgeDocument, err := t.Knowledge.AddDocument(ctx, user, title, text)
+	if err != nil {
+		return t.handleAddKnowledgeErr(user, chatID, from, err)
+	}
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageKnowledgeAddedFormat, api.EscapeText(api.ModeMarkdown, knowledgeDocument.Title),
+		knowledgeDocument.ChunksCount,
+	)
+	return nil
+}
+
+func (t *TelegramUsecase) addKnowledgeFile(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	doc *api.Document,
+) error {
+	maxFileSize := t.Document.MaxFileSize()
+	if doc.FileSize > maxFileSize {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageDocumentTooLargeFormat, maxFileSize/1024)
+		return nil
+	}
+	_, err := t.sendChatAction(chatID, api.ChatTyping)
+	if err != nil {
+		log.Printf("failed to send new action to bot: %v\n", err)
+	}
+	data, err := t.downloadFile(doc.FileID, maxFileSize)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageDocumentTooLargeFormat, maxFileSize/1024)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to download document: %w", err)
+	}
+
+	knowledgeDocument, err := t.Knowledge.AddFile(ctx, user, doc.FileName, doc.MimeType, data)
+	if err != nil {
+		return t.handleAddKnowledgeErr(user, chatID, from, err)
+	}
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageKnowledgeAddedFormat, api.EscapeText(api.ModeMarkdown, knowledgeDocument.Title),
+		knowledgeDocument.ChunksCount,
+	)
+	return nil
+}
+
+func (t *TelegramUsecase) handleAddKnowledgeErr(user model.User, chatID int64, from *api.User, err error) error {
+	switch {
+	case errors.Is(err, document.ErrUnsupportedDocument):
+		t.sendMessageAndHandleErr(chatID, from, MessageDocumentUnsupported)
+		return nil
+	case errors.Is(err, document.ErrEmptyDocument), errors.Is(err, ErrKnowledgeDocumentEmptyText):
+		t.sendMessageAndHandleErr(chatID, from, MessageDocumentEmpty)
+		return nil
+	case errors.Is(err, ErrKnowledgeDocumentTooLarge):
+		t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeDocumentTooLarge)
+		return nil
+	case errors.Is(err, ErrKnowledgeBaseFull):
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageKnowledgeFullFormat, t.Knowledge.MaxDocuments(user))
+		return nil
+	}
+	t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+	return fmt.Errorf("failed to add knowledge document: %w", err)
+}
+
+func (t *TelegramUsecase) sendKnowledgeDocuments(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+) error {
+	documents, err := t.Knowledge.ListDocuments(ctx, user.UserID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to list knowledge documents: %w", err)
+	}
+	if len(documents) == 0 {
+		t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeEmpty)
+		return nil
+	}
+
+	result := strings.Builder{}
+	result.WriteString(getLocalFormatText(from, MessageKnowledgeDocumentsFormat, len(documents)))
+	for i, knowledgeDocument := range documents {
+		result.WriteString(
+			getLocalFormatText(
+				from, MessageKnowledgeDocumentInfoFormat, i+1,
+				api.EscapeText(api.ModeMarkdown, knowledgeDocument.Title), knowledgeDocument.ChunksCount,
+				knowledgeDocument.CreatedAt.In(t.User.GetUserLocation(user)).Format("2006-01-02 15:04"),
+			),
+		)
+	}
+	t.sendMessageAndHandleErrNoLocal(chatID, result.String())
+	return nil
+}
+
+func (t *TelegramUsecase) deleteKnowledgeDocument(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	args string,
+) error {
+	number, err := strconv.Atoi(args)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeUsage)
+		return nil
+	}
+	documents, err := t.Knowledge.ListDocuments(ctx, user.UserID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to list knowledge documents: %w", err)
+	}
+	if number < 1 || number > len(documents) {
+		t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeDocumentNotFound)
+		return nil
+	}
+
+	knowledgeDocument := documents[number-1]
+	if err = t.Knowledge.DeleteDocument(ctx, user.UserID, knowledgeDocument.DocumentID); err != nil {
+		if errors.Is(err, model.ErrKnowledgeDocumentDoesNotExist) {
+			t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeDocumentNotFound)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to delete knowledge document: %w", err)
+	}
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageKnowledgeDeletedFormat, api.EscapeText(api.ModeMarkdown, knowledgeDocument.Title),
+	)
+	return nil
+}
+
+func (t *TelegramUsecase) updateChatKnowledgeBase(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	enabled bool,
+) error {
+	aiChat, err := t.getAIChat(ctx, user, chatID, from)
+	if err != nil {
+		if errors.Is(err, ErrAIChatNotCreatedYet) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user ai-chat: %w", err)
+	}
+	if err = t.AIChat.UpdateChatKnowledgeBase(ctx, aiChat.ChatID, enabled); err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to update chat knowledge base: %w", err)
+	}
+	if enabled {
+		t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeOn)
+	} else {
+		t.sendMessageAndHandleErr(chatID, from, MessageKnowledgeOff)
+	}
+	return nil
+}
+
+// searchKnowledge returns the knowledge base matches for the message when the chat uses the knowledge
+// base. Search errors are logged and the answer is given without the knowledge base.
+func (t *TelegramUsecase) searchKnowledge(user model.User, aiChat model.AIChat, msgText string) []model.KnowledgeMatch {
+	if !aiChat.UseKnowledgeBase {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.Knowledge.SearchTimeout())
+	defer cancel()
+
+	matches, err := t.Knowledge.Search(ctx, user.UserID, msgText)
+	if err != nil {
+		log.Printf("failed to search knowledge base: %v\n", err)
+		return nil
+	}
+	return matches
+}
+
+// formatKnowledgeSources renders the numbered sources cited in the answer.
+func formatKnowledgeSources(from *api.User, matches []model.KnowledgeMatch) string {
+	if len(matches) == 0 {
+		return ""
+	}
+	result := strings.Builder{}
+	result.WriteString("\n\n")
+	result.WriteString(getLocalText(from, MessageKnowledgeSources))
+	for i, match := range matches {
+		result.WriteString(
+			getLocalFormatText(
+				from, MessageKnowledgeSourceFormat, i+1, api.EscapeText(api.ModeMarkdown, match.Chunk.Title),
+				match.Chunk.Index+1,
+			),
+		)
+	}
+	return result.String()
+}
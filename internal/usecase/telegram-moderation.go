@@ -0,0 +1,176 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	MessageUserBannedFormat = local.NewSet(
+		"`%v` is banned.",
+		local.NewTrans(local.Rus, "`%v` заблокирован."),
+	)
+	MessageUserBannedUntilFormat = local.NewSet(
+		"`%v` is banned until %s.",
+		local.NewTrans(local.Rus, "`%v` заблокирован до %s."),
+	)
+	MessageUserUnbannedFormat = local.NewSet(
+		"`%v` is unbanned.",
+		local.NewTrans(local.Rus, "`%v` разблокирован."),
+	)
+	MessageUserIsNotBannedFormat = local.NewSet(
+		"`%v` isn't banned.",
+		local.NewTrans(local.Rus, "`%v` не заблокирован."),
+	)
+	MessageCannotBanAdminFormat = local.NewSet(
+		"`%v` is an admin and can't be banned.",
+		local.NewTrans(local.Rus, "`%v` - администратор, его нельзя заблокировать."),
+	)
+	MessageWhoisBannedFormat = local.NewSet(
+		"\nBanned %s, reason: %s",
+		local.NewTrans(local.Rus, "\nЗаблокирован %s, причина: %s"),
+	)
+	MessageBanUntilFormat = local.NewSet(
+		"until %s",
+		local.NewTrans(local.Rus, "до %s"),
+	)
+	MessageBanForever = local.NewSet(
+		"forever",
+		local.NewTrans(local.Rus, "навсегда"),
+	)
+	MessageBanNoReason = local.NewSet(
+		"no reason",
+		local.NewTrans(local.Rus, "без причины"),
+	)
+	MessageBannedPayment = local.NewSet(
+		"You are banned and can't buy products.",
+		local.NewTrans(local.Rus, "Вы заблокированы и не можете покупать товары."),
+	)
+	MessagePromptBlocked = local.NewSet(
+		"The message breaks the usage rules and won't be answered.",
+		local.NewTrans(local.Rus, "Сообщение нарушает правила использования, на него не будет ответа."),
+	)
+)
+
+const (
+	CommandBan   = "ban"
+	CommandUnban = "unban"
+)
+
+// isBanned is checked before the user is looked up, so banned users aren't even saved.
+func (t *TelegramUsecase) isBanned(ctx context.Context, telegramUserID int64) (bool, error) {
+	_, banned, err := t.Ban.GetBan(ctx, telegramUserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get ban of %v: %w", telegramUserID, err)
+	}
+	return banned, nil
+}
+
+// handleCommandBan handles /ban and /unban of admins. The reason and the duration of the ban are optional, the
+// last argument is the duration if it can be parsed, e.g. `/ban 123 spam 7d`.
+func (t *TelegramUsecase) handleCommandBan(ctx context.Context, user model.User, message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	if !isAdmin(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageCommandUnknown)
+		return nil
+	}
+
+	targetID, args, ok := parseAdminTarget(message, message.CommandArguments())
+	if !ok {
+		t.sendMessageAndHandleErr(chatID, from, MessageAdminUsage)
+		return nil
+	}
+
+	if message.Command() == CommandUnban {
+		if err := t.Ban.Unban(ctx, targetID); err != nil {
+			if errors.Is(err, ErrUserIsNotBanned) {
+				t.sendFormatMessageAndHandleErr(chatID, from, MessageUserIsNotBannedFormat, targetID)
+				return nil
+			}
+			t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+			return fmt.Errorf("failed to unban user: %w", err)
+		}
+		fmt.Printf("telegram user %v was unbanned by %v\n", targetID, from.ID)
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageUserUnbannedFormat, targetID)
+		return nil
+	}
+
+	reason, duration := parseBanArgs(args)
+	ban, err := t.Ban.Ban(ctx, from.ID, targetID, reason, duration)
+	if err != nil {
+		if errors.Is(err, ErrCannotBanAdmin) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageCannotBanAdminFormat, targetID)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to ban user: %w", err)
+	}
+	fmt.Printf("telegram user %v was banned by %v for %v: %s\n", targetID, from.ID, duration, reason)
+	if ban.ExpiresAt.IsZero() {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageUserBannedFormat, targetID)
+		return nil
+	}
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageUserBannedUntilFormat, targetID,
+		ban.ExpiresAt.In(t.User.GetUserLocation(user)).Format(roleExpiryTimeLayout),
+	)
+	return nil
+}
+
+// parseBanArgs splits the ban arguments into the reason and the duration, which is the last word if it can be
+// parsed.
+func parseBanArgs(args string) (string, time.Duration) {
+	args = strings.TrimSpace(args)
+	lastSpace := strings.LastIndexFunc(args, unicode.IsSpace)
+	duration, err := parseDuration(args[lastSpace+1:])
+	if err != nil || duration <= 0 {
+		return args, 0
+	}
+	return strings.TrimSpace(args[:lastSpace+1]), duration
+}
+
+// formatWhoisBan returns the ban line of /whois, empty for users who aren't banned.
+func (t *TelegramUsecase) formatWhoisBan(ctx context.Context, from *api.User, target model.User) (string, error) {
+	ban, banned, err := t.Ban.GetBan(ctx, target.TelegramID)
+	if err != nil || !banned {
+		return "", err
+	}
+	until := getLocalText(from, MessageBanForever)
+	if !ban.ExpiresAt.IsZero() {
+		until = getLocalFormatText(
+			from, MessageBanUntilFormat, ban.ExpiresAt.In(t.User.GetUserLocation(target)).Format(roleExpiryTimeLayout),
+		)
+	}
+	reason := getLocalText(from, MessageBanNoReason)
+	if len(ban.Reason) != 0 {
+		reason = api.EscapeText(api.ModeMarkdown, ban.Reason)
+	}
+	return getLocalFormatText(from, MessageWhoisBannedFormat, until, reason), nil
+}
+
+// checkPrompt tells the sender about the prompt blocked by moderation and returns false, if the prompt can't be
+// sent to models.
+func (t *TelegramUsecase) checkPrompt(chatID int64, from *api.User, prompt string) (bool, error) {
+	// Moderation has its own timeout, which may be longer than the timeout of the message handling.
+	ctx, cancel := context.WithTimeout(context.Background(), t.Moderation.Timeout())
+	defer cancel()
+
+	err := t.Moderation.CheckPrompt(ctx, from.ID, prompt)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrPromptBlocked) {
+		t.sendMessageAndHandleErr(chatID, from, MessagePromptBlocked)
+		return false, nil
+	}
+	t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+	return false, fmt.Errorf("failed to check prompt: %w", err)
+}
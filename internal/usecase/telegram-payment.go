@@ -0,0 +1,363 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var (
+	MessagePaymentsDisabled = local.NewSet(
+		"Purchases are not available.",
+		local.NewTrans(local.Rus, "Покупки недоступны."),
+	)
+	MessageSelectProduct = local.NewSet(
+		"Select what to buy for Telegram Stars.",
+		local.NewTrans(local.Rus, "Выберите, что купить за Telegram Stars."),
+	)
+	MessageProductButtonFormat = local.NewSet(
+		"%s - %v ⭐",
+		local.NewTrans(local.Rus, "%s - %v ⭐"),
+	)
+	MessageProductNotAvailable = local.NewSet(
+		"The product is not available anymore.",
+		local.NewTrans(local.Rus, "Товар больше недоступен."),
+	)
+	MessageProductPriceChanged = local.NewSet(
+		"The price has changed, use /buy again.",
+		local.NewTrans(local.Rus, "Цена изменилась, воспользуйтесь командой /buy ещё раз."),
+	)
+	MessageProductRoleAlreadyGrantedFormat = local.NewSet(
+		"You already have role %s without expiry.",
+		local.NewTrans(local.Rus, "У вас уже есть бессрочная роль %s."),
+	)
+	MessageRolePurchasedFormat = local.NewSet(
+		"Thank you! Role %s is active until %s.",
+		local.NewTrans(local.Rus, "Спасибо! Роль %s действует до %s."),
+	)
+	MessageTokensPurchasedFormat = local.NewSet(
+		"Thank you! %v tokens were added, you have %v token credits. They are spent when the monthly tokens are "+
+			"used up.",
+		local.NewTrans(
+			local.Rus, "Спасибо! Добавлено токенов: %v, всего купленных токенов: %v. Они расходуются, когда "+
+				"закончатся токены на месяц.",
+		),
+	)
+	MessagePaymentRefundedFormat = local.NewSet(
+		"Payment for %s was refunded.",
+		local.NewTrans(local.Rus, "Платёж за %s возвращён."),
+	)
+	MessagePaymentsUsage = local.NewSet(
+		"Payment commands:\n"+
+			"`/payments <user ID>` - show payments of the user\n"+
+			"`/refund <charge ID>` - refund a payment and take back what it gave",
+		local.NewTrans(
+			local.Rus, "Команды платежей:\n"+
+				"`/payments <ID пользователя>` - показать платежи пользователя\n"+
+				"`/refund <ID платежа>` - вернуть платёж и забрать то, что он дал",
+		),
+	)
+	MessagePaymentsFormat = local.NewSet(
+		"Payments of `%v`: %v",
+		local.NewTrans(local.Rus, "Платежей `%v`: %v"),
+	)
+	MessagePaymentInfoFormat = local.NewSet(
+		"\n`%s` - %s, %v ⭐, %s",
+		local.NewTrans(local.Rus, "\n`%s` - %s, %v ⭐, %s"),
+	)
+	MessagePaymentRefundedMark = local.NewSet(
+		", refunded",
+		local.NewTrans(local.Rus, ", возвращён"),
+	)
+	MessagePaymentNotFoundFormat = local.NewSet(
+		"Payment `%s` doesn't exist.",
+		local.NewTrans(local.Rus, "Платежа `%s` не существует."),
+	)
+	MessagePaymentAlreadyRefundedFormat = local.NewSet(
+		"Payment `%s` was already refunded.",
+		local.NewTrans(local.Rus, "Платёж `%s` уже возвращён."),
+	)
+	MessagePaymentRefundFailedFormat = local.NewSet(
+		"Telegram refused to refund payment `%s`: %s",
+		local.NewTrans(local.Rus, "Telegram не вернул платёж `%s`: %s"),
+	)
+	MessagePaymentRefundDoneFormat = local.NewSet(
+		"Payment `%s` of `%v` was refunded.",
+		local.NewTrans(local.Rus, "Платёж `%s` пользователя `%v` возвращён."),
+	)
+
+	CommandBuyInfo = local.NewSet(
+		"Buy premium time or tokens",
+		local.NewTrans(local.Rus, "Купить премиум или токены"),
+	)
+)
+
+const (
+	CommandBuy      = "buy"
+	CommandPayments = "payments"
+	CommandRefund   = "refund"
+
+	CallbackQueryPrefixBuy = "buy_"
+
+	paymentTimeLayout = "2006-01-02 15:04"
+)
+
+// sendProducts sends buttons of products. The command is available without access to the bot, so users of the
+// private bot can buy the role giving it.
+func (t *TelegramUsecase) sendProducts(chatID int64, from *api.User) error {
+	if !t.Payment.Enabled() {
+		t.sendMessageAndHandleErr(chatID, from, MessagePaymentsDisabled)
+		return nil
+	}
+	rows := make([][]api.InlineKeyboardButton, 0, len(t.Payment.Products()))
+	for _, product := range t.Payment.Products() {
+		rows = append(
+			rows, api.NewInlineKeyboardRow(
+				api.NewInlineKeyboardButtonData(
+					getLocalFormatText(from, MessageProductButtonFormat, product.Title, product.Stars),
+					CallbackQueryPrefixBuy+product.ID,
+				),
+			),
+		)
+	}
+	msg := api.NewMessage(chatID, getLocalText(from, MessageSelectProduct))
+	msg.ReplyMarkup = api.NewInlineKeyboardMarkup(rows...)
+	if _, err := t.sendToBot(msg); err != nil {
+		return fmt.Errorf("failed to send products: %w", err)
+	}
+	return nil
+}
+
+// handleCallbackBuy sends the invoice of the product. The payload of the invoice is the product ID.
+func (t *TelegramUsecase) handleCallbackBuy(update api.Update) error {
+	chatID := update.CallbackQuery.Message.Chat.ID
+	from := update.CallbackQuery.From
+
+	if _, err := t.Bot.Request(api.NewCallback(update.CallbackQuery.ID, "")); err != nil {
+		return fmt.Errorf("failed to request callback: %w", err)
+	}
+	product, err := t.Payment.GetProduct(strings.TrimPrefix(update.CallbackQuery.Data, CallbackQueryPrefixBuy))
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageProductNotAvailable)
+		return nil
+	}
+	invoice := api.NewInvoice(
+		chatID, product.Title, product.Description, product.ID, "", "", PaymentCurrency,
+		[]api.LabeledPrice{{Label: product.Title, Amount: product.Stars}}, nil,
+	)
+	if _, err = t.sendToBot(invoice); err != nil {
+		return fmt.Errorf("failed to send invoice: %w", err)
+	}
+	return nil
+}
+
+// handlePreCheckoutQuery confirms the order before the user pays. Telegram waits for the answer for 10 seconds.
+func (t *TelegramUsecase) handlePreCheckoutQuery(query *api.PreCheckoutQuery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), HandleUpdateContextTimeout)
+	defer cancel()
+
+	answer := api.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+	// Invoices sent before the ban can still be paid, so the payer is checked here.
+	banned, err := t.isBanned(ctx, query.From.ID)
+	var product config.Product
+	if err == nil && !banned {
+		product, err = t.Payment.CheckPreCheckout(
+			ctx, query.From.ID, query.InvoicePayload, query.Currency, query.TotalAmount,
+		)
+	}
+	switch {
+	case banned:
+		answer = api.PreCheckoutConfig{
+			PreCheckoutQueryID: query.ID,
+			ErrorMessage:       getLocalText(query.From, MessageBannedPayment),
+		}
+	case errors.Is(err, ErrProductDoesNotExist):
+		answer = api.PreCheckoutConfig{
+			PreCheckoutQueryID: query.ID,
+			ErrorMessage:       getLocalText(query.From, MessageProductNotAvailable),
+		}
+	case errors.Is(err, ErrInvalidPaymentAmount):
+		answer = api.PreCheckoutConfig{
+			PreCheckoutQueryID: query.ID,
+			ErrorMessage:       getLocalText(query.From, MessageProductPriceChanged),
+		}
+	case errors.Is(err, ErrUserAlreadyHasRole):
+		answer = api.PreCheckoutConfig{
+			PreCheckoutQueryID: query.ID,
+			ErrorMessage:       getLocalFormatText(query.From, MessageProductRoleAlreadyGrantedFormat, product.Role),
+		}
+	case err != nil:
+		answer = api.PreCheckoutConfig{
+			PreCheckoutQueryID: query.ID,
+			ErrorMessage:       getLocalText(query.From, MessageServerError),
+		}
+		log.Printf("failed to check pre-checkout query: %v\n", err)
+	}
+	if _, err = t.Bot.Request(answer); err != nil {
+		return fmt.Errorf("failed to answer pre-checkout query: %w", err)
+	}
+	return nil
+}
+
+// handleSuccessfulPayment gives the bought product to the payer. If the payer got the role of the product
+// without expiry after the pre-checkout, the payment is refunded.
+func (t *TelegramUsecase) handleSuccessfulPayment(ctx context.Context, message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	payment := message.SuccessfulPayment
+
+	completed, err := t.Payment.CompletePayment(
+		ctx, from.ID, payment.TelegramPaymentChargeID, payment.InvoicePayload, payment.TotalAmount,
+	)
+	switch {
+	case errors.Is(err, ErrPaymentAlreadyCompleted):
+		return nil
+	case errors.Is(err, ErrUserAlreadyHasRole):
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageProductRoleAlreadyGrantedFormat, completed.Payment.Role,
+		)
+		if err = t.refundPayment(ctx, completed.Payment); err != nil {
+			return err
+		}
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessagePaymentRefundedFormat, api.EscapeText(api.ModeMarkdown, t.Payment.GetProductTitle(completed.Payment.ProductID)),
+		)
+		return nil
+	case err != nil:
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to complete payment %s: %w", payment.TelegramPaymentChargeID, err)
+	}
+
+	if len(completed.Payment.Role) != 0 {
+		user, err := t.User.GetUserInfoForTelegramUser(ctx, from.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		expiresAt := completed.RoleExpiresAt.In(t.User.GetUserLocation(user)).Format(paymentTimeLayout)
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageRolePurchasedFormat, completed.Payment.Role, expiresAt,
+		)
+		return nil
+	}
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageTokensPurchasedFormat, completed.Payment.Tokens, completed.Credits,
+	)
+	return nil
+}
+
+// handleRefundedPayment takes back the product of the payment refunded outside the bot.
+func (t *TelegramUsecase) handleRefundedPayment(ctx context.Context, message *api.Message) error {
+	payment, err := t.Payment.RevertPayment(ctx, message.RefundedPayment.TelegramPaymentChargeID)
+	if err != nil {
+		if errors.Is(err, ErrPaymentAlreadyRefunded) || errors.Is(err, model.ErrPaymentDoesNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to revert payment: %w", err)
+	}
+	t.sendFormatMessageAndHandleErr(
+		message.Chat.ID, message.From, MessagePaymentRefundedFormat,
+		api.EscapeText(api.ModeMarkdown, t.Payment.GetProductTitle(payment.ProductID)),
+	)
+	return nil
+}
+
+// refundPayment returns the stars to the payer and takes back the product.
+func (t *TelegramUsecase) refundPayment(ctx context.Context, payment model.Payment) error {
+	refund := api.RefundStarPaymentConfig{
+		UserID:                  payment.TelegramID,
+		TelegramPaymentChargeID: payment.ChargeID,
+	}
+	if _, err := t.Bot.Request(refund); err != nil {
+		return fmt.Errorf("failed to refund payment %s: %w", payment.ChargeID, err)
+	}
+	if _, err := t.Payment.RevertPayment(ctx, payment.ChargeID); err != nil && !errors.Is(
+		err, ErrPaymentAlreadyRefunded,
+	) {
+		return fmt.Errorf("failed to revert payment: %w", err)
+	}
+	return nil
+}
+
+// handleCommandPayments handles commands managing payments. They are unknown for users who aren't admins.
+func (t *TelegramUsecase) handleCommandPayments(ctx context.Context, user model.User, message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	if !isAdmin(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageCommandUnknown)
+		return nil
+	}
+
+	arg, _ := cutWord(message.CommandArguments())
+	if len(arg) == 0 {
+		t.sendMessageAndHandleErr(chatID, from, MessagePaymentsUsage)
+		return nil
+	}
+	if message.Command() == CommandRefund {
+		return t.handleCommandRefund(ctx, chatID, from, arg)
+	}
+
+	targetID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessagePaymentsUsage)
+		return nil
+	}
+	payments, err := t.Payment.ListUserPayments(ctx, targetID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to list payments: %w", err)
+	}
+	location := t.User.GetUserLocation(user)
+	var builder strings.Builder
+	builder.WriteString(getLocalFormatText(from, MessagePaymentsFormat, targetID, len(payments)))
+	for _, payment := range payments {
+		builder.WriteString(
+			getLocalFormatText(
+				from, MessagePaymentInfoFormat, payment.ChargeID, api.EscapeText(api.ModeMarkdown, payment.ProductID),
+				payment.Stars, payment.CreatedAt.In(location).Format(paymentTimeLayout),
+			),
+		)
+		if payment.IsRefunded() {
+			builder.WriteString(getLocalText(from, MessagePaymentRefundedMark))
+		}
+	}
+	t.sendMessageAndHandleErrNoLocal(chatID, builder.String())
+	return nil
+}
+
+func (t *TelegramUsecase) handleCommandRefund(ctx context.Context, chatID int64, from *api.User, chargeID string) error {
+	escapedChargeID := api.EscapeText(api.ModeMarkdown, chargeID)
+	payment, err := t.Payment.GetPayment(ctx, chargeID)
+	if err != nil {
+		if errors.Is(err, model.ErrPaymentDoesNotExist) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessagePaymentNotFoundFormat, escapedChargeID)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+	if payment.IsRefunded() {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessagePaymentAlreadyRefundedFormat, escapedChargeID)
+		return nil
+	}
+	if err = t.refundPayment(ctx, payment); err != nil {
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessagePaymentRefundFailedFormat, escapedChargeID,
+			api.EscapeText(api.ModeMarkdown, err.Error()),
+		)
+		return err
+	}
+	t.sendFormatMessageAndHandleErr(chatID, from, MessagePaymentRefundDoneFormat, escapedChargeID, payment.TelegramID)
+
+	msg := api.NewMessage(payment.TelegramID, getLocalFormatText(nil, MessagePaymentRefundedFormat, t.Payment.GetProductTitle(payment.ProductID)))
+	if _, err = t.Bot.Send(msg); err != nil {
+		log.Printf("failed to notify user %v about refund: %v\n", payment.TelegramID, err)
+	}
+	return nil
+}
@@ -0,0 +1,574 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAdminTelegramID = 1
+	testBuyerTelegramID = 100
+)
+
+var testProducts = []config.Product{
+	{ID: "week", Title: "Premium for a week", Stars: 50, Role: "premium", Duration: 7 * 24 * time.Hour},
+	{ID: "tokens", Title: "100k tokens", Stars: 20, Tokens: 100_000},
+}
+
+// fakeBotAPI is a Bot API server keeping requests of the bot.
+type fakeBotAPI struct {
+	mu       sync.Mutex
+	requests []fakeBotRequest
+}
+
+type fakeBotRequest struct {
+	method string
+	params url.Values
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeBotRequest{method: method, params: r.PostForm})
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch method {
+	case "getMe":
+		_, _ = fmt.Fprint(w, `{"ok":true,"result":{"id":42,"is_bot":true,"first_name":"Test","username":"test_bot"}}`)
+	case "sendMessage", "sendInvoice":
+		_, _ = fmt.Fprintf(
+			w, `{"ok":true,"result":{"message_id":1,"chat":{"id":%s,"type":"private"}}}`, r.PostForm.Get("chat_id"),
+		)
+	default:
+		_, _ = fmt.Fprint(w, `{"ok":true,"result":true}`)
+	}
+}
+
+// takeRequests returns requests of the method sent since the last call.
+func (f *fakeBotAPI) takeRequests(method string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	params := make([]url.Values, 0)
+	for _, request := range f.requests {
+		if request.method == method {
+			params = append(params, request.params)
+		}
+	}
+	f.requests = nil
+	return params
+}
+
+type fakeUserStorage struct {
+	mu              sync.Mutex
+	telegramUsers   map[int64]uuid.UUID
+	users           map[uuid.UUID]model.User
+	configuredRoles map[int64][]model.UserRole
+}
+
+func newFakeUserStorage() *fakeUserStorage {
+	return &fakeUserStorage{
+		telegramUsers: make(map[int64]uuid.UUID),
+		users:         make(map[uuid.UUID]model.User),
+	}
+}
+
+func (f *fakeUserStorage) GetUserIDForTelegramUser(_ context.Context, userTelegramID int64) (uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	userID, ok := f.telegramUsers[userTelegramID]
+	if !ok {
+		return uuid.Nil, model.ErrTelegramUserDoesNotExists
+	}
+	return userID, nil
+}
+
+func (f *fakeUserStorage) CreateNewTelegramUser(
+	_ context.Context,
+	userTelegramID int64,
+	roles []model.UserRole,
+) (uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	userID := uuid.New()
+	f.telegramUsers[userTelegramID] = userID
+	f.users[userID] = model.User{UserID: userID, TelegramID: userTelegramID, Roles: roles}
+	return userID, nil
+}
+
+func (f *fakeUserStorage) GetUserIDForTelegramChat(_ context.Context, _ int64) (uuid.UUID, error) {
+	return uuid.Nil, model.ErrTelegramChatDoesNotExist
+}
+
+func (f *fakeUserStorage) CreateNewTelegramChat(_ context.Context, _ int64) (uuid.UUID, error) {
+	return uuid.Nil, fmt.Errorf("group chats aren't supported")
+}
+
+func (f *fakeUserStorage) GetUserInfo(_ context.Context, userID uuid.UUID) (model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return model.User{}, fmt.Errorf("%w: %s", model.ErrUserDoesNotExist, userID)
+	}
+	user.Roles = append([]model.UserRole(nil), user.Roles...)
+	expiries := make(map[model.UserRole]time.Time, len(user.RoleExpiresAt))
+	for role, expiresAt := range user.RoleExpiresAt {
+		expiries[role] = expiresAt
+	}
+	user.RoleExpiresAt = expiries
+	return user, nil
+}
+
+func (f *fakeUserStorage) UpdateUserLastAIChat(_ context.Context, userID uuid.UUID, aiChatID uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := f.users[userID]
+	user.LastAIChat = aiChatID
+	f.users[userID] = user
+	return nil
+}
+
+func (f *fakeUserStorage) UpdateUserTimezone(_ context.Context, userID uuid.UUID, timezone string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := f.users[userID]
+	user.Timezone = timezone
+	f.users[userID] = user
+	return nil
+}
+
+func (f *fakeUserStorage) UpdateUserRoles(
+	ctx context.Context,
+	userID uuid.UUID,
+	update func(roles []model.UserRole, roleExpiresAt map[model.UserRole]time.Time) (
+		[]model.UserRole,
+		map[model.UserRole]time.Time,
+		error,
+	),
+) error {
+	user, err := f.GetUserInfo(ctx, userID)
+	if err != nil {
+		return err
+	}
+	roles, roleExpiresAt, err := update(user.Roles, user.RoleExpiresAt)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user.Roles = roles
+	user.RoleExpiresAt = roleExpiresAt
+	f.users[userID] = user
+	return nil
+}
+
+func (f *fakeUserStorage) GetConfiguredRoles(_ context.Context) (map[int64][]model.UserRole, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.configuredRoles), nil
+}
+
+func (f *fakeUserStorage) SetConfiguredRoles(_ context.Context, configuredRoles map[int64][]model.UserRole) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configuredRoles = maps.Clone(configuredRoles)
+	return nil
+}
+
+func (f *fakeUserStorage) getTelegramUser(t *testing.T, userTelegramID int64) model.User {
+	t.Helper()
+	userID, err := f.GetUserIDForTelegramUser(context.Background(), userTelegramID)
+	if err != nil {
+		t.Fatalf("failed to get user %v: %v", userTelegramID, err)
+	}
+	user, err := f.GetUserInfo(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("failed to get user %v: %v", userTelegramID, err)
+	}
+	return user
+}
+
+type fakePaymentStorage struct {
+	mu       sync.Mutex
+	payments map[string]model.Payment
+}
+
+func (f *fakePaymentStorage) CreatePayment(_ context.Context, payment model.Payment) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.payments[payment.ChargeID]; ok {
+		return false, nil
+	}
+	f.payments[payment.ChargeID] = payment
+	return true, nil
+}
+
+func (f *fakePaymentStorage) GetPayment(_ context.Context, chargeID string) (model.Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[chargeID]
+	if !ok {
+		return model.Payment{}, model.ErrPaymentDoesNotExist
+	}
+	return payment, nil
+}
+
+func (f *fakePaymentStorage) ListUserPayments(_ context.Context, telegramID int64) ([]model.Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payments := make([]model.Payment, 0)
+	for _, payment := range f.payments {
+		if payment.TelegramID == telegramID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (f *fakePaymentStorage) MarkPaymentRefunded(_ context.Context, chargeID string, refundedAt time.Time) (
+	bool,
+	error,
+) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payment, ok := f.payments[chargeID]
+	if !ok {
+		return false, model.ErrPaymentDoesNotExist
+	}
+	if payment.IsRefunded() {
+		return false, nil
+	}
+	payment.RefundedAt = refundedAt
+	f.payments[chargeID] = payment
+	return true, nil
+}
+
+type fakeBanStorage struct{}
+
+func (fakeBanStorage) SetBan(_ context.Context, _ model.Ban) error {
+	return nil
+}
+
+func (fakeBanStorage) GetBan(_ context.Context, _ int64) (model.Ban, error) {
+	return model.Ban{}, model.ErrBanDoesNotExist
+}
+
+func (fakeBanStorage) DeleteBan(_ context.Context, _ int64) (bool, error) {
+	return false, nil
+}
+
+type paymentTest struct {
+	telegram *TelegramUsecase
+	bot      *fakeBotAPI
+	users    *fakeUserStorage
+	payments *fakePaymentStorage
+}
+
+func newPaymentTest(t *testing.T) paymentTest {
+	t.Helper()
+	bot := &fakeBotAPI{}
+	server := httptest.NewServer(bot)
+	t.Cleanup(server.Close)
+
+	telegramCfg := config.Telegram{
+		TelegramAPIToken:    "token",
+		AdminTelegramIDList: []int64{testAdminTelegramID},
+		APIEndpoint:         server.URL + "/bot%s/%s",
+	}
+	botAPI, err := api.NewBotAPIWithAPIEndpoint(telegramCfg.TelegramAPIToken, telegramCfg.APIEndpoint)
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+	roles := []config.Role{{Role: "default"}, {Role: "admin"}, {Role: "premium"}}
+	users := newFakeUserStorage()
+	payments := &fakePaymentStorage{payments: make(map[string]model.Payment)}
+	user := NewUserUsecase(UserUsecaseDeps{UserStorage: users}, telegramCfg, roles)
+	telegram, err := NewTelegramUsecase(
+		telegramCfg, TelegramUsecaseDeps{
+			User: user,
+			Bot:  botAPI,
+			Payment: NewPaymentUsecase(
+				PaymentUsecaseDeps{PaymentStorage: payments, User: user},
+				config.Payments{Enabled: true, Products: testProducts},
+			),
+			Ban: NewBanUsecase(BanUsecaseDeps{BanStorage: fakeBanStorage{}, User: user}),
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create telegram usecase: %v", err)
+	}
+	bot.takeRequests("")
+	return paymentTest{telegram: telegram, bot: bot, users: users, payments: payments}
+}
+
+func (p paymentTest) pay(t *testing.T, telegramID int64, chargeID, productID string) {
+	t.Helper()
+	product, err := p.telegram.Payment.GetProduct(productID)
+	if err != nil {
+		t.Fatalf("failed to get product: %v", err)
+	}
+	update := api.Update{
+		Message: &api.Message{
+			From: &api.User{ID: telegramID},
+			Chat: api.Chat{ID: telegramID, Type: "private"},
+			SuccessfulPayment: &api.SuccessfulPayment{
+				Currency:                PaymentCurrency,
+				TotalAmount:             product.Stars,
+				InvoicePayload:          product.ID,
+				TelegramPaymentChargeID: chargeID,
+			},
+		},
+	}
+	if err = p.telegram.handleMessage(update); err != nil {
+		t.Fatalf("failed to handle successful payment: %v", err)
+	}
+}
+
+func TestHandleCallbackBuySendsInvoiceInStars(t *testing.T) {
+	p := newPaymentTest(t)
+	update := api.Update{
+		CallbackQuery: &api.CallbackQuery{
+			ID:      "query",
+			From:    &api.User{ID: testBuyerTelegramID},
+			Message: &api.Message{Chat: api.Chat{ID: testBuyerTelegramID, Type: "private"}},
+			Data:    CallbackQueryPrefixBuy + "week",
+		},
+	}
+	if err := p.telegram.handleCallbackBuy(update); err != nil {
+		t.Fatalf("failed to handle buy callback: %v", err)
+	}
+
+	invoices := p.bot.takeRequests("sendInvoice")
+	if len(invoices) != 1 {
+		t.Fatalf("sent %v invoices, want 1", len(invoices))
+	}
+	invoice := invoices[0]
+	if currency := invoice.Get("currency"); currency != PaymentCurrency {
+		t.Errorf("currency = %q, want %q", currency, PaymentCurrency)
+	}
+	if payload := invoice.Get("payload"); payload != "week" {
+		t.Errorf("payload = %q, want %q", payload, "week")
+	}
+	if token := invoice.Get("provider_token"); token != "" {
+		t.Errorf("provider_token = %q, payments in stars have no provider", token)
+	}
+	if prices := invoice.Get("prices"); !strings.Contains(prices, `"amount":50`) {
+		t.Errorf("prices = %s, want amount 50", prices)
+	}
+}
+
+func TestHandlePreCheckoutQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		currency  string
+		amount    int
+		roles     []model.UserRole
+		wantOK    bool
+		wantError string
+	}{
+		{
+			name:     "role product",
+			payload:  "week",
+			currency: PaymentCurrency,
+			amount:   50,
+			wantOK:   true,
+		},
+		{
+			name:     "tokens product with permanent role",
+			payload:  "tokens",
+			currency: PaymentCurrency,
+			amount:   20,
+			roles:    []model.UserRole{model.UserRoleDefault, model.UserRolePremium},
+			wantOK:   true,
+		},
+		{
+			name:      "unknown product",
+			payload:   "month",
+			currency:  PaymentCurrency,
+			amount:    50,
+			wantError: MessageProductNotAvailable.Default,
+		},
+		{
+			name:      "changed price",
+			payload:   "week",
+			currency:  PaymentCurrency,
+			amount:    40,
+			wantError: MessageProductPriceChanged.Default,
+		},
+		{
+			name:      "other currency",
+			payload:   "week",
+			currency:  "USD",
+			amount:    50,
+			wantError: MessageProductPriceChanged.Default,
+		},
+		{
+			name:      "permanent role",
+			payload:   "week",
+			currency:  PaymentCurrency,
+			amount:    50,
+			roles:     []model.UserRole{model.UserRoleDefault, model.UserRolePremium},
+			wantError: fmt.Sprintf(MessageProductRoleAlreadyGrantedFormat.Default, model.UserRolePremium),
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				p := newPaymentTest(t)
+				if len(test.roles) != 0 {
+					_, err := p.users.CreateNewTelegramUser(context.Background(), testBuyerTelegramID, test.roles)
+					if err != nil {
+						t.Fatalf("failed to create user: %v", err)
+					}
+				}
+				query := &api.PreCheckoutQuery{
+					ID:             "query",
+					From:           &api.User{ID: testBuyerTelegramID},
+					Currency:       test.currency,
+					TotalAmount:    test.amount,
+					InvoicePayload: test.payload,
+				}
+				if err := p.telegram.handlePreCheckoutQuery(query); err != nil {
+					t.Fatalf("failed to handle pre-checkout query: %v", err)
+				}
+
+				answers := p.bot.takeRequests("answerPreCheckoutQuery")
+				if len(answers) != 1 {
+					t.Fatalf("sent %v answers, want 1", len(answers))
+				}
+				answer := answers[0]
+				if ok := answer.Get("ok") == "true"; ok != test.wantOK {
+					t.Errorf("ok = %v, want %v", ok, test.wantOK)
+				}
+				if errorMessage := answer.Get("error_message"); errorMessage != test.wantError {
+					t.Errorf("error_message = %q, want %q", errorMessage, test.wantError)
+				}
+			},
+		)
+	}
+}
+
+func TestHandleSuccessfulPaymentGivesProductOnce(t *testing.T) {
+	p := newPaymentTest(t)
+	p.pay(t, testBuyerTelegramID, "charge", "week")
+	user := p.users.getTelegramUser(t, testBuyerTelegramID)
+	expiresAt, ok := user.RoleExpiresAt[model.UserRolePremium]
+	if !ok {
+		t.Fatalf("user has no expiry of premium role, roles: %v", user.Roles)
+	}
+	if len(p.bot.takeRequests("sendMessage")) != 1 {
+		t.Errorf("user isn't thanked for the payment")
+	}
+
+	p.pay(t, testBuyerTelegramID, "charge", "week")
+	user = p.users.getTelegramUser(t, testBuyerTelegramID)
+	if !user.RoleExpiresAt[model.UserRolePremium].Equal(expiresAt) {
+		t.Errorf("repeated payment changed the expiry from %v to %v", expiresAt, user.RoleExpiresAt[model.UserRolePremium])
+	}
+	if messages := p.bot.takeRequests("sendMessage"); len(messages) != 0 {
+		t.Errorf("repeated payment sent %v messages, want 0", len(messages))
+	}
+	_, err := p.telegram.Payment.CompletePayment(context.Background(), testBuyerTelegramID, "charge", "week", 50)
+	if !errors.Is(err, ErrPaymentAlreadyCompleted) {
+		t.Errorf("CompletePayment() error = %v, want %v", err, ErrPaymentAlreadyCompleted)
+	}
+}
+
+func TestHandleSuccessfulPaymentRefundsPermanentRole(t *testing.T) {
+	p := newPaymentTest(t)
+	roles := []model.UserRole{model.UserRoleDefault, model.UserRolePremium}
+	if _, err := p.users.CreateNewTelegramUser(context.Background(), testBuyerTelegramID, roles); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	p.pay(t, testBuyerTelegramID, "charge", "week")
+
+	refunds := p.bot.takeRequests("refundStarPayment")
+	if len(refunds) != 1 {
+		t.Fatalf("sent %v refunds, want 1", len(refunds))
+	}
+	if chargeID := refunds[0].Get("telegram_payment_charge_id"); chargeID != "charge" {
+		t.Errorf("refunded charge = %q, want %q", chargeID, "charge")
+	}
+	if userID := refunds[0].Get("user_id"); userID != fmt.Sprint(testBuyerTelegramID) {
+		t.Errorf("refunded user = %q, want %v", userID, testBuyerTelegramID)
+	}
+	payment, err := p.payments.GetPayment(context.Background(), "charge")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if !payment.IsRefunded() {
+		t.Errorf("payment isn't marked refunded")
+	}
+	user := p.users.getTelegramUser(t, testBuyerTelegramID)
+	if _, hasExpiry := user.RoleExpiresAt[model.UserRolePremium]; hasExpiry {
+		t.Errorf("permanent premium role got expiry %v", user.RoleExpiresAt[model.UserRolePremium])
+	}
+}
+
+func TestHandleCommandRefund(t *testing.T) {
+	p := newPaymentTest(t)
+	p.pay(t, testBuyerTelegramID, "charge", "week")
+	p.bot.takeRequests("")
+
+	admin, err := p.telegram.User.GetUserInfoForTelegramUser(context.Background(), testAdminTelegramID)
+	if err != nil {
+		t.Fatalf("failed to get admin: %v", err)
+	}
+	text := "/" + CommandRefund + " charge"
+	message := &api.Message{
+		From:     &api.User{ID: testAdminTelegramID},
+		Chat:     api.Chat{ID: testAdminTelegramID, Type: "private"},
+		Text:     text,
+		Entities: []api.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(CommandRefund) + 1}},
+	}
+	if err = p.telegram.handleCommandPayments(context.Background(), admin, message); err != nil {
+		t.Fatalf("failed to handle refund command: %v", err)
+	}
+
+	refunds := p.bot.takeRequests("refundStarPayment")
+	if len(refunds) != 1 {
+		t.Fatalf("sent %v refunds, want 1", len(refunds))
+	}
+	if chargeID := refunds[0].Get("telegram_payment_charge_id"); chargeID != "charge" {
+		t.Errorf("refunded charge = %q, want %q", chargeID, "charge")
+	}
+	user := p.users.getTelegramUser(t, testBuyerTelegramID)
+	for _, role := range user.Roles {
+		if role == model.UserRolePremium {
+			t.Errorf("refunded premium role wasn't taken back, roles: %v", user.Roles)
+		}
+	}
+	payment, err := p.payments.GetPayment(context.Background(), "charge")
+	if err != nil {
+		t.Fatalf("failed to get payment: %v", err)
+	}
+	if !payment.IsRefunded() {
+		t.Errorf("payment isn't marked refunded")
+	}
+
+	if err = p.telegram.handleCommandPayments(context.Background(), admin, message); err != nil {
+		t.Fatalf("failed to handle repeated refund command: %v", err)
+	}
+	if refunds = p.bot.takeRequests("refundStarPayment"); len(refunds) != 0 {
+		t.Errorf("refunded payment was refunded %v times again", len(refunds))
+	}
+}
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"math"
+)
+
+var (
+	MessageRateLimitedFormat = local.NewSet(
+		"You are sending messages too fast. Try again in %v s.",
+		local.NewTrans(local.Rus, "Вы отправляете сообщения слишком часто. Попробуйте снова через %v с."),
+	)
+	MessageGenerationInProgress = local.NewSet(
+		"I'm still answering the previous message, wait for the answer to finish.",
+		local.NewTrans(local.Rus, "Я ещё отвечаю на предыдущее сообщение, дождитесь окончания ответа."),
+	)
+)
+
+// waitRateLimit waits for the turn of the message sender. The sender is told about the rate limit and false is
+// returned, if the message is rejected.
+func (t *TelegramUsecase) waitRateLimit(ctx context.Context, message *api.Message, chatUser model.User) (
+	bool,
+	error,
+) {
+	chatID := message.Chat.ID
+	from := message.From
+	sender, err := t.getSenderUser(ctx, message, chatUser)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return false, fmt.Errorf("failed to get sender user: %w", err)
+	}
+	return t.waitSenderRateLimit(chatID, from, sender)
+}
+
+// waitSenderRateLimit waits for the turn of the sender in the chat, see waitRateLimit.
+func (t *TelegramUsecase) waitSenderRateLimit(chatID int64, from *api.User, sender model.User) (bool, error) {
+	// The wait is limited by the queue timeout, not by the timeout of the handler.
+	err := t.RateLimit.Wait(context.Background(), from.ID, sender)
+	if err == nil {
+		return true, nil
+	}
+	var rateLimitedErr *RateLimitedError
+	if !errors.As(err, &rateLimitedErr) {
+		return false, fmt.Errorf("failed to wait for rate limit: %w", err)
+	}
+	fmt.Printf("telegram user %v is rate limited for %s\n", from.ID, rateLimitedErr.RetryAfter)
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageRateLimitedFormat, math.Ceil(rateLimitedErr.RetryAfter.Seconds()),
+	)
+	return false, nil
+}
+
+// startGeneration marks the AI chat answering. The user is told that the AI chat is busy and false is returned,
+// if it is answering another message.
+func (t *TelegramUsecase) startGeneration(aiChat model.AIChat, message *api.Message) (func(), bool) {
+	finish, err := t.RateLimit.StartGeneration(aiChat.ChatID)
+	if err != nil {
+		t.sendMessageAndHandleErr(message.Chat.ID, message.From, MessageGenerationInProgress)
+		return nil, false
+	}
+	return finish, true
+}
@@ -0,0 +1,60 @@
+package usecase
+
+import (
+	"context"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"time"
+)
+
+var (
+	MessageRoleExpiresFormat = local.NewSet(
+		"Your role %s expires at %s.",
+		local.NewTrans(local.Rus, "Ваша роль %s истекает %s."),
+	)
+	MessageRoleExpiredFormat = local.NewSet(
+		"Your role %s has expired.",
+		local.NewTrans(local.Rus, "Срок вашей роли %s истёк."),
+	)
+	MessageRoleExtendHint = local.NewSet(
+		" Use /buy to extend it.",
+		local.NewTrans(local.Rus, " Воспользуйтесь командой /buy, чтобы продлить её."),
+	)
+)
+
+const roleExpiryTimeLayout = "2006-01-02 15:04"
+
+// runRoleExpiryScheduler checks roles given for a time every check interval until the bot stops. Users get
+// notifications in the default language, their language is known only from their updates.
+func (t *TelegramUsecase) runRoleExpiryScheduler() {
+	if t.RoleExpiry == nil || t.RoleExpiry.CheckInterval() <= 0 {
+		return
+	}
+	ticker := time.NewTicker(t.RoleExpiry.CheckInterval())
+	defer ticker.Stop()
+	for now := range ticker.C {
+		t.checkRoleExpiry(now)
+	}
+}
+
+// checkRoleExpiry notifies users about expired and expiring roles. Every user is checked with its own timeout.
+func (t *TelegramUsecase) checkRoleExpiry(now time.Time) {
+	events, err := t.RoleExpiry.CheckRoles(context.Background(), now)
+	if err != nil {
+		log.Printf("failed to check role expiry: %v\n", err)
+	}
+	for _, event := range events {
+		text := getLocalFormatText(nil, MessageRoleExpiredFormat, event.Role)
+		if !event.Expired {
+			expiresAt := event.ExpiresAt.In(t.User.GetUserLocation(event.User)).Format(roleExpiryTimeLayout)
+			text = getLocalFormatText(nil, MessageRoleExpiresFormat, event.Role, expiresAt)
+		}
+		if t.Payment.SellsRole(event.Role) {
+			text += getLocalText(nil, MessageRoleExtendHint)
+		}
+		if _, err = t.Bot.Send(api.NewMessage(event.User.TelegramID, text)); err != nil {
+			log.Printf("failed to notify telegram user %v about role expiry: %v\n", event.User.TelegramID, err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	MessageTranscriptFormat = local.NewSet(
+		"🎤 %s",
+	)
+	MessageVoiceNotRecognized = local.NewSet(
+		"I couldn't recognize any speech in this message.",
+		local.NewTrans(local.Rus, "Не удалось распознать речь в этом сообщении."),
+	)
+	MessageAudioTooLargeFormat = local.NewSet(
+		"Audio is too large. Maximum size is %v KB.",
+		local.NewTrans(local.Rus, "Аудио слишком большое. Максимальный размер %v КБ."),
+	)
+	MessageVoiceRepliesOn = local.NewSet(
+		"Answers in this chat will be also sent as voice messages.",
+		local.NewTrans(local.Rus, "Ответы в этом чате будут также приходить голосовыми сообщениями."),
+	)
+	MessageVoiceRepliesOff = local.NewSet(
+		"Voice answers are turned off. Use 🔊 under an answer to listen to it.",
+		local.NewTrans(local.Rus, "Голосовые ответы выключены. Нажмите 🔊 под ответом, чтобы прослушать его."),
+	)
+	MessageVoiceUsage = local.NewSet(
+		"Use `/voice on` or `/voice off` to turn voice answers in the current chat on or off.",
+		local.NewTrans(
+			local.Rus, "Воспользуйтесь `/voice on` или `/voice off`, чтобы включить или выключить голосовые ответы в текущем чате.",
+		),
+	)
+
+	CommandVoiceInfo = local.NewSet(
+		"Turn voice answers on or off",
+		local.NewTrans(local.Rus, "Включить или выключить голосовые ответы"),
+	)
+)
+
+const (
+	CommandVoice = "voice"
+
+	// CallbackQueryPrefixSpeech is followed by the AI chat ID and the index of the answer in it.
+	CallbackQueryPrefixSpeech = "speech_"
+
+	speechButtonText = "🔊"
+)
+
+var (
+	ErrVoiceNotTranscribed = errors.New("voice not transcribed")
+)
+
+// transcribeVoice downloads the voice or audio of the message, replies with the transcript and returns it.
+// The transcription cost is counted for the sender.
+func (t *TelegramUsecase) transcribeVoice(ctx context.Context, sender model.User, message *api.Message) (
+	string,
+	error,
+) {
+	chatID := message.Chat.ID
+	from := message.From
+	maxFileSize := t.Speech.MaxFileSize()
+
+	var fileID, fileName string
+	var fileSize int64
+	var duration int
+	switch {
+	case message.Voice != nil:
+		fileID, fileName, fileSize = message.Voice.FileID, "voice.ogg", message.Voice.FileSize
+		duration = message.Voice.Duration
+	case message.Audio != nil:
+		fileID, fileName, fileSize = message.Audio.FileID, message.Audio.FileName, message.Audio.FileSize
+		duration = message.Audio.Duration
+		if len(fileName) == 0 {
+			fileName = "audio.mp3"
+		}
+	default:
+		return "", ErrVoiceNotTranscribed
+	}
+
+	if fileSize > maxFileSize {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageAudioTooLargeFormat, maxFileSize/1024)
+		return "", ErrVoiceNotTranscribed
+	}
+	_, err := t.sendChatAction(chatID, api.ChatTyping)
+	if err != nil {
+		log.Printf("failed to send new action to bot: %v\n", err)
+	}
+	data, err := t.downloadFile(fileID, maxFileSize)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageAudioTooLargeFormat, maxFileSize/1024)
+			return "", ErrVoiceNotTranscribed
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return "", fmt.Errorf("failed to download audio: %w", err)
+	}
+
+	transcript, err := t.Speech.Transcribe(ctx, fileName, data)
+	if err == nil || errors.Is(err, ErrEmptyTranscript) {
+		cost := t.Speech.GetTranscriptionCost(time.Duration(duration) * time.Second)
+		t.recordQuotaUsage(sender, model.Usage{Cost: cost})
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEmptyTranscript):
+			t.sendMessageAndHandleErr(chatID, from, MessageVoiceNotRecognized)
+			return "", ErrVoiceNotTranscribed
+		case errors.Is(err, ErrAudioTooLarge):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageAudioTooLargeFormat, maxFileSize/1024)
+			return "", ErrVoiceNotTranscribed
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+
+	// The transcript is sent without markdown, so user speech can't break message formatting.
+	msg := api.NewMessage(chatID, getLocalFormatText(from, MessageTranscriptFormat, transcript))
+	msg.ReplyParameters.MessageID = message.MessageID
+	if _, err = t.sendToBot(msg); err != nil {
+		log.Printf("failed to send transcript to bot: %v\n", err)
+	}
+	return transcript, nil
+}
+
+func (t *TelegramUsecase) handleCommandVoice(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	args string,
+) error {
+	var enabled bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		t.sendMessageAndHandleErr(chatID, from, MessageVoiceUsage)
+		return nil
+	}
+
+	aiChat, err := t.getAIChat(ctx, user, chatID, from)
+	if err != nil {
+		if errors.Is(err, ErrAIChatNotCreatedYet) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user ai-chat: %w", err)
+	}
+	if err = t.AIChat.UpdateChatVoiceReplies(ctx, aiChat.ChatID, enabled); err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to update chat voice replies: %w", err)
+	}
+	if enabled {
+		t.sendMessageAndHandleErr(chatID, from, MessageVoiceRepliesOn)
+	} else {
+		t.sendMessageAndHandleErr(chatID, from, MessageVoiceRepliesOff)
+	}
+	return nil
+}
+
+// handleCallbackSpeech sends the answer under the pressed speech button as voice. The answer is taken from the AI
+// chat, so tool statuses and knowledge sources of the message aren't spoken. Clicks are ignored while the answer
+// is being synthesized.
+func (t *TelegramUsecase) handleCallbackSpeech(ctx context.Context, update api.Update) error {
+	message := update.CallbackQuery.Message
+	chatID := message.Chat.ID
+	callbackQueryID := update.CallbackQuery.ID
+	from := update.CallbackQuery.From
+
+	callback := api.NewCallback(callbackQueryID, "")
+	if _, err := t.Bot.Request(callback); err != nil {
+		return fmt.Errorf("failed to request callback: %w", err)
+	}
+
+	user, err := t.getChatUser(ctx, chatID, from)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get user info for telegram user: %w", err)
+	}
+	if !t.User.HasAccess(user) {
+		t.sendMessageAndHandleErr(chatID, from, MessageUserNoAccess)
+		return nil
+	}
+	aiChatID, answerIndex, err := parseSpeechCallbackData(update.CallbackQuery.Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse speech callback data: %w", err)
+	}
+	aiChat, err := t.AIChat.GetChat(ctx, aiChatID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get ai chat: %w", err)
+	}
+	if aiChat.UserID != user.UserID || answerIndex >= len(aiChat.Messages) ||
+		aiChat.Messages[answerIndex].Source != model.MessageSourceAssistant {
+		return fmt.Errorf("answer %d of ai chat %s isn't found for user %s", answerIndex, aiChatID, user.UserID)
+	}
+	answer := aiChat.Messages[answerIndex].Body
+
+	finishSynthesis, err := t.Speech.StartSynthesis(aiChatID, answerIndex)
+	if err != nil {
+		return nil
+	}
+	sender, err := t.getChatMemberUser(ctx, message.Chat, from, user)
+	if err != nil {
+		finishSynthesis()
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get sender user: %w", err)
+	}
+	if ok, err := t.waitSenderRateLimit(chatID, from, sender); !ok {
+		finishSynthesis()
+		return err
+	}
+	// Synthesis is limited by the speech timeout, so long answers don't hold other callbacks.
+	go func() {
+		defer finishSynthesis()
+		if err := t.sendAnswerVoice(context.Background(), sender, chatID, message.MessageID, answer); err != nil {
+			t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+			log.Printf("failed to send answer voice: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// parseSpeechCallbackData returns the AI chat and the index of the answer from the speech button data.
+func parseSpeechCallbackData(data string) (uuid.UUID, int, error) {
+	aiChatIDStr, answerIndexStr, ok := strings.Cut(strings.TrimPrefix(data, CallbackQueryPrefixSpeech), "_")
+	if !ok {
+		return uuid.Nil, 0, fmt.Errorf("answer index is missing in %s", data)
+	}
+	aiChatID, err := uuid.Parse(aiChatIDStr)
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("failed to parse ai chat id %s: %w", aiChatIDStr, err)
+	}
+	answerIndex, err := strconv.Atoi(answerIndexStr)
+	if err != nil || answerIndex < 0 {
+		return uuid.Nil, 0, fmt.Errorf("invalid answer index %s", answerIndexStr)
+	}
+	return aiChatID, answerIndex, nil
+}
+
+// finishAnswer is called when the answer is fully streamed and saved at answerIndex of the AI chat. The answer
+// is sent as voice if voice replies are enabled for the chat, otherwise the speech button is added under it.
+func (t *TelegramUsecase) finishAnswer(
+	ctx context.Context,
+	sender model.User,
+	aiChat model.AIChat,
+	chatID int64,
+	answerMsgID int,
+	answer string,
+	answerIndex int,
+) {
+	if aiChat.VoiceReplies {
+		if err := t.sendAnswerVoice(ctx, sender, chatID, answerMsgID, answer); err != nil {
+			log.Printf("failed to send answer voice: %v\n", err)
+		}
+		return
+	}
+	speechData := fmt.Sprintf("%s%s_%d", CallbackQueryPrefixSpeech, aiChat.ChatID, answerIndex)
+	markup := api.NewInlineKeyboardMarkup(
+		api.NewInlineKeyboardRow(api.NewInlineKeyboardButtonData(speechButtonText, speechData)),
+	)
+	if _, err := t.Bot.Request(api.NewEditMessageReplyMarkup(chatID, answerMsgID, markup)); err != nil {
+		log.Printf("failed to add speech button to answer: %v\n", err)
+	}
+}
+
+// sendAnswerVoice sends the answer as voice with the voice settings of the sender and counts the speech cost
+// for the sender.
+func (t *TelegramUsecase) sendAnswerVoice(
+	ctx context.Context,
+	sender model.User,
+	chatID int64,
+	answerMsgID int,
+	answer string,
+) error {
+	_, err := t.sendChatAction(chatID, api.ChatRecordVoice)
+	if err != nil {
+		log.Printf("failed to send new action to bot: %v\n", err)
+	}
+	audio, settings, err := t.Speech.Synthesize(ctx, sender, answer)
+	if err != nil {
+		return fmt.Errorf("failed to synthesize answer: %w", err)
+	}
+	t.recordQuotaUsage(sender, model.Usage{Cost: t.Speech.GetSpeechCost(answer)})
+
+	fileName := "answer." + settings.Format
+	if settings.Format == "opus" {
+		fileName = "answer.ogg"
+	}
+	voice := api.NewVoice(chatID, api.FileBytes{Name: fileName, Bytes: audio})
+	voice.ReplyParameters.MessageID = answerMsgID
+	if _, err = t.sendToBot(voice); err != nil {
+		return fmt.Errorf("failed to send voice: %w", err)
+	}
+	return nil
+}
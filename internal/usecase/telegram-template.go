@@ -0,0 +1,265 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"log"
+	"strings"
+)
+
+var (
+	MessageTemplatesUsage = local.NewSet(
+		"Templates commands:\n"+
+			"`/templates` - show templates\n"+
+			"`/templates add <name> <text>` - save a template, use `{{name}}` for placeholders\n"+
+			"`/templates delete <name>` - delete a template\n"+
+			"`/t <name> <args>` - fill the template and send it",
+		local.NewTrans(
+			local.Rus, "Команды шаблонов:\n"+
+				"`/templates` - показать шаблоны\n"+
+				"`/templates add <имя> <текст>` - сохранить шаблон, `{{имя}}` задаёт подстановку\n"+
+				"`/templates delete <имя>` - удалить шаблон\n"+
+				"`/t <имя> <аргументы>` - заполнить шаблон и отправить его",
+		),
+	)
+	MessageTemplatesEmpty = local.NewSet(
+		"You don't have any templates. Use `/templates add <name> <text>` to save one.",
+		local.NewTrans(
+			local.Rus, "У вас нет шаблонов. Воспользуйтесь `/templates add <имя> <текст>`, чтобы сохранить шаблон.",
+		),
+	)
+	MessageTemplatesFormat = local.NewSet(
+		"Templates: %v. Use `/t <name> <args>` to send one.",
+		local.NewTrans(local.Rus, "Шаблонов: %v. Воспользуйтесь `/t <имя> <аргументы>`, чтобы отправить шаблон."),
+	)
+	MessageTemplateInfoFormat = local.NewSet(
+		"\n• `%s` %s",
+	)
+	MessageTemplateShared = local.NewSet(
+		"(shared)",
+		local.NewTrans(local.Rus, "(общий)"),
+	)
+	MessageTemplateSavedFormat = local.NewSet(
+		"Template `%s` was saved.",
+		local.NewTrans(local.Rus, "Шаблон `%s` сохранён."),
+	)
+	MessageTemplateDeletedFormat = local.NewSet(
+		"Template `%s` was deleted.",
+		local.NewTrans(local.Rus, "Шаблон `%s` удалён."),
+	)
+	MessageTemplateNotFoundFormat = local.NewSet(
+		"There is no template `%s`. Use `/templates` to see available templates.",
+		local.NewTrans(local.Rus, "Шаблона `%s` нет. Воспользуйтесь `/templates`, чтобы увидеть доступные шаблоны."),
+	)
+	MessageTemplateInvalidName = local.NewSet(
+		"Template name can contain only latin letters, digits, `-` and `_` and be up to 32 characters long.",
+		local.NewTrans(
+			local.Rus, "Имя шаблона может содержать только латинские буквы, цифры, `-` и `_` и быть не длиннее 32 символов.",
+		),
+	)
+	MessageTemplateNameTakenFormat = local.NewSet(
+		"`%s` is a shared template, choose another name.",
+		local.NewTrans(local.Rus, "`%s` - общий шаблон, выберите другое имя."),
+	)
+	MessageTooManyTemplatesFormat = local.NewSet(
+		"You can have at most %v templates, delete some with `/templates delete`.",
+		local.NewTrans(local.Rus, "Можно сохранить не больше %v шаблонов, удалите лишние через `/templates delete`."),
+	)
+	MessageTemplateMissingArgsFormat = local.NewSet(
+		"Template `%s` expects arguments: %s.",
+		local.NewTrans(local.Rus, "Шаблон `%s` ожидает аргументы: %s."),
+	)
+	MessageTemplatePromptFormat = local.NewSet(
+		"📝 %s",
+	)
+
+	CommandTemplatesInfo = local.NewSet(
+		"Manage prompt templates",
+		local.NewTrans(local.Rus, "Управление шаблонами запросов"),
+	)
+	CommandTemplateInfo = local.NewSet(
+		"Send prompt from template",
+		local.NewTrans(local.Rus, "Отправить запрос по шаблону"),
+	)
+)
+
+const (
+	CommandTemplates = "templates"
+	CommandTemplate  = "t"
+
+	templatesActionAdd    = "add"
+	templatesActionDelete = "delete"
+)
+
+func (t *TelegramUsecase) handleCommandTemplates(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	args string,
+) error {
+	action, rest := cutWord(args)
+	switch strings.ToLower(action) {
+	case "":
+		return t.sendTemplates(ctx, user, chatID, from)
+	case templatesActionAdd:
+		name, text := cutWord(rest)
+		if len(name) == 0 || len(text) == 0 {
+			t.sendMessageAndHandleErr(chatID, from, MessageTemplatesUsage)
+			return nil
+		}
+		return t.saveTemplate(ctx, user, chatID, from, name, text)
+	case templatesActionDelete:
+		if len(rest) == 0 {
+			t.sendMessageAndHandleErr(chatID, from, MessageTemplatesUsage)
+			return nil
+		}
+		return t.deleteTemplate(ctx, user, chatID, from, rest)
+	}
+	t.sendMessageAndHandleErr(chatID, from, MessageTemplatesUsage)
+	return nil
+}
+
+func (t *TelegramUsecase) sendTemplates(ctx context.Context, user model.User, chatID int64, from *api.User) error {
+	templates, err := t.Template.ListTemplates(ctx, user.UserID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+	if len(templates) == 0 {
+		t.sendMessageAndHandleErr(chatID, from, MessageTemplatesEmpty)
+		return nil
+	}
+
+	result := strings.Builder{}
+	result.WriteString(getLocalFormatText(from, MessageTemplatesFormat, len(templates)))
+	for _, template := range templates {
+		info := make([]string, 0, 2)
+		if placeholders := TemplatePlaceholders(template); len(placeholders) != 0 {
+			info = append(info, api.EscapeText(api.ModeMarkdown, strings.Join(placeholders, " ")))
+		}
+		if template.Shared {
+			info = append(info, getLocalText(from, MessageTemplateShared))
+		}
+		result.WriteString(getLocalFormatText(from, MessageTemplateInfoFormat, template.Name, strings.Join(info, " ")))
+	}
+	t.sendMessageAndHandleErrNoLocal(chatID, result.String())
+	return nil
+}
+
+func (t *TelegramUsecase) saveTemplate(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	name string,
+	text string,
+) error {
+	if err := t.Template.SaveTemplate(ctx, user, name, text); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidTemplateName), errors.Is(err, ErrEmptyTemplate):
+			t.sendMessageAndHandleErr(chatID, from, MessageTemplateInvalidName)
+			return nil
+		case errors.Is(err, ErrTemplateNameTaken):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTemplateNameTakenFormat, name)
+			return nil
+		case errors.Is(err, ErrTooManyTemplates):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTooManyTemplatesFormat, t.Template.MaxTemplates(user))
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to save template: %w", err)
+	}
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageTemplateSavedFormat, strings.ToLower(name))
+	return nil
+}
+
+func (t *TelegramUsecase) deleteTemplate(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	name string,
+) error {
+	if err := t.Template.DeleteTemplate(ctx, user.UserID, name); err != nil {
+		switch {
+		case errors.Is(err, model.ErrPromptTemplateDoesNotExist):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTemplateNotFoundFormat, name)
+			return nil
+		case errors.Is(err, ErrTemplateNameTaken):
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTemplateNameTakenFormat, name)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageTemplateDeletedFormat, strings.ToLower(name))
+	return nil
+}
+
+// handleCommandTemplate fills the template with the arguments and sends the prompt to the current chat
+// like a usual message.
+func (t *TelegramUsecase) handleCommandTemplate(
+	ctx context.Context,
+	user model.User,
+	message *api.Message,
+	args string,
+) error {
+	chatID := message.Chat.ID
+	from := message.From
+
+	name, templateArgs := cutWord(args)
+	if len(name) == 0 {
+		return t.sendTemplates(ctx, user, chatID, from)
+	}
+	template, err := t.Template.GetTemplate(ctx, user.UserID, strings.ToLower(name))
+	if err != nil {
+		if errors.Is(err, model.ErrPromptTemplateDoesNotExist) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTemplateNotFoundFormat, name)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	prompt, err := ExpandTemplate(template, templateArgs)
+	if err != nil {
+		var missingArgsErr *MissingTemplateArgsError
+		if errors.As(err, &missingArgsErr) {
+			t.sendFormatMessageAndHandleErr(
+				chatID, from, MessageTemplateMissingArgsFormat, template.Name,
+				api.EscapeText(api.ModeMarkdown, strings.Join(missingArgsErr.Placeholders, ", ")),
+			)
+			return nil
+		}
+		return fmt.Errorf("failed to expand template: %w", err)
+	}
+
+	aiChat, err := t.getAIChat(ctx, user, chatID, from)
+	if err != nil {
+		if errors.Is(err, ErrAIChatNotCreatedYet) {
+			return nil
+		}
+		return fmt.Errorf("failed to get user ai-chat: %w", err)
+	}
+	sender, ok, err := t.checkChatAccess(ctx, user, aiChat, message)
+	if !ok {
+		return err
+	}
+	finishGeneration, ok := t.startGeneration(aiChat, message)
+	if !ok {
+		return nil
+	}
+	defer finishGeneration()
+
+	// The prompt is sent without markdown, so template text can't break message formatting.
+	msg := api.NewMessage(chatID, getLocalFormatText(from, MessageTemplatePromptFormat, prompt))
+	msg.ReplyParameters.MessageID = message.MessageID
+	if _, err = t.sendToBot(msg); err != nil {
+		log.Printf("failed to send template prompt to bot: %v\n", err)
+	}
+	return t.answerMessage(ctx, user, sender, aiChat, message, prompt)
+}
@@ -0,0 +1,59 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"strings"
+)
+
+var (
+	MessageTimezoneFormat = local.NewSet(
+		"Your timezone is `%s`. Use `/timezone Europe/Berlin` to change it.",
+		local.NewTrans(local.Rus, "Ваш часовой пояс `%s`. Воспользуйтесь `/timezone Europe/Moscow`, чтобы изменить его."),
+	)
+	MessageTimezoneUpdatedFormat = local.NewSet(
+		"Timezone changed to `%s`.",
+		local.NewTrans(local.Rus, "Часовой пояс изменён на `%s`."),
+	)
+	MessageUnknownTimezoneFormat = local.NewSet(
+		"Unknown timezone `%s`. Use IANA names like `Europe/Berlin`.",
+		local.NewTrans(local.Rus, "Неизвестный часовой пояс `%s`. Используйте названия IANA, например `Europe/Moscow`."),
+	)
+
+	CommandTimezoneInfo = local.NewSet(
+		"Show or change your timezone",
+		local.NewTrans(local.Rus, "Показать или изменить часовой пояс"),
+	)
+)
+
+const (
+	CommandTimezone = "timezone"
+)
+
+func (t *TelegramUsecase) handleCommandTimezone(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	args string,
+) error {
+	timezone := strings.TrimSpace(args)
+	if len(timezone) == 0 {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageTimezoneFormat, t.User.GetUserLocation(user))
+		return nil
+	}
+	if err := t.User.UpdateUserTimezone(ctx, user.UserID, timezone); err != nil {
+		if errors.Is(err, ErrUnknownTimezone) {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageUnknownTimezoneFormat, timezone)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to update user timezone: %w", err)
+	}
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageTimezoneUpdatedFormat, timezone)
+	return nil
+}
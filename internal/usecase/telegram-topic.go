@@ -0,0 +1,246 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+	"strings"
+)
+
+var (
+	MessageTopicUsage = local.NewSet(
+		"Use `/topic <model or persona>` to bind this topic, new chats of the topic will use it. "+
+			"`/topic reset` removes the binding.",
+		local.NewTrans(
+			local.Rus, "Воспользуйтесь `/topic <модель или персона>`, чтобы привязать эту тему, новые чаты темы будут "+
+				"использовать её. `/topic reset` убирает привязку.",
+		),
+	)
+	MessageTopicBoundFormat = local.NewSet(
+		"This topic is bound to %s.",
+		local.NewTrans(local.Rus, "Эта тема привязана к %s."),
+	)
+	MessageTopicNotBound = local.NewSet(
+		"This topic is not bound to a model.",
+		local.NewTrans(local.Rus, "Эта тема не привязана к модели."),
+	)
+	MessageTopicBindingReset = local.NewSet(
+		"Topic binding was removed.",
+		local.NewTrans(local.Rus, "Привязка темы удалена."),
+	)
+	MessageTopicOnly = local.NewSet(
+		"This command works only inside forum topics.",
+		local.NewTrans(local.Rus, "Эта команда работает только внутри тем форума."),
+	)
+	MessageTopicAdminOnly = local.NewSet(
+		"Only chat administrators can bind topics.",
+		local.NewTrans(local.Rus, "Привязывать темы могут только администраторы чата."),
+	)
+
+	CommandTopicInfo = local.NewSet(
+		"Bind forum topic to model or persona",
+		local.NewTrans(local.Rus, "Привязать тему форума к модели или персоне"),
+	)
+)
+
+const (
+	CommandTopic = "topic"
+
+	topicActionReset = "reset"
+)
+
+// inThread returns the usecase sending messages into the forum topic of the message. Messages outside of
+// topics are handled by t itself.
+func (t *TelegramUsecase) inThread(message *api.Message) *TelegramUsecase {
+	if message == nil || !message.IsTopicMessage || !message.Chat.IsForum {
+		return t
+	}
+	threadUsecase := *t
+	threadUsecase.threadID = message.MessageThreadID
+	return &threadUsecase
+}
+
+// withThread sets the forum topic of the handled update to messages sent by the bot.
+func (t *TelegramUsecase) withThread(c api.Chattable) api.Chattable {
+	if t.threadID == 0 {
+		return c
+	}
+	switch msg := c.(type) {
+	case api.MessageConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	case api.PhotoConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	case api.VoiceConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	case api.DocumentConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	case api.ChatActionConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	case api.InvoiceConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	case api.CopyMessageConfig:
+		msg.MessageThreadID = t.threadID
+		return msg
+	}
+	return c
+}
+
+func (t *TelegramUsecase) sendChatAction(chatID int64, action string) (*api.APIResponse, error) {
+	return t.Bot.Request(t.withThread(api.NewChatAction(chatID, action)))
+}
+
+// getChatUser returns the user owning AI chats of the Telegram chat with the current chat of the forum
+// topic, if the update comes from one. Chats of bound topics are created on the first message.
+func (t *TelegramUsecase) getChatUser(ctx context.Context, chatID int64, from *api.User) (model.User, error) {
+	user, err := t.User.GetUserInfoForTelegramChat(ctx, chatID, from.ID)
+	if err != nil || t.threadID == 0 {
+		return user, err
+	}
+	topic, err := t.Topic.GetTopicWithChat(ctx, user.UserID, chatID, t.threadID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get topic: %w", err)
+	}
+	user.LastAIChat = topic.AIChatID
+	return user, nil
+}
+
+// updateCurrentAIChat makes the AI chat current for the forum topic of the update or for the user.
+func (t *TelegramUsecase) updateCurrentAIChat(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	aiChatID uuid.UUID,
+) error {
+	if t.threadID != 0 {
+		return t.Topic.UpdateTopicAIChat(ctx, chatID, t.threadID, aiChatID)
+	}
+	return t.User.UpdateUserLastAIChat(ctx, user.UserID, aiChatID)
+}
+
+func (t *TelegramUsecase) handleCommandTopic(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+	args string,
+) error {
+	if t.threadID == 0 {
+		t.sendMessageAndHandleErr(chatID, from, MessageTopicOnly)
+		return nil
+	}
+	topic, err := t.Topic.GetTopic(ctx, chatID, t.threadID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get topic: %w", err)
+	}
+
+	name := strings.TrimSpace(args)
+	if len(name) == 0 {
+		if topic.IsBound() {
+			t.sendFormatMessageAndHandleErr(chatID, from, MessageTopicBoundFormat, getTopicBindingName(topic))
+		} else {
+			t.sendMessageAndHandleErr(chatID, from, MessageTopicNotBound)
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageTopicUsage)
+		return nil
+	}
+
+	isAdmin, err := t.isChatAdmin(user, chatID, from)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to check chat admin: %w", err)
+	}
+	if !isAdmin {
+		t.sendMessageAndHandleErr(chatID, from, MessageTopicAdminOnly)
+		return nil
+	}
+
+	if strings.EqualFold(name, topicActionReset) {
+		if err = t.Topic.ResetTopicBinding(ctx, topic); err != nil {
+			t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+			return fmt.Errorf("failed to reset topic binding: %w", err)
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageTopicBindingReset)
+		return nil
+	}
+	aiChat, err := t.Topic.BindTopic(ctx, user, topic, name)
+	if err != nil {
+		if errors.Is(err, ErrUserRoleHasNotAccessToModel) {
+			t.sendMessageAndHandleErr(chatID, from, MessageUserModelNoAccess)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to bind topic: %w", err)
+	}
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageTopicBoundFormat, getChatTitle(aiChat))
+	return nil
+}
+
+// createBoundTopicChat starts a new chat with the binding of the current topic. It reports false if the
+// update doesn't come from a bound topic.
+func (t *TelegramUsecase) createBoundTopicChat(
+	ctx context.Context,
+	user model.User,
+	chatID int64,
+	from *api.User,
+) (bool, error) {
+	if t.threadID == 0 {
+		return false, nil
+	}
+	topic, err := t.Topic.GetTopic(ctx, chatID, t.threadID)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return false, fmt.Errorf("failed to get topic: %w", err)
+	}
+	if !topic.IsBound() {
+		return false, nil
+	}
+	aiChat, err := t.Topic.CreateTopicChat(ctx, user.UserID, topic)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return true, fmt.Errorf("failed to create topic chat: %w", err)
+	}
+	if len(aiChat.Persona) != 0 {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageSelectedPersonaFormat, aiChat.Persona, aiChat.Model)
+	} else {
+		t.sendFormatMessageAndHandleErr(chatID, from, MessageSelectedModelFormat, aiChat.Model)
+	}
+	return true, nil
+}
+
+// isChatAdmin reports whether the sender can manage the chat: bot admins and administrators of the
+// Telegram chat can.
+func (t *TelegramUsecase) isChatAdmin(user model.User, chatID int64, from *api.User) (bool, error) {
+	if isAdmin(user) {
+		return true, nil
+	}
+	member, err := t.Bot.GetChatMember(
+		api.GetChatMemberConfig{
+			ChatConfigWithUser: api.ChatConfigWithUser{
+				ChatConfig: api.ChatConfig{ChatID: chatID},
+				UserID:     from.ID,
+			},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
+func getTopicBindingName(topic model.Topic) string {
+	if len(topic.Persona) != 0 {
+		return topic.Persona
+	}
+	return topic.Model
+}
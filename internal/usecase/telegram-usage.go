@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	api "github.com/OvyFlash/telegram-bot-api"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
+)
+
+var (
+	MessageUsageFormat = local.NewSet(
+		"Requests in 24 hours: %v/%s\nTokens in 30 days: %v/%s\nSpend in 30 days: $%.2f/%s\n"+
+			"Requests start to free up at %s, tokens and spend at %s.\nAll time: %v tokens, $%.2f.\n"+
+			"Token credits: %v.",
+		local.NewTrans(
+			local.Rus, "Запросов за 24 часа: %v/%s\nТокенов за 30 дней: %v/%s\nРасходы за 30 дней: $%.2f/%s\n"+
+				"Запросы начнут освобождаться %s, токены и расходы - %s.\nЗа всё время: %v токенов, $%.2f.\n"+
+				"Купленные токены: %v.",
+		),
+	)
+	MessageQuotaUnlimited = local.NewSet(
+		"∞",
+		local.NewTrans(local.Rus, "∞"),
+	)
+	MessageRequestsQuotaExceededFormat = local.NewSet(
+		"You have used all %v requests of the last 24 hours. They start to free up at %s.",
+		local.NewTrans(
+			local.Rus, "Вы использовали все запросы за последние 24 часа (%v). Они начнут освобождаться %s.",
+		),
+	)
+	MessageTokensQuotaExceededFormat = local.NewSet(
+		"You have used all %v tokens of the last 30 days. They start to free up at %s.",
+		local.NewTrans(
+			local.Rus, "Вы использовали все токены за последние 30 дней (%v). Они начнут освобождаться %s.",
+		),
+	)
+	MessageSpendQuotaExceededFormat = local.NewSet(
+		"You have reached the spend limit of $%.2f for the last 30 days. It starts to free up at %s.",
+		local.NewTrans(
+			local.Rus, "Вы достигли лимита расходов за последние 30 дней ($%.2f). Он начнёт освобождаться %s.",
+		),
+	)
+
+	CommandUsageInfo = local.NewSet(
+		"Show usage and quotas",
+		local.NewTrans(local.Rus, "Показать расход и лимиты"),
+	)
+)
+
+const (
+	CommandUsage = "usage"
+
+	quotaResetTimeLayout = "2006-01-02 15:04 MST"
+)
+
+// getSenderUser returns the user who sent the message. Quotas are counted for senders, so members of a group
+// chat spend their own quotas.
+func (t *TelegramUsecase) getSenderUser(ctx context.Context, message *api.Message, chatUser model.User) (
+	model.User,
+	error,
+) {
+	return t.getChatMemberUser(ctx, message.Chat, message.From, chatUser)
+}
+
+// getChatMemberUser returns the user of the chat member, in private chats it's the chat user.
+func (t *TelegramUsecase) getChatMemberUser(
+	ctx context.Context,
+	chat api.Chat,
+	from *api.User,
+	chatUser model.User,
+) (model.User, error) {
+	if !isGroupChat(chat) {
+		return chatUser, nil
+	}
+	return t.User.GetUserInfoForTelegramUser(ctx, from.ID)
+}
+
+func (t *TelegramUsecase) sendUsage(ctx context.Context, user model.User, message *api.Message) error {
+	chatID := message.Chat.ID
+	from := message.From
+	sender, err := t.getSenderUser(ctx, message, user)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get sender user: %w", err)
+	}
+	usage, err := t.Quota.GetUsage(ctx, sender)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get usage: %w", err)
+	}
+	quota := t.Quota.GetQuota(sender)
+	formatQuota := func(limit float64, format string) string {
+		if limit == 0 {
+			return getLocalText(from, MessageQuotaUnlimited)
+		}
+		return fmt.Sprintf(format, limit)
+	}
+	location := t.User.GetUserLocation(sender)
+	t.sendFormatMessageAndHandleErr(
+		chatID, from, MessageUsageFormat,
+		usage.Requests, formatQuota(float64(quota.RequestsPerDay), "%.0f"),
+		usage.Tokens, formatQuota(float64(quota.TokensPerMonth), "%.0f"),
+		usage.Spend, formatQuota(quota.SpendPerMonth, "$%.2f"),
+		usage.RequestsResetAt.In(location).Format(quotaResetTimeLayout),
+		usage.SpendResetAt.In(location).Format(quotaResetTimeLayout),
+		usage.Total.TotalTokens(), usage.Total.Cost, usage.Credits,
+	)
+	return nil
+}
+
+// reserveQuotaRequest counts the request of the message sender. The sender is told about the exceeded quota and
+// false is returned, if the request can't be answered.
+func (t *TelegramUsecase) reserveQuotaRequest(ctx context.Context, sender model.User, message *api.Message) (
+	bool,
+	error,
+) {
+	chatID := message.Chat.ID
+	from := message.From
+	err := t.Quota.ReserveRequest(ctx, sender)
+	if err == nil {
+		return true, nil
+	}
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return false, fmt.Errorf("failed to reserve request: %w", err)
+	}
+	resetAt := quotaErr.ResetAt.In(t.User.GetUserLocation(sender)).Format(quotaResetTimeLayout)
+	switch quotaErr.Kind {
+	case QuotaRequests:
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageRequestsQuotaExceededFormat, quotaErr.Quota.RequestsPerDay, resetAt,
+		)
+	case QuotaTokens:
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageTokensQuotaExceededFormat, quotaErr.Quota.TokensPerMonth, resetAt,
+		)
+	case QuotaSpend:
+		t.sendFormatMessageAndHandleErr(
+			chatID, from, MessageSpendQuotaExceededFormat, quotaErr.Quota.SpendPerMonth, resetAt,
+		)
+	}
+	return false, nil
+}
+
+// recordQuotaUsage is called when the answer is finished, so it doesn't use the context of the update.
+func (t *TelegramUsecase) recordQuotaUsage(sender model.User, usage model.Usage) {
+	ctx, cancel := context.WithTimeout(context.Background(), HandleUpdateContextTimeout)
+	defer cancel()
+	if err := t.Quota.RecordUsage(ctx, sender, usage); err != nil {
+		fmt.Printf("failed to record usage of user %s: %v\n", sender.UserID, err)
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"github.com/google/uuid"
 	"github.com/iamvkosarev/ai-telegram-bot/config"
 	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/tool"
 	"github.com/iamvkosarev/ai-telegram-bot/pkg/local"
 	"github.com/sourcegraph/conc"
 	"log"
 	"math"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -59,8 +61,8 @@ var (
 		local.NewTrans(local.Rus, "Мне не известна данная команда."),
 	)
 	MessageSelectModel = local.NewSet(
-		"Select model to create new chat.",
-		local.NewTrans(local.Rus, "Выберите модель, чтобы начать новый чат."),
+		"Select persona or model to create new chat.",
+		local.NewTrans(local.Rus, "Выберите персону или модель, чтобы начать новый чат."),
 	)
 	MessageSelectChat = local.NewSet(
 		"Select chat to continue dialog.",
@@ -70,6 +72,10 @@ var (
 		"Started new chat with %s model.",
 		local.NewTrans(local.Rus, "Начат диалог с моделью %s."),
 	)
+	MessageSelectedPersonaFormat = local.NewSet(
+		"Started new chat with %s (%s model).",
+		local.NewTrans(local.Rus, "Начат диалог с %s (модель %s)."),
+	)
 	MessageSelectedChatFormat = local.NewSet(
 		"Continue to chat with %s model.",
 		local.NewTrans(local.Rus, "Диалог с моделью %s продолжается."),
@@ -87,8 +93,8 @@ var (
 		local.NewTrans(local.Rus, "Количество доступных чатов: %v."),
 	)
 	MessageUserChatInfoFormat = local.NewSet(
-		"\n%v) Messages: %v, model: %s, T: %v",
-		local.NewTrans(local.Rus, "\n%v) Сообщение: %v, модель: %s, T: %v"),
+		"\n%v) Messages: %v, model: %s, T: %v, tokens: %v, $%.4f",
+		local.NewTrans(local.Rus, "\n%v) Сообщение: %v, модель: %s, T: %v, токенов: %v, $%.4f"),
 	)
 	MessageSelectChatFormat = local.NewSet(
 		"%s | \"%v\" | messages: %v",
@@ -111,6 +117,10 @@ var (
 		"Select chat to continue",
 		local.NewTrans(local.Rus, "Выбрать чат для продолжения диалога"),
 	)
+	CommandExportInfo = local.NewSet(
+		"Export current chat",
+		local.NewTrans(local.Rus, "Выгрузить текущий чат"),
+	)
 )
 
 const (
@@ -119,52 +129,56 @@ const (
 	CommandNew        = "new"
 	CommandChats      = "chats"
 	CommandSelectChat = "select_chat"
+	CommandExport     = "export"
 
 	CallbackQueryPrefixChat  = "chat_"
 	CallbackQueryPrefixModel = "model_"
+	// CallbackQueryPrefixPersona is followed by the persona name, which has to fit into 64 bytes of
+	// callback data with it. Length of persona names is checked when the config is loaded.
+	CallbackQueryPrefixPersona = "persona_"
 )
 
 var (
 	ErrAIChatNotCreatedYet = errors.New("ai-chat not created yet")
 
 	HandleUpdateContextTimeout = time.Second * 5
+	// HandleLongUpdateContextTimeout is used for messages with files, which have to be downloaded and
+	// processed before the answer, and for commands calling slow APIs.
+	HandleLongUpdateContextTimeout = time.Minute * 3
 )
 
 type TelegramUsecaseDeps struct {
-	User   *UserUsecase
-	AIChat *AiChatUsecase
-	Bot    *api.BotAPI
-	OpenAI *OpenAIUsecase
+	User          *UserUsecase
+	AIChat        *AiChatUsecase
+	Bot           *api.BotAPI
+	OpenAI        *OpenAIUsecase
+	Document      *DocumentUsecase
+	Speech        *SpeechUsecase
+	Image         *ImageUsecase
+	Knowledge     *KnowledgeUsecase
+	Template      *TemplateUsecase
+	Topic         *TopicUsecase
+	Inline        *InlineUsecase
+	Invite        *InviteUsecase
+	AccessRequest *AccessRequestUsecase
+	Quota         *QuotaUsecase
+	Payment       *PaymentUsecase
+	RoleExpiry    *RoleExpiryUsecase
+	RateLimit     *RateLimitUsecase
+	Ban           *BanUsecase
+	Moderation    *ModerationUsecase
+	Broadcast     *BroadcastUsecase
 }
 
 type TelegramUsecase struct {
 	TelegramUsecaseDeps
-	cfg          config.Telegram
-	userRoles    map[int64]model.UserRole
-	allowedUsers map[int64]struct{}
+	cfg        config.Telegram
+	botMention *regexp.Regexp
+	// threadID is the forum topic of the handled update, see inThread.
+	threadID int
 }
 
 func NewTelegramUsecase(cfg config.Telegram, deps TelegramUsecaseDeps) (*TelegramUsecase, error) {
-	prepareUserRoles := make(map[int64]model.UserRole)
-	allowedRoles := make(map[model.UserRole]struct{})
-	allowedUsers := make(map[int64]struct{})
-	for _, role := range cfg.AvailableForRoles {
-		allowedRoles[model.ParseUserRole(role)] = struct{}{}
-	}
-
-	for _, userID := range cfg.AdminTelegramIDList {
-		prepareUserRoles[userID] = model.UserRoleAdmin
-		if _, ok := allowedRoles[model.UserRoleAdmin]; ok {
-			allowedUsers[userID] = struct{}{}
-		}
-	}
-	for _, userID := range cfg.PremiumTelegramIDList {
-		prepareUserRoles[userID] = model.UserRolePremium
-		if _, ok := allowedRoles[model.UserRolePremium]; ok {
-			allowedUsers[userID] = struct{}{}
-		}
-	}
-
 	_, err := deps.Bot.Request(
 		api.NewSetMyCommandsWithScopeAndLanguage(
 			api.NewBotCommandScopeDefault(), string(local.Eng),
@@ -185,6 +199,46 @@ func NewTelegramUsecase(cfg config.Telegram, deps TelegramUsecaseDeps) (*Telegra
 					Command:     CommandSelectChat,
 					Description: CommandSelectChatInfo.Default,
 				},
+				{
+					Command:     CommandExport,
+					Description: CommandExportInfo.Default,
+				},
+				{
+					Command:     CommandVoice,
+					Description: CommandVoiceInfo.Default,
+				},
+				{
+					Command:     CommandImage,
+					Description: CommandImageInfo.Default,
+				},
+				{
+					Command:     CommandTimezone,
+					Description: CommandTimezoneInfo.Default,
+				},
+				{
+					Command:     CommandKnowledge,
+					Description: CommandKnowledgeInfo.Default,
+				},
+				{
+					Command:     CommandTemplates,
+					Description: CommandTemplatesInfo.Default,
+				},
+				{
+					Command:     CommandTemplate,
+					Description: CommandTemplateInfo.Default,
+				},
+				{
+					Command:     CommandTopic,
+					Description: CommandTopicInfo.Default,
+				},
+				{
+					Command:     CommandUsage,
+					Description: CommandUsageInfo.Default,
+				},
+				{
+					Command:     CommandBuy,
+					Description: CommandBuyInfo.Default,
+				},
 			}...,
 		),
 	)
@@ -212,6 +266,46 @@ func NewTelegramUsecase(cfg config.Telegram, deps TelegramUsecaseDeps) (*Telegra
 					Command:     CommandSelectChat,
 					Description: CommandSelectChatInfo.Text(local.Rus),
 				},
+				{
+					Command:     CommandExport,
+					Description: CommandExportInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandVoice,
+					Description: CommandVoiceInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandImage,
+					Description: CommandImageInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandTimezone,
+					Description: CommandTimezoneInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandKnowledge,
+					Description: CommandKnowledgeInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandTemplates,
+					Description: CommandTemplatesInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandTemplate,
+					Description: CommandTemplateInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandTopic,
+					Description: CommandTopicInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandUsage,
+					Description: CommandUsageInfo.Text(local.Rus),
+				},
+				{
+					Command:     CommandBuy,
+					Description: CommandBuyInfo.Text(local.Rus),
+				},
 			}...,
 		),
 	)
@@ -222,35 +316,47 @@ func NewTelegramUsecase(cfg config.Telegram, deps TelegramUsecaseDeps) (*Telegra
 	return &TelegramUsecase{
 		TelegramUsecaseDeps: deps,
 		cfg:                 cfg,
-		userRoles:           prepareUserRoles,
-		allowedUsers:        allowedUsers,
+		botMention:          regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(deps.Bot.Self.UserName) + `\b`),
 	}, nil
 }
 
-func (t *TelegramUsecase) GetUserRole(userID int64) model.UserRole {
-	if userRole, ok := t.userRoles[userID]; ok {
-		return userRole
-	}
-	return model.UserRoleDefault
-}
-
 func (t *TelegramUsecase) Run() error {
 	u := api.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := t.Bot.GetUpdatesChan(u)
 
+	go t.runRoleExpiryScheduler()
+
 	for update := range updates {
 		if update.Message != nil {
-			if err := t.handleMessage(update); err != nil {
-				fmt.Printf("error handling message: %v\n", err.Error())
-			}
+			// Messages are handled concurrently, so a long answer doesn't hold messages of other chats. Answers in
+			// one AI chat don't overlap, see startGeneration.
+			go func(update api.Update) {
+				if err := t.inThread(update.Message).handleMessage(update); err != nil {
+					fmt.Printf("error handling message: %v\n", err.Error())
+				}
+			}(update)
 		}
-		if update.CallbackQuery != nil {
-			if err := t.handleCallbackQuery(update); err != nil {
+		// Callbacks of messages sent in inline mode have no chat to answer in.
+		if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+			if err := t.inThread(update.CallbackQuery.Message).handleCallbackQuery(update); err != nil {
 				fmt.Printf("error handling callback Query: %v\n", err.Error())
 			}
 		}
+		if update.PreCheckoutQuery != nil {
+			if err := t.handlePreCheckoutQuery(update.PreCheckoutQuery); err != nil {
+				fmt.Printf("error handling pre-checkout query: %v\n", err.Error())
+			}
+		}
+		if update.InlineQuery != nil {
+			// Inline queries wait for the user to stop typing, so they don't block other updates.
+			go func(query *api.InlineQuery) {
+				if err := t.handleInlineQuery(query); err != nil {
+					fmt.Printf("error handling inline query: %v\n", err.Error())
+				}
+			}(update.InlineQuery)
+		}
 	}
 	return nil
 }
@@ -261,11 +367,36 @@ func (t *TelegramUsecase) handleCallbackQuery(update api.Update) error {
 
 	data := update.CallbackQuery.Data
 
+	banned, err := t.isBanned(ctx, update.CallbackQuery.From.ID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		if _, err = t.Bot.Request(api.NewCallback(update.CallbackQuery.ID, "")); err != nil {
+			return fmt.Errorf("failed to request callback: %w", err)
+		}
+		return nil
+	}
+
 	switch {
 	case strings.HasPrefix(data, CallbackQueryPrefixModel):
 		return t.handleCallbackSelectModel(ctx, update)
+	case strings.HasPrefix(data, CallbackQueryPrefixPersona):
+		return t.handleCallbackSelectPersona(ctx, update)
 	case strings.HasPrefix(data, CallbackQueryPrefixChat):
 		return t.handleCallbackSelectChat(ctx, update)
+	case strings.HasPrefix(data, CallbackQueryPrefixSpeech):
+		return t.handleCallbackSpeech(ctx, update)
+	case data == CallbackQueryAccessRequest:
+		return t.handleCallbackAccessRequest(ctx, update)
+	case strings.HasPrefix(data, CallbackQueryPrefixAccessApprove),
+		strings.HasPrefix(data, CallbackQueryPrefixAccessDeny):
+		return t.handleCallbackAccessDecision(ctx, update)
+	case strings.HasPrefix(data, CallbackQueryPrefixBuy):
+		return t.handleCallbackBuy(update)
+	case strings.HasPrefix(data, CallbackQueryPrefixBroadcastSend),
+		strings.HasPrefix(data, CallbackQueryPrefixBroadcastCancel):
+		return t.handleCallbackBroadcast(ctx, update)
 	}
 	return nil
 }
@@ -282,7 +413,7 @@ func (t *TelegramUsecase) handleCallbackSelectModel(ctx context.Context, update
 		return fmt.Errorf("failed to request callback: %w", err)
 	}
 
-	user, err := t.User.GetUserInfoForTelegramUser(ctx, chatID)
+	user, err := t.getChatUser(ctx, chatID, from)
 	if err != nil {
 		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 		return fmt.Errorf("failed to get user info for telegram user: %w", err)
@@ -317,6 +448,50 @@ func (t *TelegramUsecase) handleCallbackSelectModel(ctx context.Context, update
 	}
 	return nil
 }
+func (t *TelegramUsecase) handleCallbackSelectPersona(ctx context.Context, update api.Update) error {
+	chatID := update.CallbackQuery.Message.Chat.ID
+	callbackQueryID := update.CallbackQuery.ID
+	data := update.CallbackQuery.Data
+	from := update.CallbackQuery.From
+
+	callback := api.NewCallback(callbackQueryID, "")
+	if _, err := t.Bot.Request(callback); err != nil {
+		return fmt.Errorf("failed to request callback: %w", err)
+	}
+
+	user, err := t.getChatUser(ctx, chatID, from)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to get user info for telegram user: %w", err)
+	}
+	personaName := strings.TrimPrefix(data, CallbackQueryPrefixPersona)
+	aiChat, err := t.AIChat.CreatePersonaChat(ctx, user.UserID, personaName)
+	if err != nil {
+		if errors.Is(err, ErrUserRoleHasNotAccessToPersona) {
+			t.sendMessageAndHandleErr(chatID, from, MessageUserModelNoAccess)
+			return nil
+		}
+		t.sendMessageAndHandleErr(chatID, from, MessageFailedToSaveMessageError)
+		return fmt.Errorf("failed to create new persona AI chat: %w", err)
+	}
+	if err = t.updateCurrentAIChat(ctx, user, chatID, aiChat.ChatID); err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return fmt.Errorf("failed to update user last ai-chat: %w", err)
+	}
+	fmt.Printf(
+		"created new ai chat (ID:%v, persona:%v) for user ID:%v (Telegram:%v)\n", aiChat.ChatID, aiChat.Persona,
+		user.UserID,
+		chatID,
+	)
+	t.sendFormatMessageAndHandleErr(chatID, from, MessageSelectedPersonaFormat, aiChat.Persona, aiChat.Model)
+
+	_, err = t.Bot.Request(api.NewDeleteMessage(chatID, update.CallbackQuery.Message.MessageID))
+	if err != nil {
+		return fmt.Errorf("failed to delete callback query: %w", err)
+	}
+	return nil
+}
+
 func (t *TelegramUsecase) handleCallbackSelectChat(ctx context.Context, update api.Update) error {
 	chatID := update.CallbackQuery.Message.Chat.ID
 	callbackQueryID := update.CallbackQuery.ID
@@ -328,7 +503,7 @@ func (t *TelegramUsecase) handleCallbackSelectChat(ctx context.Context, update a
 		return fmt.Errorf("failed to request callback: %w", err)
 	}
 
-	user, err := t.User.GetUserInfoForTelegramUser(ctx, chatID)
+	user, err := t.getChatUser(ctx, chatID, from)
 	if err != nil {
 		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 		return fmt.Errorf("failed to get user info for telegram user: %w", err)
@@ -351,7 +526,7 @@ func (t *TelegramUsecase) handleCallbackSelectChat(ctx context.Context, update a
 		return nil
 	}
 
-	if err = t.User.UpdateUserLastAIChat(ctx, user.UserID, chatIDToSelect); err != nil {
+	if err = t.updateCurrentAIChat(ctx, user, chatID, chatIDToSelect); err != nil {
 		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 		return fmt.Errorf("failed to update user last AI chat: %w", err)
 	}
@@ -366,24 +541,62 @@ func (t *TelegramUsecase) handleCallbackSelectChat(ctx context.Context, update a
 }
 
 func (t *TelegramUsecase) handleMessage(update api.Update) error {
-	ctx, cancel := context.WithTimeout(context.Background(), HandleUpdateContextTimeout)
+	timeout := HandleUpdateContextTimeout
+	if isLongRunning(update.Message) {
+		timeout = HandleLongUpdateContextTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	chatID := update.Message.Chat.ID
 	from := update.Message.From
 
-	if t.cfg.IsNotPublic {
-		if _, ok := t.allowedUsers[chatID]; !ok {
-			t.sendMessageAndHandleErr(chatID, from, MessageUserNoAccess)
-			return nil
+	// Payments come as service messages, which aren't addressed to the bot in groups.
+	if update.Message.SuccessfulPayment != nil {
+		return t.handleSuccessfulPayment(ctx, update.Message)
+	}
+	if update.Message.RefundedPayment != nil {
+		return t.handleRefundedPayment(ctx, update.Message)
+	}
+
+	if !t.isAddressedToBot(update.Message) {
+		return nil
+	}
+	// Messages of banned users are ignored, in group chats too.
+	if banned, err := t.isBanned(ctx, from.ID); banned || err != nil {
+		return err
+	}
+
+	if update.Message.IsCommand() && update.Message.Command() == CommandBuy {
+		return t.sendProducts(chatID, from)
+	}
+
+	if isInviteStart(update.Message) {
+		if err := t.redeemStartInvite(ctx, update.Message); err != nil {
+			return fmt.Errorf("failed to redeem start invite: %w", err)
 		}
 	}
 
-	user, err := t.User.GetUserInfoForTelegramUser(ctx, chatID)
+	user, err := t.getChatUser(ctx, chatID, from)
 	if err != nil {
 		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 		return fmt.Errorf("failed to get user info for telegram user: %w", err)
 	}
+	// Roles of the chat user are roles of the sender.
+	if !t.User.HasAccess(user) {
+		t.sendNoAccessMessage(update.Message)
+		return nil
+	}
+	if ok, err := t.waitRateLimit(ctx, update.Message, user); !ok {
+		return err
+	}
+	// The message is handled in the whole timeout after it waited for its turn.
+	ctx, cancelHandling := context.WithTimeout(context.Background(), timeout)
+	defer cancelHandling()
+	// The message asked for by /broadcast is previewed instead of being answered.
+	if !update.Message.IsCommand() && isAdmin(user) && t.Broadcast.IsWaitingForDraft(from.ID, chatID) {
+		return t.previewBroadcast(update.Message)
+	}
 
 	if update.Message.IsCommand() {
 		var textSet local.TextSet
@@ -401,6 +614,9 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 			t.sendUsersChats(chatID, from, chats)
 			return nil
 		case CommandNew:
+			if created, err := t.createBoundTopicChat(ctx, user, chatID, from); created || err != nil {
+				return err
+			}
 			if err = t.sendSelectModelsKeyboard(user, chatID, from); err != nil {
 				return fmt.Errorf("failed to send select models keyboard: %w", err)
 			}
@@ -410,6 +626,76 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 				return fmt.Errorf("failed to send select chat keyboard: %w", err)
 			}
 			return nil
+		case CommandExport:
+			if err = t.sendChatExport(ctx, user, chatID, from); err != nil {
+				return fmt.Errorf("failed to send chat export: %w", err)
+			}
+			return nil
+		case CommandVoice:
+			if err = t.handleCommandVoice(ctx, user, chatID, from, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle voice command: %w", err)
+			}
+			return nil
+		case CommandImage:
+			if err = t.handleCommandImage(ctx, user, update.Message, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle image command: %w", err)
+			}
+			return nil
+		case CommandTimezone:
+			if err = t.handleCommandTimezone(ctx, user, chatID, from, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle timezone command: %w", err)
+			}
+			return nil
+		case CommandKnowledge:
+			if err = t.handleCommandKnowledge(ctx, user, update.Message, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle knowledge command: %w", err)
+			}
+			return nil
+		case CommandTopic:
+			if err = t.handleCommandTopic(ctx, user, chatID, from, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle topic command: %w", err)
+			}
+			return nil
+		case CommandGrant, CommandRevoke, CommandWhois:
+			if err = t.handleCommandAdmin(ctx, user, update.Message, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle admin command: %w", err)
+			}
+			return nil
+		case CommandBan, CommandUnban:
+			if err = t.handleCommandBan(ctx, user, update.Message); err != nil {
+				return fmt.Errorf("failed to handle ban command: %w", err)
+			}
+			return nil
+		case CommandBroadcast:
+			if err = t.handleCommandBroadcast(user, update.Message); err != nil {
+				return fmt.Errorf("failed to handle broadcast command: %w", err)
+			}
+			return nil
+		case CommandUsage:
+			if err = t.sendUsage(ctx, user, update.Message); err != nil {
+				return fmt.Errorf("failed to send usage: %w", err)
+			}
+			return nil
+		case CommandPayments, CommandRefund:
+			if err = t.handleCommandPayments(ctx, user, update.Message); err != nil {
+				return fmt.Errorf("failed to handle payments command: %w", err)
+			}
+			return nil
+		case CommandInvite:
+			if err = t.handleCommandInvite(ctx, user, chatID, from, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle invite command: %w", err)
+			}
+			return nil
+		case CommandTemplates:
+			if err = t.handleCommandTemplates(ctx, user, chatID, from, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle templates command: %w", err)
+			}
+			return nil
+		case CommandTemplate:
+			if err = t.handleCommandTemplate(ctx, user, update.Message, update.Message.CommandArguments()); err != nil {
+				return fmt.Errorf("failed to handle template command: %w", err)
+			}
+			return nil
 		default:
 			textSet = MessageCommandUnknown
 		}
@@ -417,6 +703,13 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 		return nil
 	}
 
+	if update.Message.Document != nil && isKnowledgeCaption(update.Message.Caption) {
+		if err = t.addKnowledgeFile(ctx, user, chatID, from, update.Message.Document); err != nil {
+			return fmt.Errorf("failed to add knowledge file: %w", err)
+		}
+		return nil
+	}
+
 	aiChat, err := t.getAIChat(ctx, user, chatID, from)
 	if err != nil {
 		if errors.Is(err, ErrAIChatNotCreatedYet) {
@@ -425,25 +718,134 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 		return fmt.Errorf("failed to get user ai-chat: %w", err)
 	}
 
-	answerChan := make(chan string)
-	throttledAnswerChan := make(chan string)
-	msgText := update.Message.Text
+	sender, ok, err := t.checkChatAccess(ctx, user, aiChat, update.Message)
+	if !ok {
+		return err
+	}
+	// The chat is taken before the document is attached, so a question to the document isn't refused after the
+	// document is saved.
+	finishGeneration, ok := t.startGeneration(aiChat, update.Message)
+	if !ok {
+		return nil
+	}
+	defer finishGeneration()
 
-	if err = t.AIChat.AddMessageToChat(ctx, aiChat.ChatID, msgText, model.MessageSourceUser); err != nil {
+	msgText := t.stripBotMention(update.Message.Text)
+	if update.Message.Document != nil {
+		aiChat, err = t.attachDocument(ctx, aiChat, update.Message)
+		if err != nil {
+			if errors.Is(err, ErrDocumentNotAttached) {
+				return nil
+			}
+			return fmt.Errorf("failed to attach document: %w", err)
+		}
+		msgText = t.stripBotMention(update.Message.Caption)
+		if len(msgText) == 0 {
+			return nil
+		}
+	}
+	if update.Message.Voice != nil || update.Message.Audio != nil {
+		msgText, err = t.transcribeVoice(ctx, sender, update.Message)
+		if err != nil {
+			if errors.Is(err, ErrVoiceNotTranscribed) {
+				return nil
+			}
+			return fmt.Errorf("failed to transcribe voice: %w", err)
+		}
+	}
+
+	return t.answerMessage(ctx, user, sender, aiChat, update.Message, msgText)
+}
+
+// checkChatAccess returns the sender of the message and false, if the sender can't use the model or the persona
+// of the AI chat, e.g. the group chat was set up by a member with another role or the sender's role has expired.
+func (t *TelegramUsecase) checkChatAccess(
+	ctx context.Context,
+	user model.User,
+	aiChat model.AIChat,
+	message *api.Message,
+) (model.User, bool, error) {
+	chatID := message.Chat.ID
+	from := message.From
+	sender, err := t.getSenderUser(ctx, message, user)
+	if err != nil {
+		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
+		return model.User{}, false, fmt.Errorf("failed to get sender user: %w", err)
+	}
+	if !t.AIChat.CanUseChat(sender, aiChat) {
+		t.sendMessageAndHandleErr(chatID, from, MessageUserModelNoAccess)
+		return model.User{}, false, nil
+	}
+	return sender, true, nil
+}
+
+// answerMessage saves msgText of the message to the AI chat and streams the model answer to the Telegram chat.
+// The caller has to check the sender access to the AI chat and start the generation in it first, see
+// checkChatAccess and startGeneration.
+func (t *TelegramUsecase) answerMessage(
+	ctx context.Context,
+	user model.User,
+	sender model.User,
+	aiChat model.AIChat,
+	message *api.Message,
+	msgText string,
+) error {
+	chatID := message.Chat.ID
+	from := message.From
+
+	if ok, err := t.checkPrompt(chatID, from, msgText); !ok {
+		return err
+	}
+	if ok, err := t.reserveQuotaRequest(ctx, sender, message); !ok {
+		return err
+	}
+
+	knowledgeMatches := t.searchKnowledge(user, aiChat, msgText)
+	contextMessages := make([]string, 0, 1)
+	if len(knowledgeMatches) != 0 {
+		contextMessages = append(contextMessages, t.Knowledge.BuildContext(knowledgeMatches))
+	}
+
+	answerChan := make(chan model.AnswerProgress)
+	throttledAnswerChan := make(chan model.AnswerProgress)
+
+	msgText = attributeGroupMessage(message, msgText)
+	err := t.AIChat.AddMessageToChat(ctx, aiChat.ChatID, msgText, model.MessageSourceUser)
+	if err != nil {
 		t.sendMessageAndHandleErr(chatID, from, MessageFailedToSaveMessageError)
 		return fmt.Errorf("failed to add message to ai chat: %w", err)
 	}
 
+	// answerIndex is the index of the saved answer in the AI chat, -1 if the answer isn't saved.
+	answerIndex := -1
+	var answer string
+	var answerMsgID int
 	wg := conc.NewWaitGroup()
 	wg.Go(
 		func() {
+			toolNames := t.AIChat.GetAvailableForChatTools(user, aiChat)
+			toolCtx := tool.WithLocation(context.Background(), t.User.GetUserLocation(user))
+			var usage model.Usage
 			var contextTrimmed bool
-			if contextTrimmed, err = t.OpenAI.SendMessage(msgText, aiChat, answerChan); err != nil {
+			var err error
+			answer, usage, contextTrimmed, err = t.OpenAI.SendMessage(
+				toolCtx, msgText, aiChat, contextMessages, toolNames, answerChan,
+			)
+			t.recordQuotaUsage(sender, usage)
+			if err != nil {
 				t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 				log.Printf("failed to send message to gpt: %v\n", err.Error())
 				return
 			}
 
+			if len(answer) != 0 {
+				index, err := t.AIChat.AddAnswerToChat(context.Background(), aiChat.ChatID, answer, usage)
+				if err != nil {
+					log.Printf("failed to add answer to ai chat: %v\n", err)
+					return
+				}
+				answerIndex = index
+			}
 			if contextTrimmed {
 				t.sendMessageAndHandleErr(chatID, from, MessageContextTrimmed)
 			}
@@ -452,7 +854,7 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 	wg.Go(
 		func() {
 			lastUpdateTime := time.Now()
-			var currentAnswer string
+			var currentAnswer model.AnswerProgress
 			for answer := range answerChan {
 				currentAnswer = answer
 				// Update message every 2.5 seconds to avoid hitting Telegram API limits. In the documentation,
@@ -471,31 +873,28 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 	wg.Go(
 		func() {
 			ctx = context.Background()
-			_, err = t.Bot.Request(api.NewChatAction(chatID, api.ChatTyping))
+			_, err = t.sendChatAction(chatID, api.ChatTyping)
 			if err != nil {
 				log.Printf("failed to send new action to bot: %v\n", err)
 			}
 
-			var answerMsgID int
-			for currentAnswer := range throttledAnswerChan {
-				if len(currentAnswer) == 0 {
+			sources := formatKnowledgeSources(from, knowledgeMatches)
+			for progress := range throttledAnswerChan {
+				if len(progress.Text) == 0 && len(progress.ToolCalls) == 0 {
 					continue
 				}
-				currentAnswer = strings.ReplaceAll(currentAnswer, "**", "*")
-				currentAnswer = strings.ReplaceAll(currentAnswer, "__", "_")
+				progress.Text = strings.ReplaceAll(progress.Text, "**", "*")
+				progress.Text = strings.ReplaceAll(progress.Text, "__", "_")
+				currentAnswer := formatAnswerProgress(progress)
+				if len(progress.Text) != 0 {
+					currentAnswer += sources
+				}
 				if answerMsgID == 0 {
 					var answerMsg api.Message
 					if answerMsg, err = t.sendMessage(chatID, currentAnswer); err != nil {
 						log.Printf("failed to send answer to bot: %v\n", err)
 					}
 					answerMsgID = answerMsg.MessageID
-					if err = t.AIChat.AddMessageToChat(
-						ctx,
-						aiChat.ChatID, currentAnswer, model.MessageSourceAssistant,
-					); err != nil {
-						log.Printf("failed to add answer to ai chat: %v\n", err)
-						return
-					}
 				} else {
 					if _, err = t.sendEditMessage(chatID, answerMsgID, currentAnswer); err != nil {
 						log.Printf("failed to send new edit message to bot: %v\n", err)
@@ -506,9 +905,42 @@ func (t *TelegramUsecase) handleMessage(update api.Update) error {
 	)
 
 	wg.Wait()
+	if answerMsgID != 0 && answerIndex >= 0 {
+		t.finishAnswer(context.Background(), sender, aiChat, chatID, answerMsgID, answer, answerIndex)
+	}
 	return nil
 }
 
+// formatAnswerProgress renders tool calls as short status lines above the answer text.
+func formatAnswerProgress(progress model.AnswerProgress) string {
+	if len(progress.ToolCalls) == 0 {
+		return progress.Text
+	}
+	result := strings.Builder{}
+	for _, toolCall := range progress.ToolCalls {
+		var status string
+		switch toolCall.Status {
+		case model.ToolCallStatusDone:
+			status = "✓"
+		case model.ToolCallStatusFailed:
+			status = "✗"
+		default:
+			status = "…"
+		}
+		result.WriteString(fmt.Sprintf("`🔧 %s %s`\n", toolCall.Name, status))
+	}
+	if len(progress.Text) != 0 {
+		result.WriteString("\n")
+		result.WriteString(progress.Text)
+	}
+	return result.String()
+}
+
+func isLongRunning(message *api.Message) bool {
+	return message.Document != nil || message.Voice != nil || message.Audio != nil ||
+		message.Command() == CommandImage || message.Command() == CommandKnowledge
+}
+
 func (t *TelegramUsecase) sendUsersChats(chatID int64, from *api.User, chats []model.AIChat) {
 	result := strings.Builder{}
 	result.WriteString(getLocalFormatText(from, MessageYouHaveChatsFormat, len(chats)))
@@ -516,6 +948,7 @@ func (t *TelegramUsecase) sendUsersChats(chatID int64, from *api.User, chats []m
 		result.WriteString(
 			getLocalFormatText(
 				from, MessageUserChatInfoFormat, i+1, len(chat.Messages), chat.Model, chat.ModelTemperature,
+				chat.Usage.TotalTokens(), chat.Usage.Cost,
 			),
 		)
 	}
@@ -546,7 +979,8 @@ func (t *TelegramUsecase) getAIChat(ctx context.Context, user model.User, chatID
 
 func (t *TelegramUsecase) sendSelectModelsKeyboard(user model.User, chatID int64, from *api.User) error {
 	aiModelsMap := t.AIChat.GetAvailableForUserModels(user)
-	if len(aiModelsMap) == 0 {
+	personas := t.AIChat.GetAvailableForUserPersonas(user)
+	if len(aiModelsMap) == 0 && len(personas) == 0 {
 		t.sendMessageAndHandleErr(chatID, from, MessageHaveNoAvailableModels)
 		return fmt.Errorf("failed to get user models: %w", ErrUserRoleHasNotAnyAvailableModels)
 	}
@@ -560,6 +994,17 @@ func (t *TelegramUsecase) sendSelectModelsKeyboard(user model.User, chatID int64
 	msg.ParseMode = api.ModeMarkdown
 	const maxButtonsInRow = 2
 	inlineRows := make([][]api.InlineKeyboardButton, 0)
+	// Personas go first, one in a row, so their descriptions fit into buttons.
+	for _, persona := range personas {
+		buttonText := persona.Name
+		if len(persona.Description) != 0 {
+			buttonText = fmt.Sprintf("%s - %s", persona.Name, persona.Description)
+		}
+		personaWithPrefix := fmt.Sprintf("%s%s", CallbackQueryPrefixPersona, persona.Name)
+		inlineRows = append(
+			inlineRows, api.NewInlineKeyboardRow(api.NewInlineKeyboardButtonData(buttonText, personaWithPrefix)),
+		)
+	}
 	inlineButtons := make([]api.InlineKeyboardButton, 0)
 	for _, aiModel := range aiModels {
 		if len(inlineButtons) >= maxButtonsInRow {
@@ -570,9 +1015,11 @@ func (t *TelegramUsecase) sendSelectModelsKeyboard(user model.User, chatID int64
 		modelWithPrefix := fmt.Sprintf("%s%s", CallbackQueryPrefixModel, aiModel)
 		inlineButtons = append(inlineButtons, api.NewInlineKeyboardButtonData(aiModel, modelWithPrefix))
 	}
-	inlineRows = append(inlineRows, inlineButtons)
+	if len(inlineButtons) != 0 {
+		inlineRows = append(inlineRows, inlineButtons)
+	}
 	msg.ReplyMarkup = api.NewInlineKeyboardMarkup(inlineRows...)
-	if _, err := t.Bot.Send(msg); err != nil {
+	if _, err := t.sendToBot(msg); err != nil {
 		return fmt.Errorf("failed to send message to bot: %w", err)
 	}
 	return nil
@@ -607,11 +1054,11 @@ func (t *TelegramUsecase) sendSelectChatKeyboard(
 			lastMessage := chat.Messages[messagesCount-1].Body
 			length := math.Min(float64(maxMessageViewLength), float64(len([]rune(lastMessage))))
 			buttonText = getLocalFormatText(
-				from, MessageSelectChatFormat, chat.Model, string(([]rune(lastMessage))[:int(length)]),
+				from, MessageSelectChatFormat, getChatTitle(chat), string(([]rune(lastMessage))[:int(length)]),
 				messagesCount,
 			)
 		} else {
-			buttonText = getLocalFormatText(from, MessageSelectChatFormat, chat.Model, "...", 0)
+			buttonText = getLocalFormatText(from, MessageSelectChatFormat, getChatTitle(chat), "...", 0)
 		}
 
 		chatWithPrefix := fmt.Sprintf("%s%s", CallbackQueryPrefixChat, chat.ChatID.String())
@@ -619,12 +1066,20 @@ func (t *TelegramUsecase) sendSelectChatKeyboard(
 		inlineRows = append(inlineRows, inlineButtons)
 	}
 	msg.ReplyMarkup = api.NewInlineKeyboardMarkup(inlineRows...)
-	if _, err := t.Bot.Send(msg); err != nil {
+	if _, err := t.sendToBot(msg); err != nil {
 		return fmt.Errorf("failed to send message to bot: %w", err)
 	}
 	return nil
 }
 
+// getChatTitle returns the persona name for persona chats and the model name otherwise.
+func getChatTitle(chat model.AIChat) string {
+	if len(chat.Persona) != 0 {
+		return chat.Persona
+	}
+	return chat.Model
+}
+
 func (t *TelegramUsecase) createNewAIChat(
 	ctx context.Context,
 	user model.User,
@@ -637,7 +1092,7 @@ func (t *TelegramUsecase) createNewAIChat(
 		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 		return model.AIChat{}, fmt.Errorf("failed to create user ai-chat: %w", err)
 	}
-	if err = t.User.UpdateUserLastAIChat(ctx, user.UserID, aiChat.ChatID); err != nil {
+	if err = t.updateCurrentAIChat(ctx, user, chatID, aiChat.ChatID); err != nil {
 		t.sendMessageAndHandleErr(chatID, from, MessageServerError)
 		return model.AIChat{}, fmt.Errorf("failed to update user last ai-chat: %w", err)
 	}
@@ -693,6 +1148,9 @@ func getLocalText(user *api.User, textSet local.TextSet) string {
 
 func getLocalFormatText(user *api.User, textSet local.TextSet, a ...any) string {
 	text := textSet.DefaultFormat(a...)
+	if user == nil {
+		return text
+	}
 	switch user.LanguageCode {
 	case "ru":
 		text = textSet.Format(local.Rus, a...)
@@ -714,5 +1172,5 @@ func (t *TelegramUsecase) sendEditMessage(chatID int64, previousMsgID int, messa
 }
 
 func (t *TelegramUsecase) sendToBot(c api.Chattable) (api.Message, error) {
-	return t.Bot.Send(c)
+	return t.Bot.Send(t.withThread(c))
 }
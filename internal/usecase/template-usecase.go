@@ -0,0 +1,211 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidTemplateName = errors.New("invalid template name")
+	ErrTemplateNameTaken   = errors.New("template name is taken by a shared template")
+	ErrTooManyTemplates    = errors.New("too many templates")
+	ErrEmptyTemplate       = errors.New("template text is empty")
+)
+
+var (
+	templateNameRegexp        = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+	templatePlaceholderRegexp = regexp.MustCompile(`{{\s*([a-zA-Z0-9_-]+)\s*}}`)
+)
+
+type TemplateStorage interface {
+	SaveTemplate(ctx context.Context, userID uuid.UUID, name, text string) error
+	ListTemplates(ctx context.Context, userID uuid.UUID) ([]model.PromptTemplate, error)
+	DeleteTemplate(ctx context.Context, userID uuid.UUID, name string) error
+}
+
+// MissingTemplateArgsError is returned when there are fewer arguments than template placeholders.
+type MissingTemplateArgsError struct {
+	Placeholders []string
+}
+
+func (e *MissingTemplateArgsError) Error() string {
+	return fmt.Sprintf("template expects arguments: %s", strings.Join(e.Placeholders, ", "))
+}
+
+type TemplateUsecaseDeps struct {
+	TemplateStorage TemplateStorage
+}
+
+type TemplateUsecase struct {
+	TemplateUsecaseDeps
+	cfg              config.Templates
+	shared           []model.PromptTemplate
+	roleMaxTemplates map[model.UserRole]int
+}
+
+// NewTemplateUsecase lowercases names of shared templates, as template names are looked up in lowercase. An
+// error is returned if a shared template name is invalid or repeated.
+func NewTemplateUsecase(deps TemplateUsecaseDeps, cfg config.Templates, roles []config.Role) (
+	*TemplateUsecase,
+	error,
+) {
+	shared := make([]model.PromptTemplate, 0, len(cfg.Shared))
+	for _, template := range cfg.Shared {
+		name := strings.ToLower(template.Name)
+		if !templateNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("%w: shared template %q", ErrInvalidTemplateName, template.Name)
+		}
+		isRepeated := slices.ContainsFunc(
+			shared, func(sharedTemplate model.PromptTemplate) bool {
+				return sharedTemplate.Name == name
+			},
+		)
+		if isRepeated {
+			return nil, fmt.Errorf("shared template %s is declared twice", name)
+		}
+		shared = append(shared, model.PromptTemplate{Name: name, Text: template.Text, Shared: true})
+	}
+	roleMaxTemplates := make(map[model.UserRole]int)
+	for _, role := range roles {
+		roleMaxTemplates[model.ParseUserRole(role.Role)] = role.Limits.MaxTemplates
+	}
+	return &TemplateUsecase{
+		TemplateUsecaseDeps: deps,
+		cfg:                 cfg,
+		shared:              shared,
+		roleMaxTemplates:    roleMaxTemplates,
+	}, nil
+}
+
+func (t *TemplateUsecase) MaxTemplates(user model.User) int {
+	return getRoleLimit(user, t.roleMaxTemplates, t.cfg.MaxTemplates)
+}
+
+// ListTemplates returns shared templates followed by the user's own ones.
+func (t *TemplateUsecase) ListTemplates(ctx context.Context, userID uuid.UUID) ([]model.PromptTemplate, error) {
+	templates, err := t.TemplateStorage.ListTemplates(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	return append(slices.Clone(t.shared), templates...), nil
+}
+
+func (t *TemplateUsecase) GetTemplate(ctx context.Context, userID uuid.UUID, name string) (
+	model.PromptTemplate,
+	error,
+) {
+	templates, err := t.ListTemplates(ctx, userID)
+	if err != nil {
+		return model.PromptTemplate{}, err
+	}
+	for _, template := range templates {
+		if template.Name == name {
+			return template, nil
+		}
+	}
+	return model.PromptTemplate{}, model.ErrPromptTemplateDoesNotExist
+}
+
+func (t *TemplateUsecase) SaveTemplate(ctx context.Context, user model.User, name, text string) error {
+	name = strings.ToLower(name)
+	if !templateNameRegexp.MatchString(name) {
+		return ErrInvalidTemplateName
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		return ErrEmptyTemplate
+	}
+	if t.isShared(name) {
+		return ErrTemplateNameTaken
+	}
+	templates, err := t.TemplateStorage.ListTemplates(ctx, user.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to list templates: %w", err)
+	}
+	exists := slices.ContainsFunc(
+		templates, func(template model.PromptTemplate) bool {
+			return template.Name == name
+		},
+	)
+	if !exists && len(templates) >= t.MaxTemplates(user) {
+		return ErrTooManyTemplates
+	}
+	return t.TemplateStorage.SaveTemplate(ctx, user.UserID, name, text)
+}
+
+func (t *TemplateUsecase) DeleteTemplate(ctx context.Context, userID uuid.UUID, name string) error {
+	name = strings.ToLower(name)
+	if t.isShared(name) {
+		return ErrTemplateNameTaken
+	}
+	return t.TemplateStorage.DeleteTemplate(ctx, userID, name)
+}
+
+// ExpandTemplate replaces {{placeholders}} with the arguments. Every placeholder but the last one takes
+// one word of args, the last one takes the rest, so "translate {{language}}: {{text}}" is used as
+// "/t translate English some long text". Repeated placeholders get the same value. Arguments of a template
+// without placeholders are appended to its text.
+func ExpandTemplate(template model.PromptTemplate, args string) (string, error) {
+	placeholders := TemplatePlaceholders(template)
+	rest := strings.TrimSpace(args)
+	if len(placeholders) == 0 {
+		if len(rest) == 0 {
+			return template.Text, nil
+		}
+		return template.Text + "\n\n" + rest, nil
+	}
+
+	values := make(map[string]string, len(placeholders))
+	for i, placeholder := range placeholders {
+		if len(rest) == 0 {
+			return "", &MissingTemplateArgsError{Placeholders: placeholders}
+		}
+		if i == len(placeholders)-1 {
+			values[placeholder] = rest
+			break
+		}
+		values[placeholder], rest = cutWord(rest)
+	}
+
+	return templatePlaceholderRegexp.ReplaceAllStringFunc(
+		template.Text, func(match string) string {
+			name := templatePlaceholderRegexp.FindStringSubmatch(match)[1]
+			return values[name]
+		},
+	), nil
+}
+
+// TemplatePlaceholders returns unique placeholder names in the order of their first appearance.
+func TemplatePlaceholders(template model.PromptTemplate) []string {
+	placeholders := make([]string, 0)
+	for _, match := range templatePlaceholderRegexp.FindAllStringSubmatch(template.Text, -1) {
+		if !slices.Contains(placeholders, match[1]) {
+			placeholders = append(placeholders, match[1])
+		}
+	}
+	return placeholders
+}
+
+func (t *TemplateUsecase) isShared(name string) bool {
+	return slices.ContainsFunc(
+		t.shared, func(template model.PromptTemplate) bool {
+			return template.Name == name
+		},
+	)
+}
+
+// cutWord returns the first word of s and the rest of it. Line breaks of the rest are kept.
+func cutWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	index := strings.IndexAny(s, " \t\n")
+	if index < 0 {
+		return s, ""
+	}
+	return s[:index], strings.TrimSpace(s[index:])
+}
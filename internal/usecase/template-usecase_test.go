@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"testing"
+)
+
+func TestNewTemplateUsecaseSharedNames(t *testing.T) {
+	templates, err := NewTemplateUsecase(
+		TemplateUsecaseDeps{}, config.Templates{
+			Shared: []config.PromptTemplate{{Name: "ReviewGo", Text: "Review {{code}}"}},
+		}, nil,
+	)
+	if err != nil {
+		t.Fatalf("NewTemplateUsecase() error = %v", err)
+	}
+	if !templates.isShared("reviewgo") {
+		t.Errorf("shared template name isn't lowercased")
+	}
+
+	tests := []struct {
+		name   string
+		shared []config.PromptTemplate
+	}{
+		{
+			name:   "invalid name",
+			shared: []config.PromptTemplate{{Name: "review go", Text: "Review {{code}}"}},
+		},
+		{
+			name: "repeated name",
+			shared: []config.PromptTemplate{
+				{Name: "review", Text: "Review {{code}}"},
+				{Name: "Review", Text: "Review {{code}}"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				_, err := NewTemplateUsecase(TemplateUsecaseDeps{}, config.Templates{Shared: test.shared}, nil)
+				if err == nil {
+					t.Fatalf("NewTemplateUsecase() error = nil")
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"sync"
+)
+
+type TopicStorage interface {
+	GetTopic(ctx context.Context, chatID int64, threadID int) (model.Topic, error)
+	UpdateTopicAIChat(ctx context.Context, chatID int64, threadID int, aiChatID uuid.UUID) error
+	UpdateTopicBinding(ctx context.Context, chatID int64, threadID int, aiModel string, persona string) error
+}
+
+type TopicUsecaseDeps struct {
+	TopicStorage TopicStorage
+	AIChat       *AiChatUsecase
+}
+
+type TopicUsecase struct {
+	TopicUsecaseDeps
+	// createMu makes concurrent first messages of a bound topic share one created chat.
+	createMu sync.Mutex
+}
+
+func NewTopicUsecase(deps TopicUsecaseDeps) *TopicUsecase {
+	return &TopicUsecase{
+		TopicUsecaseDeps: deps,
+	}
+}
+
+func (t *TopicUsecase) GetTopic(ctx context.Context, chatID int64, threadID int) (model.Topic, error) {
+	return t.TopicStorage.GetTopic(ctx, chatID, threadID)
+}
+
+func (t *TopicUsecase) UpdateTopicAIChat(ctx context.Context, chatID int64, threadID int, aiChatID uuid.UUID) error {
+	return t.TopicStorage.UpdateTopicAIChat(ctx, chatID, threadID, aiChatID)
+}
+
+// GetTopicWithChat returns the topic, a chat owned by ownerID is created for the bound topic without one.
+func (t *TopicUsecase) GetTopicWithChat(ctx context.Context, ownerID uuid.UUID, chatID int64, threadID int) (
+	model.Topic,
+	error,
+) {
+	topic, err := t.TopicStorage.GetTopic(ctx, chatID, threadID)
+	if err != nil || topic.AIChatID != uuid.Nil || !topic.IsBound() {
+		return topic, err
+	}
+	t.createMu.Lock()
+	defer t.createMu.Unlock()
+	// The chat could be created by another message while waiting.
+	topic, err = t.TopicStorage.GetTopic(ctx, chatID, threadID)
+	if err != nil || topic.AIChatID != uuid.Nil || !topic.IsBound() {
+		return topic, err
+	}
+	aiChat, err := t.CreateTopicChat(ctx, ownerID, topic)
+	if err != nil {
+		return model.Topic{}, err
+	}
+	topic.AIChatID = aiChat.ChatID
+	return topic, nil
+}
+
+// BindTopic binds the topic to the persona or the model with the given name, available to the user, and
+// starts a new chat with it in the topic.
+func (t *TopicUsecase) BindTopic(ctx context.Context, user model.User, topic model.Topic, name string) (
+	model.AIChat,
+	error,
+) {
+	var aiModel, persona string
+	if p, ok := t.AIChat.GetPersona(user, name); ok {
+		persona = p.Name
+	} else if _, ok = t.AIChat.GetAvailableForUserModels(user)[name]; ok {
+		aiModel = name
+	} else {
+		return model.AIChat{}, ErrUserRoleHasNotAccessToModel
+	}
+
+	if err := t.TopicStorage.UpdateTopicBinding(ctx, topic.ChatID, topic.ThreadID, aiModel, persona); err != nil {
+		return model.AIChat{}, fmt.Errorf("failed to update topic binding: %w", err)
+	}
+	topic.Model, topic.Persona = aiModel, persona
+	return t.CreateTopicChat(ctx, user.UserID, topic)
+}
+
+func (t *TopicUsecase) ResetTopicBinding(ctx context.Context, topic model.Topic) error {
+	return t.TopicStorage.UpdateTopicBinding(ctx, topic.ChatID, topic.ThreadID, "", "")
+}
+
+// CreateTopicChat creates a chat with the topic binding and makes it the current chat of the topic.
+func (t *TopicUsecase) CreateTopicChat(ctx context.Context, ownerID uuid.UUID, topic model.Topic) (
+	model.AIChat,
+	error,
+) {
+	aiChat, err := t.AIChat.CreateBoundChat(ctx, ownerID, topic.Model, topic.Persona)
+	if err != nil {
+		return model.AIChat{}, fmt.Errorf("failed to create topic chat: %w", err)
+	}
+	if err = t.TopicStorage.UpdateTopicAIChat(ctx, topic.ChatID, topic.ThreadID, aiChat.ChatID); err != nil {
+		return model.AIChat{}, fmt.Errorf("failed to update topic ai chat: %w", err)
+	}
+	return aiChat, nil
+}
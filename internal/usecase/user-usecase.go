@@ -7,13 +7,40 @@ import (
 	"github.com/google/uuid"
 	"github.com/iamvkosarev/ai-telegram-bot/config"
 	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownTimezone    = errors.New("unknown timezone")
+	ErrUnknownRole        = errors.New("unknown role")
+	ErrRoleIsConfigured   = errors.New("role is configured by environment")
+	ErrUserAlreadyHasRole = errors.New("user already has role")
+	ErrUserHasNoRole      = errors.New("user has no role")
+
+	errRoleChanged = errors.New("role is changed")
 )
 
 type UserStorage interface {
 	GetUserIDForTelegramUser(ctx context.Context, userTelegramID int64) (uuid.UUID, error)
 	CreateNewTelegramUser(ctx context.Context, userTelegramID int64, roles []model.UserRole) (uuid.UUID, error)
+	GetUserIDForTelegramChat(ctx context.Context, chatTelegramID int64) (uuid.UUID, error)
+	CreateNewTelegramChat(ctx context.Context, chatTelegramID int64) (uuid.UUID, error)
 	GetUserInfo(ctx context.Context, userID uuid.UUID) (model.User, error)
 	UpdateUserLastAIChat(ctx context.Context, userID uuid.UUID, aiChatID uuid.UUID) error
+	UpdateUserTimezone(ctx context.Context, userID uuid.UUID, timezone string) error
+	UpdateUserRoles(
+		ctx context.Context,
+		userID uuid.UUID,
+		update func(roles []model.UserRole, roleExpiresAt map[model.UserRole]time.Time) (
+			[]model.UserRole,
+			map[model.UserRole]time.Time,
+			error,
+		),
+	) error
+	GetConfiguredRoles(ctx context.Context) (map[int64][]model.UserRole, error)
+	SetConfiguredRoles(ctx context.Context, configuredRoles map[int64][]model.UserRole) error
 }
 
 type UserUsecaseDeps struct {
@@ -23,12 +50,19 @@ type UserUsecaseDeps struct {
 type UserUsecase struct {
 	UserUsecaseDeps
 	telegramCfg config.Telegram
+	// roles are declared roles in config order.
+	roles []model.UserRole
 }
 
-func NewUserUsecase(deps UserUsecaseDeps, telegramCfg config.Telegram) *UserUsecase {
+func NewUserUsecase(deps UserUsecaseDeps, telegramCfg config.Telegram, roles []config.Role) *UserUsecase {
+	declaredRoles := make([]model.UserRole, 0, len(roles))
+	for _, role := range roles {
+		declaredRoles = append(declaredRoles, model.ParseUserRole(role.Role))
+	}
 	return &UserUsecase{
 		UserUsecaseDeps: deps,
 		telegramCfg:     telegramCfg,
+		roles:           declaredRoles,
 	}
 }
 
@@ -46,14 +80,60 @@ func (u *UserUsecase) GetUserInfoForTelegramUser(ctx context.Context, userTelegr
 			return model.User{}, fmt.Errorf("failed to get telegram user: %w", err)
 		}
 	}
-	return u.UserStorage.GetUserInfo(ctx, userID)
+	return u.GetUserInfo(ctx, userID)
+}
+
+// GetUserInfoForTelegramChat returns the user owning AI chats of the Telegram chat. Private chats are owned
+// by the sender, group chats are owned by a separate user of the chat, so all members share its chats.
+// Roles of the group user are taken from the sender, so the sender's model access applies.
+func (u *UserUsecase) GetUserInfoForTelegramChat(ctx context.Context, chatID, senderTelegramID int64) (
+	model.User,
+	error,
+) {
+	sender, err := u.GetUserInfoForTelegramUser(ctx, senderTelegramID)
+	if err != nil || chatID == senderTelegramID {
+		return sender, err
+	}
+	chatUserID, err := u.UserStorage.GetUserIDForTelegramChat(ctx, chatID)
+	if err != nil {
+		if !errors.Is(err, model.ErrTelegramChatDoesNotExist) {
+			return model.User{}, fmt.Errorf("failed to get telegram chat: %w", err)
+		}
+		if chatUserID, err = u.UserStorage.CreateNewTelegramChat(ctx, chatID); err != nil {
+			return model.User{}, fmt.Errorf("failed to create telegram chat: %w", err)
+		}
+	}
+	group, err := u.GetUserInfo(ctx, chatUserID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get group user: %w", err)
+	}
+	group.Roles = sender.Roles
+	return group, nil
+}
+
+// FindTelegramUser returns the Telegram user without creating it, model.ErrTelegramUserDoesNotExists is returned
+// for users who haven't used the bot.
+func (u *UserUsecase) FindTelegramUser(ctx context.Context, userTelegramID int64) (model.User, error) {
+	userID, err := u.UserStorage.GetUserIDForTelegramUser(ctx, userTelegramID)
+	if err != nil {
+		return model.User{}, err
+	}
+	return u.GetUserInfo(ctx, userID)
 }
 
+// GetUserInfo returns the user without expired roles. Their expiry is kept until the roles are revoked.
 func (u *UserUsecase) GetUserInfo(ctx context.Context, userID uuid.UUID) (model.User, error) {
 	user, err := u.UserStorage.GetUserInfo(ctx, userID)
 	if err != nil {
 		return model.User{}, err
 	}
+	now := time.Now()
+	user.Roles = slices.DeleteFunc(
+		user.Roles, func(role model.UserRole) bool {
+			expiresAt, ok := user.RoleExpiresAt[role]
+			return ok && !now.Before(expiresAt)
+		},
+	)
 	return user, nil
 }
 
@@ -61,6 +141,292 @@ func (u *UserUsecase) UpdateUserLastAIChat(ctx context.Context, userID, aiChatID
 	return u.UserStorage.UpdateUserLastAIChat(ctx, userID, aiChatID)
 }
 
+func (u *UserUsecase) UpdateUserTimezone(ctx context.Context, userID uuid.UUID, timezone string) error {
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownTimezone, timezone)
+	}
+	return u.UserStorage.UpdateUserTimezone(ctx, userID, timezone)
+}
+
+// HasAccess reports whether the user can use the bot. Private bots are available only for users with roles from
+// the available_for_roles config.
+func (u *UserUsecase) HasAccess(user model.User) bool {
+	if !u.telegramCfg.IsNotPublic {
+		return true
+	}
+	for _, role := range u.telegramCfg.AvailableForRoles {
+		if slices.Contains(user.Roles, model.ParseUserRole(role)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGrantableRole returns the declared role which can be granted by admins. The default role is always
+// given, so it can't be granted.
+func (u *UserUsecase) ParseGrantableRole(name string) (model.UserRole, error) {
+	for _, role := range u.GrantableRoles() {
+		if strings.EqualFold(role.String(), strings.TrimSpace(name)) {
+			return role, nil
+		}
+	}
+	return model.UserRoleDefault, fmt.Errorf("%w: %s", ErrUnknownRole, name)
+}
+
+// GrantableRoles returns declared roles except the default one in config order.
+func (u *UserUsecase) GrantableRoles() []model.UserRole {
+	roles := make([]model.UserRole, 0, len(u.roles))
+	for _, role := range u.roles {
+		if role != model.UserRoleDefault {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// GrantRole adds the role to the Telegram user, the user is created if the user hasn't used the bot yet. The
+// role given for a time becomes permanent.
+func (u *UserUsecase) GrantRole(ctx context.Context, userTelegramID int64, role model.UserRole) (model.User, error) {
+	user, err := u.GetUserInfoForTelegramUser(ctx, userTelegramID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	err = u.updateRoles(
+		ctx, &user, func(user *model.User) error {
+			_, hasExpiry := user.RoleExpiresAt[role]
+			if slices.Contains(user.Roles, role) && !hasExpiry {
+				return ErrUserAlreadyHasRole
+			}
+			if !slices.Contains(user.Roles, role) {
+				user.Roles = append(user.Roles, role)
+			}
+			delete(user.RoleExpiresAt, role)
+			return nil
+		},
+	)
+	if errors.Is(err, ErrUserAlreadyHasRole) {
+		return user, err
+	}
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to update user roles: %w", err)
+	}
+	return user, nil
+}
+
+// ExtendRole gives the role to the Telegram user for the duration. If the user has the role for a time, the
+// duration is added to its expiry. ErrUserAlreadyHasRole is returned if the user has the role permanently.
+func (u *UserUsecase) ExtendRole(
+	ctx context.Context,
+	userTelegramID int64,
+	role model.UserRole,
+	duration time.Duration,
+) (time.Time, error) {
+	user, err := u.GetUserInfoForTelegramUser(ctx, userTelegramID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	var expiresAt time.Time
+	err = u.updateRoles(
+		ctx, &user, func(user *model.User) error {
+			var hasExpiry bool
+			expiresAt, hasExpiry = user.RoleExpiresAt[role]
+			if slices.Contains(user.Roles, role) && !hasExpiry {
+				return ErrUserAlreadyHasRole
+			}
+			if now := time.Now(); expiresAt.Before(now) {
+				expiresAt = now
+			}
+			expiresAt = expiresAt.Add(duration)
+
+			if !slices.Contains(user.Roles, role) {
+				user.Roles = append(user.Roles, role)
+			}
+			if user.RoleExpiresAt == nil {
+				user.RoleExpiresAt = make(map[model.UserRole]time.Time)
+			}
+			user.RoleExpiresAt[role] = expiresAt
+			return nil
+		},
+	)
+	if errors.Is(err, ErrUserAlreadyHasRole) {
+		return time.Time{}, err
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to update user roles: %w", err)
+	}
+	return expiresAt, nil
+}
+
+// ExpireRole removes the role of the user which expires at the time. It returns false if the role was extended or
+// removed since then.
+func (u *UserUsecase) ExpireRole(
+	ctx context.Context,
+	userID uuid.UUID,
+	role model.UserRole,
+	expiresAt time.Time,
+) (bool, error) {
+	user, err := u.UserStorage.GetUserInfo(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+	err = u.updateRoles(
+		ctx, &user, func(user *model.User) error {
+			currentExpiresAt, hasExpiry := user.RoleExpiresAt[role]
+			if !hasExpiry || !currentExpiresAt.Equal(expiresAt) {
+				return errRoleChanged
+			}
+			delete(user.RoleExpiresAt, role)
+			user.Roles = slices.DeleteFunc(
+				user.Roles, func(userRole model.UserRole) bool {
+					return userRole == role
+				},
+			)
+			return nil
+		},
+	)
+	if errors.Is(err, errRoleChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update user roles: %w", err)
+	}
+	return true, nil
+}
+
+// ShortenRole takes the duration back from the role given for a time, the role is removed when nothing is left.
+// Permanent roles aren't changed.
+func (u *UserUsecase) ShortenRole(
+	ctx context.Context,
+	userTelegramID int64,
+	role model.UserRole,
+	duration time.Duration,
+) error {
+	user, err := u.GetUserInfoForTelegramUser(ctx, userTelegramID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	err = u.updateRoles(
+		ctx, &user, func(user *model.User) error {
+			expiresAt, hasExpiry := user.RoleExpiresAt[role]
+			if !hasExpiry {
+				return errRoleChanged
+			}
+			expiresAt = expiresAt.Add(-duration)
+			if expiresAt.After(time.Now()) {
+				user.RoleExpiresAt[role] = expiresAt
+			} else {
+				delete(user.RoleExpiresAt, role)
+				user.Roles = slices.DeleteFunc(
+					user.Roles, func(userRole model.UserRole) bool {
+						return userRole == role
+					},
+				)
+			}
+			return nil
+		},
+	)
+	if err != nil && !errors.Is(err, errRoleChanged) {
+		return fmt.Errorf("failed to update user roles: %w", err)
+	}
+	return nil
+}
+
+// RevokeRole removes the role from the Telegram user. Roles given by ADMIN_TELEGRAM_ID_LIST and
+// PREMIUM_TELEGRAM_ID_LIST can't be revoked, they are granted again on start.
+func (u *UserUsecase) RevokeRole(ctx context.Context, userTelegramID int64, role model.UserRole) (model.User, error) {
+	if slices.Contains(u.getTelegramUserRoles(userTelegramID), role) {
+		return model.User{}, ErrRoleIsConfigured
+	}
+	user, err := u.GetUserInfoForTelegramUser(ctx, userTelegramID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	err = u.updateRoles(
+		ctx, &user, func(user *model.User) error {
+			if !slices.Contains(user.Roles, role) {
+				return ErrUserHasNoRole
+			}
+			user.Roles = slices.DeleteFunc(
+				user.Roles, func(userRole model.UserRole) bool {
+					return userRole == role
+				},
+			)
+			delete(user.RoleExpiresAt, role)
+			return nil
+		},
+	)
+	if errors.Is(err, ErrUserHasNoRole) {
+		return user, err
+	}
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to update user roles: %w", err)
+	}
+	return user, nil
+}
+
+// ReconcileConfiguredRoles grants roles from ADMIN_TELEGRAM_ID_LIST and PREMIUM_TELEGRAM_ID_LIST to users
+// saved before they were added to the lists, and revokes roles given by the lists on the previous start from
+// users removed from them. Roles given by the lists are saved to be compared on the next start.
+func (u *UserUsecase) ReconcileConfiguredRoles(ctx context.Context) error {
+	previousRoles, err := u.UserStorage.GetConfiguredRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get configured roles: %w", err)
+	}
+	configuredRoles := make(map[int64][]model.UserRole)
+	for _, userTelegramID := range slices.Concat(u.telegramCfg.AdminTelegramIDList, u.telegramCfg.PremiumTelegramIDList) {
+		for _, role := range u.getTelegramUserRoles(userTelegramID) {
+			if _, err = u.GrantRole(ctx, userTelegramID, role); err != nil && !errors.Is(err, ErrUserAlreadyHasRole) {
+				return fmt.Errorf("failed to grant %s role to %v: %w", role, userTelegramID, err)
+			}
+			if role != model.UserRoleDefault && !slices.Contains(configuredRoles[userTelegramID], role) {
+				configuredRoles[userTelegramID] = append(configuredRoles[userTelegramID], role)
+			}
+		}
+	}
+	for userTelegramID, roles := range previousRoles {
+		for _, role := range roles {
+			if slices.Contains(configuredRoles[userTelegramID], role) {
+				continue
+			}
+			if err = u.revokeConfiguredRole(ctx, userTelegramID, role); err != nil {
+				return fmt.Errorf("failed to revoke %s role from %v: %w", role, userTelegramID, err)
+			}
+		}
+	}
+	if err = u.UserStorage.SetConfiguredRoles(ctx, configuredRoles); err != nil {
+		return fmt.Errorf("failed to save configured roles: %w", err)
+	}
+	return nil
+}
+
+// revokeConfiguredRole takes the role given by the environment lists from the user removed from them. Users who
+// are no longer saved or don't have the role are skipped.
+func (u *UserUsecase) revokeConfiguredRole(ctx context.Context, userTelegramID int64, role model.UserRole) error {
+	if _, err := u.FindTelegramUser(ctx, userTelegramID); err != nil {
+		if errors.Is(err, model.ErrTelegramUserDoesNotExists) {
+			return nil
+		}
+		return err
+	}
+	if _, err := u.RevokeRole(ctx, userTelegramID, role); err != nil && !errors.Is(err, ErrUserHasNoRole) {
+		return err
+	}
+	return nil
+}
+
+// GetUserLocation returns the user time location, users without timezone get the default one.
+func (u *UserUsecase) GetUserLocation(user model.User) *time.Location {
+	for _, timezone := range []string{user.Timezone, u.telegramCfg.DefaultTimezone} {
+		if len(timezone) == 0 {
+			continue
+		}
+		if location, err := time.LoadLocation(timezone); err == nil {
+			return location
+		}
+	}
+	return time.UTC
+}
+
 func (u *UserUsecase) getTelegramUserRoles(userTelegramID int64) []model.UserRole {
 	roles := []model.UserRole{
 		model.UserRoleDefault,
@@ -79,3 +445,35 @@ func (u *UserUsecase) getTelegramUserRoles(userTelegramID int64) []model.UserRol
 	}
 	return roles
 }
+
+// updateRoles saves roles of the user changed by the update of the saved roles, the user gets them. The update is
+// run again if the user is changed concurrently, so roles given or taken at the same time aren't lost.
+func (u *UserUsecase) updateRoles(ctx context.Context, user *model.User, update func(user *model.User) error) error {
+	return u.UserStorage.UpdateUserRoles(
+		ctx, user.UserID,
+		func(roles []model.UserRole, roleExpiresAt map[model.UserRole]time.Time) (
+			[]model.UserRole,
+			map[model.UserRole]time.Time,
+			error,
+		) {
+			user.Roles = roles
+			user.RoleExpiresAt = roleExpiresAt
+			if err := update(user); err != nil {
+				return nil, nil, err
+			}
+			return user.Roles, user.RoleExpiresAt, nil
+		},
+	)
+}
+
+// getRoleLimit returns the largest limit of the user roles, defaultLimit is used if no role sets the limit.
+func getRoleLimit(user model.User, roleLimits map[model.UserRole]int, defaultLimit int) int {
+	limit := 0
+	for _, role := range user.Roles {
+		limit = max(limit, roleLimits[role])
+	}
+	if limit == 0 {
+		return defaultLimit
+	}
+	return limit
+}
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"context"
+	"github.com/iamvkosarev/ai-telegram-bot/config"
+	"github.com/iamvkosarev/ai-telegram-bot/internal/model"
+	"slices"
+	"testing"
+)
+
+func TestUserUsecaseReconcileConfiguredRolesRevokesRemovedUsers(t *testing.T) {
+	const (
+		adminTelegramID   = 1
+		premiumTelegramID = 2
+		grantedTelegramID = 3
+	)
+	ctx := context.Background()
+	users := newFakeUserStorage()
+	roles := []config.Role{{Role: "default"}, {Role: "admin"}, {Role: "premium"}}
+
+	userUsecase := NewUserUsecase(
+		UserUsecaseDeps{UserStorage: users},
+		config.Telegram{
+			AdminTelegramIDList:   []int64{adminTelegramID},
+			PremiumTelegramIDList: []int64{premiumTelegramID},
+		},
+		roles,
+	)
+	if err := userUsecase.ReconcileConfiguredRoles(ctx); err != nil {
+		t.Fatalf("failed to reconcile configured roles: %v", err)
+	}
+	if _, err := userUsecase.GrantRole(ctx, grantedTelegramID, model.UserRoleAdmin); err != nil {
+		t.Fatalf("failed to grant admin role: %v", err)
+	}
+
+	// The admin is removed from ADMIN_TELEGRAM_ID_LIST before the next start.
+	userUsecase = NewUserUsecase(
+		UserUsecaseDeps{UserStorage: users},
+		config.Telegram{PremiumTelegramIDList: []int64{premiumTelegramID}},
+		roles,
+	)
+	if err := userUsecase.ReconcileConfiguredRoles(ctx); err != nil {
+		t.Fatalf("failed to reconcile configured roles: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		telegramID int64
+		role       model.UserRole
+		want       bool
+	}{
+		{name: "removed admin loses admin role", telegramID: adminTelegramID, role: model.UserRoleAdmin},
+		{name: "removed admin stays default", telegramID: adminTelegramID, role: model.UserRoleDefault, want: true},
+		{name: "listed premium keeps role", telegramID: premiumTelegramID, role: model.UserRolePremium, want: true},
+		{name: "granted admin keeps role", telegramID: grantedTelegramID, role: model.UserRoleAdmin, want: true},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				user := users.getTelegramUser(t, test.telegramID)
+				if got := slices.Contains(user.Roles, test.role); got != test.want {
+					t.Errorf("user %v has %s role = %v, want %v", test.telegramID, test.role, got, test.want)
+				}
+			},
+		)
+	}
+}
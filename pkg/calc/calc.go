@@ -0,0 +1,245 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrSyntax           = errors.New("syntax error")
+	ErrDivisionByZero   = errors.New("division by zero")
+	ErrUnknownFunction  = errors.New("unknown function")
+	ErrUnknownConstant  = errors.New("unknown constant")
+	ErrExpressionTooBig = errors.New("expression is too big")
+)
+
+const maxExpressionLength = 1000
+
+var functions = map[string]func(float64) float64{
+	"sqrt":  math.Sqrt,
+	"abs":   math.Abs,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"asin":  math.Asin,
+	"acos":  math.Acos,
+	"atan":  math.Atan,
+	"ln":    math.Log,
+	"log":   math.Log10,
+	"log2":  math.Log2,
+	"exp":   math.Exp,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+}
+
+var constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// Eval evaluates an arithmetic expression. It supports +, -, *, /, % (remainder), ^ (power),
+// parentheses, the pi and e constants and common functions like sqrt, sin or round.
+func Eval(expression string) (float64, error) {
+	if len(expression) > maxExpressionLength {
+		return 0, ErrExpressionTooBig
+	}
+	p := parser{input: []rune(strings.ToLower(expression))}
+	result, err := p.parseExpression()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return 0, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, p.input[p.pos], p.pos)
+	}
+	return result, nil
+}
+
+type parser struct {
+	input []rune
+	pos   int
+}
+
+// parseExpression parses terms joined with + and -.
+func (p *parser) parseExpression() (float64, error) {
+	result, err := p.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			term, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			result += term
+		case '-':
+			p.pos++
+			term, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			result -= term
+		default:
+			return result, nil
+		}
+	}
+}
+
+// parseTerm parses factors joined with *, / and %.
+func (p *parser) parseTerm() (float64, error) {
+	result, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		operator := p.peek()
+		if operator != '*' && operator != '/' && operator != '%' {
+			return result, nil
+		}
+		p.pos++
+		factor, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case '*':
+			result *= factor
+		case '/':
+			if factor == 0 {
+				return 0, ErrDivisionByZero
+			}
+			result /= factor
+		case '%':
+			if factor == 0 {
+				return 0, ErrDivisionByZero
+			}
+			result = math.Mod(result, factor)
+		}
+	}
+}
+
+func (p *parser) parseUnary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// parsePower parses right associative powers, so 2^3^2 is 2^9.
+func (p *parser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+func (p *parser) parsePrimary() (float64, error) {
+	r := p.peek()
+	switch {
+	case r == '(':
+		p.pos++
+		value, err := p.parseExpression()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("%w: missing closing parenthesis", ErrSyntax)
+		}
+		p.pos++
+		return value, nil
+	case unicode.IsDigit(r) || r == '.':
+		return p.parseNumber()
+	case unicode.IsLetter(r):
+		return p.parseIdentifier()
+	case r == 0:
+		return 0, fmt.Errorf("%w: unexpected end of expression", ErrSyntax)
+	}
+	return 0, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, r, p.pos)
+}
+
+func (p *parser) parseNumber() (float64, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	// Exponent notation, e.g. 1.5e3.
+	if p.pos < len(p.input) && p.input[p.pos] == 'e' {
+		next := p.pos + 1
+		if next < len(p.input) && (p.input[next] == '-' || p.input[next] == '+') {
+			next++
+		}
+		if next < len(p.input) && unicode.IsDigit(p.input[next]) {
+			p.pos = next
+			for p.pos < len(p.input) && unicode.IsDigit(p.input[p.pos]) {
+				p.pos++
+			}
+		}
+	}
+	value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid number %q", ErrSyntax, string(p.input[start:p.pos]))
+	}
+	return value, nil
+}
+
+func (p *parser) parseIdentifier() (float64, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos])) {
+		p.pos++
+	}
+	name := string(p.input[start:p.pos])
+	if p.peek() == '(' {
+		function, ok := functions[name]
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownFunction, name)
+		}
+		argument, err := p.parsePrimary()
+		if err != nil {
+			return 0, err
+		}
+		return function(argument), nil
+	}
+	value, ok := constants[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownConstant, name)
+	}
+	return value, nil
+}
+
+// peek skips spaces and returns the next rune or 0 at the end of input.
+func (p *parser) peek() rune {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
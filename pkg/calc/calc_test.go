@@ -0,0 +1,69 @@
+package calc
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{expression: "1 + 2", want: 3},
+		{expression: "2 + 3 * 4", want: 14},
+		{expression: "(2 + 3) * 4", want: 20},
+		{expression: "10 - 4 - 3", want: 3},
+		{expression: "24 / 4 / 2", want: 3},
+		{expression: "7 % 4", want: 3},
+		{expression: "2 ^ 3 ^ 2", want: 512},
+		{expression: "2 * 3 ^ 2", want: 18},
+		{expression: "-3 + 5", want: 2},
+		{expression: "-2 ^ 2", want: -4},
+		{expression: "2 ^ -1", want: 0.5},
+		{expression: "--3", want: 3},
+		{expression: "4 * -(1 + 1)", want: -8},
+		{expression: "+5", want: 5},
+		{expression: "1.5e3 + .5", want: 1500.5},
+		{expression: "sqrt(16) + abs(-2)", want: 6},
+		{expression: "round(2.5) * floor(1.9)", want: 3},
+		{expression: "2 * PI", want: 2 * math.Pi},
+		{expression: "ln(e)", want: 1},
+	}
+	for _, test := range tests {
+		got, err := Eval(test.expression)
+		if err != nil {
+			t.Errorf("Eval(%q) error = %v", test.expression, err)
+			continue
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("Eval(%q) = %v, want %v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    error
+	}{
+		{expression: "1 / 0", wantErr: ErrDivisionByZero},
+		{expression: "1 / (2 - 2)", wantErr: ErrDivisionByZero},
+		{expression: "5 % 0", wantErr: ErrDivisionByZero},
+		{expression: "", wantErr: ErrSyntax},
+		{expression: "1 +", wantErr: ErrSyntax},
+		{expression: "(1 + 2", wantErr: ErrSyntax},
+		{expression: "1 + 2)", wantErr: ErrSyntax},
+		{expression: "2 $ 3", wantErr: ErrSyntax},
+		{expression: "1..2", wantErr: ErrSyntax},
+		{expression: "foo(1)", wantErr: ErrUnknownFunction},
+		{expression: "2 * tau", wantErr: ErrUnknownConstant},
+		{expression: string(make([]byte, maxExpressionLength+1)), wantErr: ErrExpressionTooBig},
+	}
+	for _, test := range tests {
+		if _, err := Eval(test.expression); !errors.Is(err, test.wantErr) {
+			t.Errorf("Eval(%q) error = %v, want %v", test.expression, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnsupportedDocument = errors.New("unsupported document type")
+	ErrEmptyDocument       = errors.New("document has no text")
+)
+
+var textExtensions = map[string]struct{}{
+	".txt": {}, ".md": {}, ".markdown": {}, ".rst": {}, ".csv": {}, ".tsv": {}, ".log": {},
+	".json": {}, ".yaml": {}, ".yml": {}, ".toml": {}, ".ini": {}, ".xml": {}, ".html": {}, ".htm": {},
+	".go": {}, ".mod": {}, ".py": {}, ".js": {}, ".ts": {}, ".jsx": {}, ".tsx": {}, ".java": {}, ".kt": {},
+	".c": {}, ".h": {}, ".cpp": {}, ".hpp": {}, ".cs": {}, ".rs": {}, ".rb": {}, ".php": {}, ".swift": {},
+	".sh": {}, ".bash": {}, ".sql": {}, ".proto": {}, ".css": {}, ".scss": {}, ".lua": {}, ".dart": {},
+}
+
+// ExtractText returns the plain text of a document. Plain text, markdown and source files are returned
+// as is, PDF files are converted to text page by page.
+func ExtractText(fileName, mimeType string, data []byte) (string, error) {
+	var text string
+	switch {
+	case isPDF(fileName, mimeType):
+		var err error
+		if text, err = extractPDFText(data); err != nil {
+			return "", err
+		}
+	case isText(fileName, mimeType):
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("%w: file is not valid UTF-8", ErrUnsupportedDocument)
+		}
+		text = string(data)
+	default:
+		return "", ErrUnsupportedDocument
+	}
+
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if len(text) == 0 {
+		return "", ErrEmptyDocument
+	}
+	return text, nil
+}
+
+func isPDF(fileName, mimeType string) bool {
+	return mimeType == "application/pdf" || strings.EqualFold(filepath.Ext(fileName), ".pdf")
+}
+
+func isText(fileName, mimeType string) bool {
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	_, ok := textExtensions[strings.ToLower(filepath.Ext(fileName))]
+	return ok
+}
+
+func extractPDFText(data []byte) (string, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open pdf: %w", err)
+	}
+	plainText, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("failed to get pdf text: %w", err)
+	}
+	text, err := io.ReadAll(plainText)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pdf text: %w", err)
+	}
+	return string(text), nil
+}
@@ -0,0 +1,62 @@
+package openai_tools
+
+import (
+	"strings"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+const fallbackEncoding = "cl100k_base"
+
+// ChunkText splits text into parts of at most maxTokens tokens. Parts are cut on line boundaries when
+// possible, so paragraphs and code blocks stay readable.
+func ChunkText(text string, model string, maxTokens int) ([]string, error) {
+	tkm, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		if tkm, err = tiktoken.GetEncoding(fallbackEncoding); err != nil {
+			return nil, err
+		}
+	}
+	countTokens := func(s string) int {
+		return len(tkm.Encode(s, nil, nil))
+	}
+
+	chunks := make([]string, 0)
+	current := strings.Builder{}
+	currentTokens := 0
+	flush := func() {
+		if current.Len() != 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentTokens = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineTokens := countTokens(line)
+		if lineTokens > maxTokens {
+			flush()
+			chunks = append(chunks, splitLongLine(line, lineTokens, maxTokens)...)
+			continue
+		}
+		if currentTokens+lineTokens > maxTokens {
+			flush()
+		}
+		current.WriteString(line)
+		currentTokens += lineTokens
+	}
+	flush()
+	return chunks, nil
+}
+
+func splitLongLine(line string, lineTokens, maxTokens int) []string {
+	runes := []rune(line)
+	partsCount := (lineTokens + maxTokens - 1) / maxTokens
+	partLength := (len(runes) + partsCount - 1) / partsCount
+	parts := make([]string, 0, partsCount)
+	for start := 0; start < len(runes); start += partLength {
+		end := min(start+partLength, len(runes))
+		parts = append(parts, string(runes[start:end]))
+	}
+	return parts
+}
@@ -0,0 +1,163 @@
+package readability
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"strings"
+)
+
+// skippedElements never contain the page content.
+var skippedElements = map[atom.Atom]struct{}{
+	atom.Script: {}, atom.Style: {}, atom.Noscript: {}, atom.Template: {}, atom.Svg: {}, atom.Canvas: {},
+	atom.Iframe: {}, atom.Head: {}, atom.Nav: {}, atom.Header: {}, atom.Footer: {}, atom.Aside: {},
+	atom.Form: {}, atom.Button: {}, atom.Select: {}, atom.Object: {}, atom.Embed: {},
+}
+
+// blockElements are separated with new lines in the result text.
+var blockElements = map[atom.Atom]struct{}{
+	atom.P: {}, atom.Div: {}, atom.Section: {}, atom.Article: {}, atom.Main: {}, atom.Br: {}, atom.Hr: {},
+	atom.H1: {}, atom.H2: {}, atom.H3: {}, atom.H4: {}, atom.H5: {}, atom.H6: {}, atom.Li: {}, atom.Ul: {},
+	atom.Ol: {}, atom.Pre: {}, atom.Blockquote: {}, atom.Table: {}, atom.Tr: {}, atom.Dt: {}, atom.Dd: {},
+	atom.Figcaption: {},
+}
+
+type Page struct {
+	Title string
+	Text  string
+}
+
+// Extract returns the title and the readable text of an HTML page. Scripts, styles, navigation and
+// other page chrome are dropped, and when the page has an article or main element only it is used.
+func Extract(r io.Reader) (Page, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to parse html: %w", err)
+	}
+
+	page := Page{
+		Title: strings.TrimSpace(collapseSpaces(textOf(findFirst(doc, atom.Title)))),
+	}
+	content := findFirst(doc, atom.Article)
+	if content == nil {
+		content = findFirst(doc, atom.Main)
+	}
+	if content == nil {
+		content = findFirst(doc, atom.Body)
+	}
+	if content == nil {
+		content = doc
+	}
+
+	builder := strings.Builder{}
+	writeText(&builder, content)
+	page.Text = normalizeLines(builder.String())
+	return page, nil
+}
+
+func findFirst(node *html.Node, element atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == element {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findFirst(child, element); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textOf(node *html.Node) string {
+	if node == nil {
+		return ""
+	}
+	builder := strings.Builder{}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode {
+			builder.WriteString(child.Data)
+		}
+	}
+	return builder.String()
+}
+
+func writeText(builder *strings.Builder, node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		builder.WriteString(collapseSpaces(node.Data))
+		return
+	case html.ElementNode:
+		if _, ok := skippedElements[node.DataAtom]; ok {
+			return
+		}
+		if isHidden(node) {
+			return
+		}
+	}
+
+	_, isBlock := blockElements[node.DataAtom]
+	if isBlock {
+		builder.WriteString("\n")
+	}
+	if node.DataAtom == atom.Li {
+		builder.WriteString("- ")
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		writeText(builder, child)
+	}
+	if isBlock {
+		builder.WriteString("\n")
+	}
+}
+
+// isHidden reports whether any attribute of the node hides it, attributes which don't hide it are skipped.
+func isHidden(node *html.Node) bool {
+	for _, attr := range node.Attr {
+		switch attr.Key {
+		case "hidden":
+			return true
+		case "aria-hidden":
+			if attr.Val == "true" {
+				return true
+			}
+		case "style":
+			style := strings.ReplaceAll(strings.ToLower(attr.Val), " ", "")
+			if strings.Contains(style, "display:none") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func collapseSpaces(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if len(s) != 0 {
+			return " "
+		}
+		return ""
+	}
+	result := strings.Join(fields, " ")
+	// Keep the spaces around inline text, so "a <b>b</b>" stays "a b".
+	if strings.TrimLeft(s, " \t\n\r") != s {
+		result = " " + result
+	}
+	if strings.TrimRight(s, " \t\n\r") != s {
+		result += " "
+	}
+	return result
+}
+
+func normalizeLines(s string) string {
+	lines := strings.Split(s, "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line == "-" {
+			continue
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
@@ -0,0 +1,53 @@
+package readability
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractSkipsHiddenElements(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "visible",
+			html: `<div style="color:red" aria-hidden="false">visible</div>`,
+			want: "visible",
+		},
+		{
+			name: "hidden",
+			html: `<div hidden>secret</div>visible`,
+			want: "visible",
+		},
+		{
+			name: "hidden after style",
+			html: `<div style="color:red" hidden>secret</div>visible`,
+			want: "visible",
+		},
+		{
+			name: "aria hidden after style",
+			html: `<div style="color:red" aria-hidden="true">secret</div>visible`,
+			want: "visible",
+		},
+		{
+			name: "display none after aria hidden",
+			html: `<div aria-hidden="false" style="display: none">secret</div>visible`,
+			want: "visible",
+		},
+	}
+	for _, test := range tests {
+		t.Run(
+			test.name, func(t *testing.T) {
+				page, err := Extract(strings.NewReader("<html><body>" + test.html + "</body></html>"))
+				if err != nil {
+					t.Fatalf("Extract() error = %v", err)
+				}
+				if page.Text != test.want {
+					t.Errorf("Extract() text = %q, want %q", page.Text, test.want)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,143 @@
+package units
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknownUnit      = errors.New("unknown unit")
+	ErrIncompatibleUnit = errors.New("incompatible units")
+)
+
+type Category string
+
+const (
+	CategoryLength      = Category("length")
+	CategoryMass        = Category("mass")
+	CategoryVolume      = Category("volume")
+	CategoryArea        = Category("area")
+	CategoryTime        = Category("time")
+	CategorySpeed       = Category("speed")
+	CategoryData        = Category("data")
+	CategoryPressure    = Category("pressure")
+	CategoryEnergy      = Category("energy")
+	CategoryTemperature = Category("temperature")
+)
+
+type unit struct {
+	category Category
+	// factor converts the unit to the base unit of its category. Temperature units use offset too.
+	factor float64
+	offset float64
+}
+
+var units = map[string]unit{
+	// Length, base is meter.
+	"mm": {CategoryLength, 0.001, 0}, "cm": {CategoryLength, 0.01, 0}, "m": {CategoryLength, 1, 0},
+	"km": {CategoryLength, 1000, 0}, "in": {CategoryLength, 0.0254, 0}, "ft": {CategoryLength, 0.3048, 0},
+	"yd": {CategoryLength, 0.9144, 0}, "mi": {CategoryLength, 1609.344, 0}, "nmi": {CategoryLength, 1852, 0},
+	// Mass, base is kilogram.
+	"mg": {CategoryMass, 1e-6, 0}, "g": {CategoryMass, 0.001, 0}, "kg": {CategoryMass, 1, 0},
+	"t": {CategoryMass, 1000, 0}, "oz": {CategoryMass, 0.028349523125, 0}, "lb": {CategoryMass, 0.45359237, 0},
+	"st": {CategoryMass, 6.35029318, 0},
+	// Volume, base is liter.
+	"ml": {CategoryVolume, 0.001, 0}, "l": {CategoryVolume, 1, 0}, "m3": {CategoryVolume, 1000, 0},
+	"tsp": {CategoryVolume, 0.00492892159375, 0}, "tbsp": {CategoryVolume, 0.01478676478125, 0},
+	"floz": {CategoryVolume, 0.0295735295625, 0}, "cup": {CategoryVolume, 0.2365882365, 0},
+	"pt": {CategoryVolume, 0.473176473, 0}, "qt": {CategoryVolume, 0.946352946, 0},
+	"gal": {CategoryVolume, 3.785411784, 0},
+	// Area, base is square meter.
+	"mm2": {CategoryArea, 1e-6, 0}, "cm2": {CategoryArea, 1e-4, 0}, "m2": {CategoryArea, 1, 0},
+	"km2": {CategoryArea, 1e6, 0}, "ha": {CategoryArea, 1e4, 0}, "acre": {CategoryArea, 4046.8564224, 0},
+	"ft2": {CategoryArea, 0.09290304, 0}, "in2": {CategoryArea, 0.00064516, 0},
+	"mi2": {CategoryArea, 2589988.110336, 0},
+	// Time, base is second.
+	"ms": {CategoryTime, 0.001, 0}, "s": {CategoryTime, 1, 0}, "min": {CategoryTime, 60, 0},
+	"h": {CategoryTime, 3600, 0}, "day": {CategoryTime, 86400, 0}, "week": {CategoryTime, 604800, 0},
+	"year": {CategoryTime, 31557600, 0},
+	// Speed, base is meter per second.
+	"m/s": {CategorySpeed, 1, 0}, "km/h": {CategorySpeed, 1000.0 / 3600, 0},
+	"mph": {CategorySpeed, 0.44704, 0}, "kn": {CategorySpeed, 1852.0 / 3600, 0},
+	"ft/s": {CategorySpeed, 0.3048, 0},
+	// Data, base is byte.
+	"bit": {CategoryData, 0.125, 0}, "b": {CategoryData, 1, 0}, "kb": {CategoryData, 1e3, 0},
+	"mb": {CategoryData, 1e6, 0}, "gb": {CategoryData, 1e9, 0}, "tb": {CategoryData, 1e12, 0},
+	"kib": {CategoryData, 1 << 10, 0}, "mib": {CategoryData, 1 << 20, 0}, "gib": {CategoryData, 1 << 30, 0},
+	"tib": {CategoryData, 1 << 40, 0},
+	// Pressure, base is pascal.
+	"pa": {CategoryPressure, 1, 0}, "kpa": {CategoryPressure, 1000, 0}, "bar": {CategoryPressure, 1e5, 0},
+	"atm": {CategoryPressure, 101325, 0}, "psi": {CategoryPressure, 6894.757293168, 0},
+	"mmhg": {CategoryPressure, 133.322387415, 0},
+	// Energy, base is joule.
+	"j": {CategoryEnergy, 1, 0}, "kj": {CategoryEnergy, 1000, 0}, "cal": {CategoryEnergy, 4.184, 0},
+	"kcal": {CategoryEnergy, 4184, 0}, "wh": {CategoryEnergy, 3600, 0}, "kwh": {CategoryEnergy, 3.6e6, 0},
+	// Temperature, base is kelvin.
+	"k": {CategoryTemperature, 1, 0}, "c": {CategoryTemperature, 1, 273.15},
+	"f": {CategoryTemperature, 5.0 / 9, 459.67},
+}
+
+var aliases = map[string]string{
+	"millimeter": "mm", "centimeter": "cm", "meter": "m", "metre": "m", "kilometer": "km", "inch": "in",
+	"foot": "ft", "feet": "ft", "yard": "yd", "mile": "mi", "gram": "g", "kilogram": "kg", "ton": "t",
+	"tonne": "t", "ounce": "oz", "pound": "lb", "lbs": "lb", "stone": "st", "milliliter": "ml", "liter": "l",
+	"litre": "l", "gallon": "gal", "pint": "pt", "quart": "qt", "hectare": "ha", "second": "s", "sec": "s",
+	"minute": "min", "hour": "h", "hr": "h", "days": "day", "d": "day", "weeks": "week", "years": "year",
+	"kph": "km/h", "kmh": "km/h", "knot": "kn", "byte": "b", "bytes": "b", "bits": "bit", "kelvin": "k",
+	"celsius": "c", "°c": "c", "fahrenheit": "f", "°f": "f", "joule": "j", "calorie": "cal",
+}
+
+// Convert converts the value between units of the same category, e.g. km to mi or c to f.
+func Convert(value float64, from, to string) (float64, error) {
+	fromUnit, err := lookup(from)
+	if err != nil {
+		return 0, err
+	}
+	toUnit, err := lookup(to)
+	if err != nil {
+		return 0, err
+	}
+	if fromUnit.category != toUnit.category {
+		return 0, fmt.Errorf(
+			"%w: %s is %s, %s is %s", ErrIncompatibleUnit, from, fromUnit.category, to, toUnit.category,
+		)
+	}
+	base := (value + fromUnit.offset) * fromUnit.factor
+	return base/toUnit.factor - toUnit.offset, nil
+}
+
+// Names returns all supported unit names sorted alphabetically.
+func Names() []string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (unit, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if u, ok := findUnit(name); ok {
+		return u, nil
+	}
+	// Plural forms like "meters", "mins" or "inches".
+	for _, suffix := range []string{"s", "es"} {
+		if singular, ok := strings.CutSuffix(name, suffix); ok && len(singular) != 0 {
+			if u, ok := findUnit(singular); ok {
+				return u, nil
+			}
+		}
+	}
+	return unit{}, fmt.Errorf("%w: %s", ErrUnknownUnit, name)
+}
+
+func findUnit(name string) (unit, bool) {
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	u, ok := units[name]
+	return u, ok
+}
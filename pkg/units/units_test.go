@@ -0,0 +1,60 @@
+package units
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value float64
+		from  string
+		to    string
+		want  float64
+	}{
+		{value: 1, from: "km", to: "m", want: 1000},
+		{value: 1, from: "mi", to: "km", want: 1.609344},
+		{value: 100, from: "c", to: "f", want: 212},
+		{value: 32, from: "°F", to: "celsius", want: 0},
+		{value: 0, from: "k", to: "c", want: -273.15},
+		{value: 1, from: "GiB", to: "MiB", want: 1024},
+		{value: 90, from: "mins", to: "hours", want: 1.5},
+		{value: 2, from: "days", to: "h", want: 48},
+		{value: 12, from: "inches", to: "feet", want: 1},
+		{value: 3, from: "meters", to: "cm", want: 300},
+		{value: 2, from: "kgs", to: "grams", want: 2000},
+		{value: 500, from: "ms", to: "seconds", want: 0.5},
+		{value: 1, from: "weeks", to: "days", want: 7},
+	}
+	for _, test := range tests {
+		got, err := Convert(test.value, test.from, test.to)
+		if err != nil {
+			t.Errorf("Convert(%v, %q, %q) error = %v", test.value, test.from, test.to, err)
+			continue
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("Convert(%v, %q, %q) = %v, want %v", test.value, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		wantErr error
+	}{
+		{from: "parsec", to: "km", wantErr: ErrUnknownUnit},
+		{from: "km", to: "furlongs", wantErr: ErrUnknownUnit},
+		{from: "es", to: "m", wantErr: ErrUnknownUnit},
+		{from: "", to: "m", wantErr: ErrUnknownUnit},
+		{from: "km", to: "kg", wantErr: ErrIncompatibleUnit},
+		{from: "mins", to: "meters", wantErr: ErrIncompatibleUnit},
+	}
+	for _, test := range tests {
+		if _, err := Convert(1, test.from, test.to); !errors.Is(err, test.wantErr) {
+			t.Errorf("Convert(1, %q, %q) error = %v, want %v", test.from, test.to, err, test.wantErr)
+		}
+	}
+}